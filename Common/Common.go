@@ -9,6 +9,12 @@ type HttpBaseConfig struct {
 	LocalIP   net.IP        `long:"local-ip" description:"Local IP address to bind to"`
 	ReuseConn bool          `long:"reuse-conn" description:"Reuse connections across queries to the same server (default: true)" default:"true"`
 	Timeout   time.Duration `long:"timeout" description:"Query timeout" default:"10s"`
+	// Download timeouts, zero falls back to Timeout except for RequestTimeout where it means no limit
+	ConnectTimeout        time.Duration `long:"connect-timeout" description:"TCP connect timeout, defaults to timeout"`
+	TLSHandshakeTimeout   time.Duration `long:"tls-handshake-timeout" description:"TLS handshake timeout, defaults to timeout"`
+	ResponseHeaderTimeout time.Duration `long:"response-header-timeout" description:"Timeout for the response headers after the request is written, defaults to timeout"`
+	IdleReadTimeout       time.Duration `long:"idle-read-timeout" description:"Abort a response body that receives nothing for this long, defaults to timeout"`
+	RequestTimeout        time.Duration `long:"request-timeout" description:"Timeout for a whole request including the body transfer, 0 means no limit"`
 	// HTTP
 	HTTPUserAgent string `long:"http-user-agent" description:"HTTP user agent" default:""`
 	HTTPMethod    string `long:"http-method" description:"HTTP method" default:"GET"`
@@ -46,6 +52,36 @@ func WithTimeout(timeout time.Duration) HttpBaseConfigOption {
 	}
 }
 
+func WithConnectTimeout(timeout time.Duration) HttpBaseConfigOption {
+	return func(config *HttpBaseConfig) {
+		config.ConnectTimeout = timeout
+	}
+}
+
+func WithTLSHandshakeTimeout(timeout time.Duration) HttpBaseConfigOption {
+	return func(config *HttpBaseConfig) {
+		config.TLSHandshakeTimeout = timeout
+	}
+}
+
+func WithResponseHeaderTimeout(timeout time.Duration) HttpBaseConfigOption {
+	return func(config *HttpBaseConfig) {
+		config.ResponseHeaderTimeout = timeout
+	}
+}
+
+func WithIdleReadTimeout(timeout time.Duration) HttpBaseConfigOption {
+	return func(config *HttpBaseConfig) {
+		config.IdleReadTimeout = timeout
+	}
+}
+
+func WithRequestTimeout(timeout time.Duration) HttpBaseConfigOption {
+	return func(config *HttpBaseConfig) {
+		config.RequestTimeout = timeout
+	}
+}
+
 func WithHTTPUserAgent(userAgent string) HttpBaseConfigOption {
 	return func(config *HttpBaseConfig) {
 		config.HTTPUserAgent = userAgent
@@ -81,3 +117,11 @@ func NewHttpBaseConfig(opts ...HttpBaseConfigOption) *HttpBaseConfig {
 	}
 	return httpBaseConfig
 }
+
+// OrTimeout returns timeout, or Timeout when timeout is zero
+func (httpBaseConfig *HttpBaseConfig) OrTimeout(timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	return httpBaseConfig.Timeout
+}
@@ -0,0 +1,253 @@
+package Common
+
+import (
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is the prefix of the environment variables that set a flag, -http-user-agent is read from HTTPBENCHMARK_HTTP_USER_AGENT
+const EnvPrefix = "HTTPBENCHMARK_"
+
+// fieldValue is a flag.Value that sets a struct field from its string form and keeps the last string it was set to
+type fieldValue struct {
+	field reflect.Value
+	raw   string
+}
+
+// String returns an empty string for a zero field, so that the usage only shows meaningful defaults
+func (value *fieldValue) String() string {
+	if !value.field.IsValid() || value.field.IsZero() {
+		return ""
+	}
+	switch v := value.field.Interface().(type) {
+	case []string:
+		return strings.Join(v, ",")
+	case net.IP:
+		if v == nil {
+			return ""
+		}
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (value *fieldValue) Set(raw string) error {
+	if err := setField(value.field, raw); err != nil {
+		return err
+	}
+	value.raw = raw
+	return nil
+}
+
+// IsBoolFlag lets boolean fields be set with a bare -flag
+func (value *fieldValue) IsBoolFlag() bool {
+	return value.field.Kind() == reflect.Bool
+}
+
+// setField parses raw into field, lists are comma separated
+func setField(field reflect.Value, raw string) error {
+	switch field.Interface().(type) {
+	case time.Duration:
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+		return nil
+	case net.IP:
+		if raw == "" {
+			field.Set(reflect.ValueOf(net.IP(nil)))
+			return nil
+		}
+		ip := net.ParseIP(raw)
+		if ip == nil {
+			return fmt.Errorf("invalid IP address %q", raw)
+		}
+		field.Set(reflect.ValueOf(ip))
+		return nil
+	case []string:
+		var list []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(raw, 0, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(raw, 0, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(u)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+// taggedFields returns the fields of the struct target points to by their long tag with prefix prepended.
+// Embedded structs are flattened unless skipEmbedded is set.
+func taggedFields(target any, prefix string, skipEmbedded bool) (map[string]reflect.StructField, map[string]reflect.Value) {
+	structFields := make(map[string]reflect.StructField)
+	values := make(map[string]reflect.Value)
+	var walk func(value reflect.Value)
+	walk = func(value reflect.Value) {
+		for i := 0; i < value.NumField(); i++ {
+			structField := value.Type().Field(i)
+			if structField.Anonymous && structField.Type.Kind() == reflect.Struct {
+				if !skipEmbedded {
+					walk(value.Field(i))
+				}
+				continue
+			}
+			long := structField.Tag.Get("long")
+			if long == "" || !structField.IsExported() {
+				continue
+			}
+			structFields[prefix+long] = structField
+			values[prefix+long] = value.Field(i)
+		}
+	}
+	walk(reflect.ValueOf(target).Elem())
+	return structFields, values
+}
+
+// RegisterFlags defines a flag for every field of target with a long tag, named prefix plus the tag and described
+// by the description tag. Fields keep their current values as defaults, names that are already defined are skipped.
+// Embedded structs are skipped when skipEmbedded is set, so that a shared HttpBaseConfig is registered only once.
+func RegisterFlags(flagSet *flag.FlagSet, target any, prefix string, skipEmbedded bool) {
+	structFields, values := taggedFields(target, prefix, skipEmbedded)
+	for name, structField := range structFields {
+		if flagSet.Lookup(name) != nil {
+			continue
+		}
+		flagSet.Var(&fieldValue{field: values[name]}, name, structField.Tag.Get("description"))
+	}
+}
+
+// ApplyValues sets the fields of target whose prefixed long name is in values
+func ApplyValues(target any, prefix string, values map[string]string) error {
+	_, fieldValues := taggedFields(target, prefix, true)
+	for name, raw := range values {
+		field, ok := fieldValues[name]
+		if !ok {
+			continue
+		}
+		if err := setField(field, raw); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ReadConfigFile reads a YAML file of flag names and values, lists are joined with commas
+func ReadConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	var document map[string]any
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	values := make(map[string]string, len(document))
+	for name, value := range document {
+		switch v := value.(type) {
+		case nil:
+			values[name] = ""
+		case []any:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[name] = strings.Join(items, ",")
+		case map[string]any:
+			return nil, fmt.Errorf("error parsing config file %s: %s must be a value or a list", path, name)
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+// envName returns the environment variable of a flag, the env tag of its field wins over the EnvPrefix form
+func envName(name string, envTags map[string]string) string {
+	if env, ok := envTags[name]; ok {
+		return env
+	}
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// EnvTags collects the env tags of the fields of target by their prefixed long name
+func EnvTags(target any, prefix string, skipEmbedded bool) map[string]string {
+	envTags := make(map[string]string)
+	structFields, _ := taggedFields(target, prefix, skipEmbedded)
+	for name, structField := range structFields {
+		if env := structField.Tag.Get("env"); env != "" {
+			envTags[name] = env
+		}
+	}
+	return envTags
+}
+
+// LoadConfig fills the flags of flagSet that were not given on the command line, from the environment first
+// and then from fileValues. It returns every flag that ended up set and its value, whatever the source.
+func LoadConfig(flagSet *flag.FlagSet, fileValues map[string]string, envTags map[string]string) (map[string]string, error) {
+	setValues := make(map[string]string)
+	flagSet.Visit(func(f *flag.Flag) {
+		if value, ok := f.Value.(*fieldValue); ok {
+			setValues[f.Name] = value.raw
+		} else {
+			setValues[f.Name] = f.Value.String()
+		}
+	})
+	for name := range fileValues {
+		if flagSet.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown setting %q in config file", name)
+		}
+	}
+	var err error
+	flagSet.VisitAll(func(f *flag.Flag) {
+		if _, ok := setValues[f.Name]; ok || err != nil {
+			return
+		}
+		raw, ok := os.LookupEnv(envName(f.Name, envTags))
+		if !ok {
+			raw, ok = fileValues[f.Name]
+		}
+		if !ok {
+			return
+		}
+		if err = flagSet.Set(f.Name, raw); err != nil {
+			err = fmt.Errorf("invalid value %q for %s: %w", raw, f.Name, err)
+			return
+		}
+		setValues[f.Name] = raw
+	})
+	return setValues, err
+}
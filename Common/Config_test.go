@@ -0,0 +1,72 @@
+package Common
+
+import (
+	"flag"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testDNSConfig struct {
+	HttpBaseConfig
+	Server string   `long:"server" description:"DoH server"`
+	Types  []string `long:"type" description:"RR types"`
+	Buffer uint16   `long:"udp-buffer" description:"UDP size"`
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "profile.yaml")
+	assert.Nil(t, os.WriteFile(configPath, []byte(`
+timeout: 30s
+http-user-agent: from-file
+local-ip: 127.0.0.1
+reuse-conn: false
+tls-next-protos: [h2, http/1.1]
+dns-type: [A, AAAA]
+dns-udp-buffer: 4096
+`), 0644))
+	t.Setenv("HTTPBENCHMARK_HTTP_USER_AGENT", "from-env")
+	t.Setenv("SSLKEYLOGFILE", "keys.log")
+
+	httpBaseConfig := NewHttpBaseConfig()
+	dnsConfig := &testDNSConfig{}
+	flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+	RegisterFlags(flagSet, httpBaseConfig, "", false)
+	RegisterFlags(flagSet, dnsConfig, "dns-", true)
+	assert.Nil(t, flagSet.Lookup("dns-timeout"))
+	assert.Nil(t, flagSet.Parse([]string{"-timeout", "5s", "-dns-server", "https://223.5.5.5/dns-query"}))
+
+	fileValues, err := ReadConfigFile(configPath)
+	assert.Nil(t, err)
+	setValues, err := LoadConfig(flagSet, fileValues, EnvTags(httpBaseConfig, "", false))
+	assert.Nil(t, err)
+
+	assert.Equal(t, 5*time.Second, httpBaseConfig.Timeout)
+	assert.Equal(t, "from-env", httpBaseConfig.HTTPUserAgent)
+	assert.Equal(t, "keys.log", httpBaseConfig.TLSKeyLogFile)
+	assert.True(t, net.ParseIP("127.0.0.1").Equal(httpBaseConfig.LocalIP))
+	assert.False(t, httpBaseConfig.ReuseConn)
+	assert.Equal(t, []string{"h2", "http/1.1"}, httpBaseConfig.TLSNextProtos)
+	assert.Equal(t, "GET", httpBaseConfig.HTTPMethod)
+	assert.Equal(t, "https://223.5.5.5/dns-query", dnsConfig.Server)
+	assert.Equal(t, "A,AAAA", setValues["dns-type"])
+
+	freshDNSConfig := &testDNSConfig{}
+	assert.Nil(t, ApplyValues(freshDNSConfig, "dns-", setValues))
+	assert.Equal(t, []string{"A", "AAAA"}, freshDNSConfig.Types)
+	assert.Equal(t, uint16(4096), freshDNSConfig.Buffer)
+	assert.Equal(t, time.Duration(0), freshDNSConfig.Timeout)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+	RegisterFlags(flagSet, NewHttpBaseConfig(), "", false)
+	assert.Nil(t, flagSet.Parse(nil))
+	_, err := LoadConfig(flagSet, map[string]string{"no-such-flag": "1"}, nil)
+	assert.ErrorContains(t, err, "no-such-flag")
+	_, err = LoadConfig(flagSet, map[string]string{"timeout": "soon"}, nil)
+	assert.ErrorContains(t, err, "timeout")
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"HttpBenchmark/Metrics"
+	"HttpBenchmark/Utils"
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// dashboardTopIPs is the number of remote IPs listed in the terminal view, busiest first
+const dashboardTopIPs = 10
+
+// Dashboard shows the live state of a run, redrawn in place on a terminal and as periodic log lines otherwise
+type Dashboard struct {
+	runStats *RunStats
+	runLimit *RunLimit
+	interval time.Duration
+	writer   io.Writer
+	terminal bool
+}
+
+func NewDashboard(runStats *RunStats, runLimit *RunLimit, interval time.Duration, writer io.Writer) *Dashboard {
+	return &Dashboard{
+		runStats: runStats,
+		runLimit: runLimit,
+		interval: interval,
+		writer:   writer,
+		terminal: isTerminal(writer),
+	}
+}
+
+// isTerminal reports whether writer is a character device, which is a console on both Windows and Unix
+func isTerminal(writer io.Writer) bool {
+	file, ok := writer.(*os.File)
+	if !ok {
+		return false
+	}
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return fileInfo.Mode()&os.ModeCharDevice != 0
+}
+
+// Run refreshes the dashboard every interval until ctx is done
+func (dashboard *Dashboard) Run(ctx context.Context) {
+	ticker := time.NewTicker(dashboard.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			snapshot := dashboard.runStats.Collector().Snapshot()
+			if dashboard.terminal {
+				// Move the cursor home and clear the screen before redrawing
+				_, _ = fmt.Fprint(dashboard.writer, "\033[H\033[2J"+dashboard.Render(snapshot))
+			} else {
+				log.Infoln(dashboard.StatusLine(snapshot))
+			}
+		}
+	}
+}
+
+// StatusLine formats the aggregate state on a single line
+func (dashboard *Dashboard) StatusLine(snapshot Metrics.Snapshot) string {
+	line := fmt.Sprintf("Current speed: %s (%s), Average speed: %s, Total downloaded: %s, Requests: %d, Errors: %d, Active workers: %d, Open connections: %d",
+		Utils.FormatBitRate(snapshot.Total.Rate), Utils.FormatByteRate(snapshot.Total.Rate), Utils.FormatBitRate(snapshot.Total.AverageRate),
+		Utils.FormatBytes(snapshot.Total.Bytes), snapshot.Total.Requests, snapshot.Total.Failures+snapshot.DNSFailures,
+		snapshot.ActiveWorkers, snapshot.OpenConns)
+	if schedule := dashboard.runStats.schedule; schedule != nil {
+		line += fmt.Sprintf(", Backlog: %d", schedule.Backlog())
+	}
+	if stage, concurrency, ok := dashboard.currentStage(); ok {
+		line += fmt.Sprintf(", Stage: %s, Concurrency: %d/%d", stage.Name, dashboard.runStats.loadProfile.Active(), concurrency)
+	}
+	if limiter := dashboard.runStats.bandwidthLimiter; limiter != nil {
+		line += fmt.Sprintf(", Throttled: %d waiting, %s in total", limiter.Waiting(), limiter.Throttled().Round(time.Millisecond))
+	}
+	if snapshot.Total.UploadedBytes > 0 {
+		line += fmt.Sprintf(", Upload speed: %s, Total uploaded: %s", Utils.FormatBitRate(snapshot.Total.UploadRate), Utils.FormatBytes(snapshot.Total.UploadedBytes))
+	}
+	if quicStats := dashboard.runStats.quicStats; quicStats != nil {
+		line += fmt.Sprintf(", QUIC: %d handshakes (%d 0-RTT)", quicStats.Handshakes(), quicStats.ZeroRTT())
+	}
+	if progress, ok := dashboard.runLimit.Progress(); ok {
+		line += fmt.Sprintf(", Run limit: %.1f%%", progress*100)
+	}
+	return line
+}
+
+// Render formats the full terminal view
+func (dashboard *Dashboard) Render(snapshot Metrics.Snapshot) string {
+	var builder strings.Builder
+	dnsServer, clientSubnet := dashboard.runStats.DNSTarget()
+	_, _ = fmt.Fprintf(&builder, "HttpBenchmark  elapsed %s\n", dashboard.runStats.Elapsed().Round(time.Second))
+	_, _ = fmt.Fprintf(&builder, "DoH server:    %s\nClient subnet: %s\n", dnsServer, clientSubnet)
+	_, _ = fmt.Fprintf(&builder, "Speed:         %s (%s), average %s\n", Utils.FormatBitRate(snapshot.Total.Rate),
+		Utils.FormatByteRate(snapshot.Total.Rate), Utils.FormatBitRate(snapshot.Total.AverageRate))
+	_, _ = fmt.Fprintf(&builder, "Downloaded:    %s in %d requests\n", Utils.FormatBytes(snapshot.Total.Bytes), snapshot.Total.Requests)
+	if snapshot.Total.UploadedBytes > 0 {
+		_, _ = fmt.Fprintf(&builder, "Upload speed:  %s (%s), average %s\n", Utils.FormatBitRate(snapshot.Total.UploadRate),
+			Utils.FormatByteRate(snapshot.Total.UploadRate), Utils.FormatBitRate(snapshot.Total.AverageUploadRate))
+		_, _ = fmt.Fprintf(&builder, "Uploaded:      %s\n", Utils.FormatBytes(snapshot.Total.UploadedBytes))
+	}
+	_, _ = fmt.Fprintf(&builder, "Errors:        %d download, %d DNS of %d queries\n", snapshot.Total.Failures, snapshot.DNSFailures, snapshot.DNSQueries)
+	_, _ = fmt.Fprintf(&builder, "Workers:       %d active, %d open connections", snapshot.ActiveWorkers, snapshot.OpenConns)
+	if failed := snapshot.WorkerPanics + snapshot.WorkerErrors; failed > 0 {
+		_, _ = fmt.Fprintf(&builder, ", %d failed, %d restarted", failed, snapshot.WorkerRestarts)
+	}
+	if snapshot.Total.Requests > 0 {
+		_, _ = fmt.Fprintf(&builder, ", %.1f%% of the requests reused one", float64(snapshot.Total.ReusedConns)/float64(snapshot.Total.Requests)*100)
+	}
+	builder.WriteString("\n")
+	if schedule := dashboard.runStats.schedule; schedule != nil {
+		_, _ = fmt.Fprintf(&builder, "Open loop:     %.2f req/s target, backlog %d (max %d)\n", schedule.Rate, schedule.Backlog(), schedule.MaxBacklog())
+	}
+	if stage, concurrency, ok := dashboard.currentStage(); ok {
+		_, _ = fmt.Fprintf(&builder, "Stage:         %s, %d of %d requests in flight\n", stage.Name, dashboard.runStats.loadProfile.Active(), concurrency)
+	}
+	if limiter := dashboard.runStats.bandwidthLimiter; limiter != nil {
+		_, _ = fmt.Fprintf(&builder, "Bandwidth cap: %s, %d transfers waiting, throttled %s in total\n", limiter, limiter.Waiting(),
+			limiter.Throttled().Round(time.Millisecond))
+	}
+	if protocols := dashboard.runStats.Protocols(); len(protocols) > 0 {
+		perProtocol := make([]string, 0, len(protocols))
+		for _, protocol := range protocols {
+			perProtocol = append(perProtocol, fmt.Sprintf("%s %d", protocol, dashboard.runStats.protocolStats(protocol).requests.Load()))
+		}
+		_, _ = fmt.Fprintf(&builder, "Protocols:     %s requests\n", strings.Join(perProtocol, ", "))
+	}
+	if tlsSessions := dashboard.runStats.TLSSessions(); len(tlsSessions) > 0 {
+		setups, remoteIPs := make(map[string]bool), make(map[string]bool)
+		for _, tlsSession := range tlsSessions {
+			setups[tlsSession.setup()] = true
+			remoteIPs[tlsSession.RemoteIP] = true
+		}
+		_, _ = fmt.Fprintf(&builder, "TLS:           %d distinct setups over %d remote IPs\n", len(setups), len(remoteIPs))
+	}
+	if happyEyeballs := dashboard.runStats.happyEyeballs; happyEyeballs != nil {
+		_, _ = fmt.Fprintf(&builder, "Eyeballs:      %d races, IPv6 won %d, IPv4 won %d, %d failed\n", happyEyeballs.Races(),
+			happyEyeballs.Family(FamilyIPv6).Wins(), happyEyeballs.Family(FamilyIPv4).Wins(), happyEyeballs.FailedRaces())
+	}
+	if quicStats := dashboard.runStats.quicStats; quicStats != nil {
+		_, _ = fmt.Fprintf(&builder, "QUIC:          %d handshakes (%d 0-RTT), %d packets lost (%.2f%%)\n", quicStats.Handshakes(),
+			quicStats.ZeroRTT(), quicStats.PacketsLost(), quicStats.LossRate()*100)
+	}
+	if progress, ok := dashboard.runLimit.Progress(); ok {
+		const barWidth = 40
+		filled := int(progress * barWidth)
+		_, _ = fmt.Fprintf(&builder, "Run limit:     [%s%s] %.1f%%\n", strings.Repeat("#", filled), strings.Repeat(".", barWidth-filled), progress*100)
+	}
+
+	shards := snapshot.GroupBy(func(labels Metrics.Labels) string {
+		return labels.RemoteIP
+	})
+	sort.SliceStable(shards, func(i, j int) bool {
+		return shards[i].Rate > shards[j].Rate
+	})
+	if len(shards) > dashboardTopIPs {
+		shards = shards[:dashboardTopIPs]
+	}
+	builder.WriteString("\n")
+	writer := tabwriter.NewWriter(&builder, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(writer, "remote ip\tspeed\taverage\tdownloaded\trequests\terrors\t")
+	for _, shard := range shards {
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\t%d\t\n", shard.Labels.RemoteIP, Utils.FormatBitRate(shard.Rate),
+			Utils.FormatBitRate(shard.AverageRate), Utils.FormatBytes(shard.Bytes), shard.Requests, shard.Failures)
+	}
+	_ = writer.Flush()
+
+	// A multi-homed run also shows how the source addresses compare
+	localIPs := snapshot.GroupBy(func(labels Metrics.Labels) string {
+		return labels.LocalIP
+	})
+	if len(localIPs) > 1 {
+		builder.WriteString("\n")
+		writer = tabwriter.NewWriter(&builder, 0, 0, 2, ' ', tabwriter.AlignRight)
+		_, _ = fmt.Fprintln(writer, "local ip\tspeed\taverage\tdownloaded\trequests\terrors\t")
+		for _, shard := range localIPs {
+			_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\t%d\t\n", shard.Labels.LocalIP, Utils.FormatBitRate(shard.Rate),
+				Utils.FormatBitRate(shard.AverageRate), Utils.FormatBytes(shard.Bytes), shard.Requests, shard.Failures)
+		}
+		_ = writer.Flush()
+	}
+	return builder.String()
+}
+
+// currentStage returns the running stage of the load profile and its target concurrency
+func (dashboard *Dashboard) currentStage() (Stage, int, bool) {
+	loadProfile := dashboard.runStats.loadProfile
+	if loadProfile == nil {
+		return Stage{}, 0, false
+	}
+	index, concurrency := loadProfile.Current()
+	if index < 0 || index >= len(loadProfile.Stages) {
+		return Stage{}, 0, false
+	}
+	return loadProfile.Stages[index], concurrency, true
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"HttpBenchmark/Metrics"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboardRender(t *testing.T) {
+	runStats := NewRunStats()
+	runLimit := NewRunLimit(runStats, WithMaxBytes(4000))
+	runStats.SetDNSTarget("https://223.5.5.5/dns-query", "1.2.3.0/24")
+	runStats.Shard(Metrics.Labels{RemoteIP: "10.0.0.1"}).AddBytes(1000)
+	runStats.Shard(Metrics.Labels{RemoteIP: "10.0.0.2"}).AddBytes(1000)
+	runStats.Shard(Metrics.Labels{RemoteIP: "10.0.0.2"}).AddFailure(FailureRequest)
+
+	dashboard := NewDashboard(runStats, runLimit, time.Second, &bytes.Buffer{})
+	assert.False(t, dashboard.terminal)
+	view := dashboard.Render(runStats.Collector().Snapshot())
+	t.Log("\n" + view)
+	assert.Contains(t, view, "https://223.5.5.5/dns-query")
+	assert.Contains(t, view, "1.2.3.0/24")
+	assert.Contains(t, view, "50.0%")
+	assert.Contains(t, view, "10.0.0.1")
+	assert.Contains(t, view, "10.0.0.2")
+
+	line := dashboard.StatusLine(runStats.Collector().Snapshot())
+	assert.NotContains(t, line, "\n")
+	assert.True(t, strings.HasSuffix(line, "Run limit: 50.0%"))
+	assert.Contains(t, line, "Errors: 1")
+}
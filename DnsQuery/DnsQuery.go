@@ -1,6 +1,7 @@
 package DnsQuery
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"github.com/miekg/dns"
@@ -12,6 +13,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // createQuery creates a slice of DnsQuery queries
@@ -143,7 +145,16 @@ func parseRRTypes(t []string) ([]uint16, error) {
 	}
 	return rrTypesSlice, nil
 }
-func DoDnsQuery(queryDNSFlags QueryDNSFlags) ([]*net.IP, error) {
+func DoDnsQuery(ctx context.Context, queryDNSFlags QueryDNSFlags) ([]*net.IP, error) {
+	startTime := time.Now()
+	ipResultList, err := doDnsQuery(ctx, queryDNSFlags)
+	if queryDNSFlags.Collector != nil {
+		queryDNSFlags.Collector.AddDNSQuery(queryDNSFlags.Server, time.Since(startTime), err != nil)
+	}
+	return ipResultList, err
+}
+
+func doDnsQuery(ctx context.Context, queryDNSFlags QueryDNSFlags) ([]*net.IP, error) {
 	queryDNSFlags.Server, _ = parseServer(queryDNSFlags.Server)
 
 	rrTypesSlice, err := parseRRTypes(queryDNSFlags.Types)
@@ -158,7 +169,7 @@ func DoDnsQuery(queryDNSFlags QueryDNSFlags) ([]*net.IP, error) {
 	}
 	var replies []*dns.Msg
 	for _, msg := range msgLists {
-		response, err := (*transport).Exchange(&msg)
+		response, err := (*transport).Exchange(ctx, &msg)
 		if err != nil {
 			return nil, fmt.Errorf("error exchanging message: %v", err)
 		}
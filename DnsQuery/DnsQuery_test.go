@@ -1,6 +1,8 @@
 package DnsQuery
 
 import (
+	"HttpBenchmark/Common"
+	"context"
 	"fmt"
 	"testing"
 	"time"
@@ -12,15 +14,17 @@ func TestDo_DNS_Query(t *testing.T) {
 		Server:           "223.5.5.5",
 		Types:            []string{"AAAA"},
 		ClientSubnet:     "1.1.1.1/24",
-		Timeout:          10 * time.Second,
 		Pad:              false,
-		HTTPMethod:       "GET",
-		ReuseConn:        true,
 		RecursionDesired: true,
 		Class:            1,
+		HttpBaseConfig: Common.HttpBaseConfig{
+			Timeout:    10 * time.Second,
+			HTTPMethod: "GET",
+			ReuseConn:  true,
+		},
 	}
 
-	query, err := DoDnsQuery(flags)
+	query, err := DoDnsQuery(context.Background(), flags)
 	fmt.Print(query)
 	if err != nil {
 		return
@@ -7,6 +7,8 @@ import (
 	"encoding/base64"
 	"fmt"
 	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/http2"
 	"io"
@@ -28,12 +30,22 @@ type HTTP struct {
 type versionedRoundTripper struct {
 	http1 *http.Transport
 	http2 *http2.Transport
+	// http3 is nil unless HTTP3 is set
+	http3 *http3.Transport
 }
 
 func (v *versionedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	var resp *http.Response
 	var err error
 
+	if v.http3 != nil {
+		resp, err = v.http3.RoundTrip(req)
+		if err == nil {
+			return resp, nil
+		}
+		log.Debugf("[http] HTTP/3 request to %s failed: %s", req.URL, err)
+	}
+
 	// If HTTP/3 fails, try HTTP/2
 	resp, err = v.http2.RoundTrip(req)
 	if err == nil {
@@ -44,8 +56,51 @@ func (v *versionedRoundTripper) RoundTrip(req *http.Request) (*http.Response, er
 	return v.http1.RoundTrip(req)
 }
 
-func (h *HTTP) Exchange(m *dns.Msg) (*dns.Msg, error) {
+func (v *versionedRoundTripper) CloseIdleConnections() {
+	v.http1.CloseIdleConnections()
+	v.http2.CloseIdleConnections()
+	if v.http3 != nil {
+		_ = v.http3.Close()
+	}
+}
+
+// newHTTP3Transport returns an HTTP/3 transport whose QUIC connections are sent from localIP
+func newHTTP3Transport(tlsConfig *tls.Config, localIP net.IP) *http3.Transport {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	return &http3.Transport{
+		TLSClientConfig: tlsConfig,
+		Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+			remoteAddr, err := net.ResolveUDPAddr("udp", addr)
+			if err != nil {
+				return nil, err
+			}
+			udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+			if err != nil {
+				return nil, err
+			}
+			conn, err := quic.DialEarly(ctx, udpConn, remoteAddr, tlsCfg, cfg)
+			if err != nil {
+				_ = udpConn.Close()
+				return nil, err
+			}
+			// The packet conn is not closed by quic-go when it was passed in
+			go func() {
+				<-conn.Context().Done()
+				_ = udpConn.Close()
+			}()
+			return conn, nil
+		},
+	}
+}
+
+func (h *HTTP) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
 	if h.conn == nil || !h.ReuseConn {
+		if h.conn != nil {
+			// Drop the connections of the previous query, an HTTP/3 one would hold its UDP socket until it idles out
+			h.conn.CloseIdleConnections()
+		}
 		dialer := &net.Dialer{} // Create a new dialer
 		if h.LocalIP != nil {   // If a local IP is set
 			localAddr := &net.TCPAddr{
@@ -63,10 +118,14 @@ func (h *HTTP) Exchange(m *dns.Msg) (*dns.Msg, error) {
 			},
 			http2: &http2.Transport{
 				DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
-					return tls.DialWithDialer(dialer, network, addr, cfg)
+					tlsDialer := &tls.Dialer{NetDialer: dialer, Config: cfg}
+					return tlsDialer.DialContext(ctx, network, addr)
 				},
 			},
 		}
+		if h.HTTP3 {
+			vrt.http3 = newHTTP3Transport(h.TLSConfig, h.LocalIP)
+		}
 		h.conn = &http.Client{
 			Timeout:   h.Timeout,
 			Transport: vrt,
@@ -83,13 +142,13 @@ func (h *HTTP) Exchange(m *dns.Msg) (*dns.Msg, error) {
 	switch h.Method {
 	case http.MethodGet:
 		queryURL = h.Server + "?dns=" + base64.RawURLEncoding.EncodeToString(buf)
-		req, err = http.NewRequest(http.MethodGet, queryURL, nil)
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, queryURL, nil)
 		if err != nil {
 			return nil, fmt.Errorf("creating http request to %s: %w", queryURL, err)
 		}
 	case http.MethodPost:
 		queryURL = h.Server
-		req, err = http.NewRequest(http.MethodPost, queryURL, bytes.NewReader(buf))
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, queryURL, bytes.NewReader(buf))
 		if err != nil {
 			return nil, fmt.Errorf("creating http request to %s: %w", queryURL, err)
 		}
@@ -1,19 +1,27 @@
 package DnsQuery
 
 import (
+	"HttpBenchmark/Common"
+	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go/http3"
 	"github.com/stretchr/testify/assert"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
 
 func httpTransport() *HTTP {
 	return &HTTP{
-		DnsHttpConfig: DnsHttpConfig{
-			Server:  "https://dns.alidns.com/dns-query",
-			Timeout: 2 * time.Second,
+		QueryDNSFlags: QueryDNSFlags{
+			Server: "https://dns.alidns.com/dns-query",
+			HttpBaseConfig: Common.HttpBaseConfig{
+				Timeout: 2 * time.Second,
+			},
 		},
 		TLSConfig: &tls.Config{},
 		UserAgent: "",
@@ -26,7 +34,7 @@ func validQuery() *dns.Msg {
 	msg.RecursionDesired = true
 	msg.Id = dns.Id()
 	msg.Question = []dns.Question{{
-		Name:   "baidu.com",
+		Name:   "baidu.com.",
 		Qtype:  dns.StringToType["A"],
 		Qclass: dns.ClassINET,
 	}}
@@ -36,7 +44,52 @@ func validQuery() *dns.Msg {
 func TestTransportHTTPPOST(t *testing.T) {
 	tp := httpTransport()
 	tp.Method = http.MethodPost
-	reply, err := tp.Exchange(validQuery())
+	reply, err := tp.Exchange(context.Background(), validQuery())
 	assert.Nil(t, err)
 	assert.Greater(t, len(reply.Answer), 0)
 }
+
+func TestTransportHTTP3(t *testing.T) {
+	var protocol string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protocol = r.Proto
+		buf, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		assert.Nil(t, err)
+		request := dns.Msg{}
+		assert.Nil(t, request.Unpack(buf))
+		reply := dns.Msg{}
+		reply.SetReply(&request)
+		reply.Answer = append(reply.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: request.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(192, 0, 2, 1),
+		})
+		packed, err := reply.Pack()
+		assert.Nil(t, err)
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(packed)
+	})
+	// Only the HTTP/3 server listens, the fallback to HTTP/2 would fail
+	certificateServer := httptest.NewTLSServer(handler)
+	certificateServer.Close()
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	server := &http3.Server{
+		Handler:   handler,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: certificateServer.TLS.Certificates}),
+	}
+	go func() {
+		_ = server.Serve(udpConn)
+	}()
+	defer server.Close()
+
+	tp := httpTransport()
+	tp.Server = "https://" + udpConn.LocalAddr().String() + "/dns-query"
+	tp.HTTP3 = true
+	tp.LocalIP = net.IPv4(127, 0, 0, 1)
+	tp.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	reply, err := tp.Exchange(context.Background(), validQuery())
+	assert.Nil(t, err)
+	assert.Len(t, reply.Answer, 1)
+	assert.Equal(t, "HTTP/3.0", protocol)
+	assert.Nil(t, tp.Close())
+}
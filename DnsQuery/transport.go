@@ -2,6 +2,8 @@ package DnsQuery
 
 import (
 	"HttpBenchmark/Common"
+	"HttpBenchmark/Metrics"
+	"context"
 	"github.com/miekg/dns"
 	"math/rand"
 	"time"
@@ -25,10 +27,13 @@ type QueryDNSFlags struct {
 	Zero                bool     `long:"z" description:"Set Z (Zero) flag in query" default:"false"`
 	Truncated           bool     `long:"t" description:"Set TC (Truncated) flag in query" default:"false"`
 	UDPBuffer           uint16   `long:"udp-buffer" description:"Set EDNS0 UDP size in query" default:"1232"`
+	HTTP3               bool     `long:"http3" description:"Query the DoH server over HTTP/3 first, falling back to HTTP/2 and HTTP/1.1" default:"false"`
+	// Collector counts the queries and their failures when set
+	Collector *Metrics.Collector
 }
 
 type Transport interface {
-	Exchange(*dns.Msg) (*dns.Msg, error)
+	Exchange(context.Context, *dns.Msg) (*dns.Msg, error)
 	Close() error
 }
 
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"HttpBenchmark/Metrics"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/logging"
+)
+
+// HTTPVersion3 runs the downloads over HTTP/3, QUIC connections over UDP to the remote IP
+const HTTPVersion3 = "3"
+
+// QUICStats are the QUIC connections of the HTTP/3 downloads of a run. The TLS session cache is shared by all workers,
+// so that a new connection to a server that issued a ticket before can resume with 0-RTT.
+type QUICStats struct {
+	sessionCache tls.ClientSessionCache
+	handshakes   atomic.Int64
+	zeroRTT      atomic.Int64
+	packetsSent  atomic.Int64
+	packetsLost  atomic.Int64
+	// probeTimeouts counts the probe timeouts, the retransmissions when no acknowledgement arrived in time
+	probeTimeouts atomic.Int64
+	// handshake holds the durations from the start of the dial to the completed handshake in nanoseconds
+	handshake *Metrics.Histogram
+}
+
+func NewQUICStats() *QUICStats {
+	return &QUICStats{
+		sessionCache: tls.NewLRUClientSessionCache(0),
+		handshake:    Metrics.NewHistogram(),
+	}
+}
+
+// tracer counts the packets of a connection that quic-go sent and declared lost, every lost packet has its frames
+// retransmitted in a later one
+func (quicStats *QUICStats) tracer(context.Context, logging.Perspective, quic.ConnectionID) *logging.ConnectionTracer {
+	return &logging.ConnectionTracer{
+		SentLongHeaderPacket: func(*logging.ExtendedHeader, logging.ByteCount, logging.ECN, *logging.AckFrame, []logging.Frame) {
+			quicStats.packetsSent.Add(1)
+		},
+		SentShortHeaderPacket: func(*logging.ShortHeader, logging.ByteCount, logging.ECN, *logging.AckFrame, []logging.Frame) {
+			quicStats.packetsSent.Add(1)
+		},
+		LostPacket: func(logging.EncryptionLevel, logging.PacketNumber, logging.PacketLossReason) {
+			quicStats.packetsLost.Add(1)
+		},
+		UpdatedPTOCount: func(value uint32) {
+			// The count goes back to 0 with the next acknowledgement, every rise is another probe timeout
+			if value > 0 {
+				quicStats.probeTimeouts.Add(1)
+			}
+		},
+	}
+}
+
+// recordHandshake counts a completed handshake that took duration and tells whether it resumed with 0-RTT
+func (quicStats *QUICStats) recordHandshake(duration time.Duration, used0RTT bool) {
+	quicStats.handshakes.Add(1)
+	quicStats.handshake.Record(int64(duration))
+	if used0RTT {
+		quicStats.zeroRTT.Add(1)
+	}
+}
+
+// Handshakes returns the number of completed QUIC handshakes
+func (quicStats *QUICStats) Handshakes() int64 {
+	return quicStats.handshakes.Load()
+}
+
+// ZeroRTT returns the number of handshakes that resumed a session with 0-RTT
+func (quicStats *QUICStats) ZeroRTT() int64 {
+	return quicStats.zeroRTT.Load()
+}
+
+func (quicStats *QUICStats) PacketsSent() int64 {
+	return quicStats.packetsSent.Load()
+}
+
+func (quicStats *QUICStats) PacketsLost() int64 {
+	return quicStats.packetsLost.Load()
+}
+
+func (quicStats *QUICStats) ProbeTimeouts() int64 {
+	return quicStats.probeTimeouts.Load()
+}
+
+// LossRate returns the share of the sent packets that were declared lost, 0 before a packet was sent
+func (quicStats *QUICStats) LossRate() float64 {
+	sent := quicStats.PacketsSent()
+	if sent == 0 {
+		return 0
+	}
+	return float64(quicStats.PacketsLost()) / float64(sent)
+}
+
+// Handshake returns the distribution of the handshake durations in nanoseconds
+func (quicStats *QUICStats) Handshake() Metrics.HistogramSnapshot {
+	return quicStats.handshake.Snapshot()
+}
+
+// createHTTP3Transport returns the HTTP/3 round tripper of a worker. With keep-alive its requests share a QUIC
+// connection as streams, otherwise every request dials its own connection.
+func (downloadHttpConfig *DownloadHttpConfig) createHTTP3Transport() http.RoundTripper {
+	quicStats := downloadHttpConfig.quicStats
+	if quicStats == nil {
+		quicStats = NewQUICStats()
+	}
+	newTransport := func() *http3.Transport {
+		return &http3.Transport{
+			// http3 replaces the ALPN protocols with h3
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				ClientSessionCache: quicStats.sessionCache,
+			},
+			QUICConfig: &quic.Config{
+				HandshakeIdleTimeout: downloadHttpConfig.OrTimeout(downloadHttpConfig.TLSHandshakeTimeout),
+				Tracer:               quicStats.tracer,
+			},
+			Dial: func(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (quic.EarlyConnection, error) {
+				return downloadHttpConfig.dialQUIC(ctx, tlsConfig, quicConfig, quicStats)
+			},
+		}
+	}
+	if downloadHttpConfig.ReuseConn {
+		return newTransport()
+	}
+	return singleUseHTTP3{newTransport: newTransport}
+}
+
+// dialQUIC connects from the local IP to the remote IP and port of the worker instead of the address of the URL.
+// QUIC does the TLS handshake as part of the connect, so the Connect phase of a request covers both.
+func (downloadHttpConfig *DownloadHttpConfig) dialQUIC(ctx context.Context, tlsConfig *tls.Config, quicConfig *quic.Config,
+	quicStats *QUICStats) (quic.EarlyConnection, error) {
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: downloadHttpConfig.LocalIP})
+	if err != nil {
+		return nil, err
+	}
+	remoteAddr := &net.UDPAddr{IP: *downloadHttpConfig.RemoteIP, Port: downloadHttpConfig.RemotePort}
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.ConnectStart != nil {
+		trace.ConnectStart("udp", remoteAddr.String())
+	}
+	start := time.Now()
+	conn, err := quic.DialEarly(ctx, udpConn, remoteAddr, tlsConfig, quicConfig)
+	if trace != nil && trace.ConnectDone != nil {
+		trace.ConnectDone("udp", remoteAddr.String(), err)
+	}
+	if err != nil {
+		_ = udpConn.Close()
+		return nil, err
+	}
+	var collector *Metrics.Collector
+	if downloadHttpConfig.runStats != nil {
+		collector = downloadHttpConfig.runStats.Collector()
+		collector.ConnOpened()
+	}
+	go func() {
+		// DialEarly returns before the handshake is complete when it can send 0-RTT data
+		select {
+		case <-conn.HandshakeComplete():
+			quicStats.recordHandshake(time.Since(start), conn.ConnectionState().Used0RTT)
+			downloadHttpConfig.recordTLS(conn.RemoteAddr(), conn.ConnectionState().TLS)
+		case <-conn.Context().Done():
+		}
+		<-conn.Context().Done()
+		_ = udpConn.Close()
+		if collector != nil {
+			collector.ConnClosed()
+		}
+	}()
+	return newQUICConn(conn), nil
+}
+
+// quicConn reports the request streams of a QUIC connection to the GotConn hook of their request, which http3 does
+// not call, so that the requests of a connection are timed and counted like those of a TCP connection
+type quicConn struct {
+	quic.EarlyConnection
+	handle  net.Conn
+	streams atomic.Int64
+}
+
+// quicConnHandle stands in for a QUIC connection where httptrace wants a net.Conn, only its addresses can be used
+type quicConnHandle struct {
+	net.Conn
+	conn quic.EarlyConnection
+}
+
+func newQUICConn(conn quic.EarlyConnection) *quicConn {
+	return &quicConn{
+		EarlyConnection: conn,
+		handle:          &quicConnHandle{conn: conn},
+	}
+}
+
+// OpenStreamSync opens the stream of a request, http3 passes it the context of the request
+func (conn *quicConn) OpenStreamSync(ctx context.Context) (quic.Stream, error) {
+	stream, err := conn.EarlyConnection.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if trace := httptrace.ContextClientTrace(ctx); trace != nil && trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{Conn: conn.handle, Reused: conn.streams.Add(1) > 1})
+	}
+	return stream, nil
+}
+
+func (handle *quicConnHandle) LocalAddr() net.Addr {
+	return handle.conn.LocalAddr()
+}
+
+func (handle *quicConnHandle) RemoteAddr() net.Addr {
+	return handle.conn.RemoteAddr()
+}
+
+// singleUseHTTP3 dials a new QUIC connection for every request and closes it with the response body
+type singleUseHTTP3 struct {
+	newTransport func() *http3.Transport
+}
+
+func (singleUse singleUseHTTP3) RoundTrip(request *http.Request) (*http.Response, error) {
+	transport := singleUse.newTransport()
+	response, err := transport.RoundTrip(request)
+	if err != nil {
+		_ = transport.Close()
+		return nil, err
+	}
+	response.Body = &closingBody{ReadCloser: response.Body, onClose: transport.Close}
+	return response, nil
+}
+
+// closingBody runs onClose after closing the body
+type closingBody struct {
+	io.ReadCloser
+	onClose func() error
+}
+
+func (body *closingBody) Close() error {
+	err := body.ReadCloser.Close()
+	if closeErr := body.onClose(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// zeroRTTMethod returns the method that lets http3 send a request in 0-RTT data, only requests without a body
+// that are safe to replay qualify
+func zeroRTTMethod(method string, hasBody bool) string {
+	switch {
+	case hasBody:
+		return method
+	case method == http.MethodGet:
+		return http3.MethodGet0RTT
+	case method == http.MethodHead:
+		return http3.MethodHead0RTT
+	default:
+		return method
+	}
+}
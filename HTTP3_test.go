@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/assert"
+)
+
+// newHTTP3TestServer serves handler over HTTP/3 on a local UDP port with the certificate of an httptest server and
+// returns a download pinned to it
+func newHTTP3TestServer(t *testing.T, handler http.Handler) *DownloadHttpConfig {
+	certificateServer := httptest.NewTLSServer(handler)
+	certificateServer.Close()
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	server := &http3.Server{
+		Handler:   handler,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: certificateServer.TLS.Certificates}),
+	}
+	go func() {
+		_ = server.Serve(udpConn)
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = udpConn.Close()
+	})
+
+	remoteAddr := udpConn.LocalAddr().(*net.UDPAddr)
+	serverURL := &url.URL{Scheme: "https", Host: net.JoinHostPort("download.invalid", strconv.Itoa(remoteAddr.Port)), Path: "/"}
+	return NewDownloadHttpConfig(WithUrl(serverURL), WithRemoteIP(&remoteAddr.IP), WithRemotePort(remoteAddr.Port),
+		WithHTTPVersion(HTTPVersion3))
+}
+
+func TestHTTP3Download(t *testing.T) {
+	downloadHttpConfig := newHTTP3TestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 64*1024))
+	}))
+	runStats := NewRunStats()
+	quicStats := NewQUICStats()
+	runStats.SetQUICStats(quicStats)
+	WithRunStats(runStats)(downloadHttpConfig)
+	WithQUICStats(quicStats)(downloadHttpConfig)
+	WithSingleIpDownloadTimes(3)(downloadHttpConfig)
+	downloadHttpConfig.ReuseConn = false
+	assert.Nil(t, downloadHttpConfig.DoHttpDownload(context.Background()))
+
+	snapshot := runStats.Collector().Snapshot()
+	assert.Equal(t, int64(3), snapshot.Total.Requests)
+	assert.Equal(t, int64(3*64*1024), snapshot.Total.Bytes)
+	assert.Equal(t, []string{"HTTP/3.0"}, runStats.Protocols())
+	assert.Equal(t, int64(3), runStats.protocolStats("HTTP/3.0").connections.Load())
+	// Every request dials its own connection, the later ones resume the session of the first with 0-RTT
+	assert.Eventually(t, func() bool { return quicStats.Handshakes() == 3 }, time.Second, time.Millisecond)
+	assert.Greater(t, quicStats.ZeroRTT(), int64(0))
+	assert.Greater(t, quicStats.PacketsSent(), int64(0))
+	tlsSessions := runStats.TLSSessions()
+	assert.Len(t, tlsSessions, 1)
+	assert.Equal(t, "h3", tlsSessions[0].ALPN)
+	assert.Equal(t, int64(3), tlsSessions[0].Connections)
+	assert.GreaterOrEqual(t, tlsSessions[0].Resumed, quicStats.ZeroRTT())
+
+	report := runStats.Report(ReportConfig{}, StopReasonLimit)
+	assert.Equal(t, int64(3), report.QUIC.Handshakes)
+	assert.Equal(t, int64(3), report.QUIC.Handshake.Count)
+	assert.Contains(t, runStats.Summary(), "QUIC: 3 handshakes")
+}
+
+func TestHTTP3Streams(t *testing.T) {
+	downloadHttpConfig := newHTTP3TestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 1024))
+	}))
+	runStats := NewRunStats()
+	quicStats := NewQUICStats()
+	downloadHttpConfig.ReuseConn = true
+	WithRunStats(runStats)(downloadHttpConfig)
+	WithQUICStats(quicStats)(downloadHttpConfig)
+	WithStreams(4)(downloadHttpConfig)
+	WithSingleIpDownloadTimes(8)(downloadHttpConfig)
+	assert.Nil(t, downloadHttpConfig.DoHttpDownload(context.Background()))
+
+	assert.Equal(t, int64(8), runStats.Collector().Snapshot().Total.Requests)
+	// The streams of a worker share one QUIC connection
+	assert.Eventually(t, func() bool { return quicStats.Handshakes() == 1 }, time.Second, time.Millisecond)
+	report := runStats.Report(ReportConfig{}, StopReasonLimit)
+	assert.Len(t, report.Protocols, 1)
+	assert.Equal(t, int64(1), report.Protocols[0].Connections)
+	assert.Equal(t, 8.0, report.Protocols[0].StreamsPerConnection)
+}
+
+func TestHTTP3UnreachableServer(t *testing.T) {
+	// A port without a QUIC server only times out, nothing refuses a UDP packet the way TCP does
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	defer udpConn.Close()
+	remoteAddr := udpConn.LocalAddr().(*net.UDPAddr)
+	runStats := NewRunStats()
+	downloadHttpConfig := NewDownloadHttpConfig(WithUrl(&url.URL{Scheme: "https", Host: "download.invalid"}),
+		WithRemoteIP(&remoteAddr.IP), WithRemotePort(remoteAddr.Port), WithHTTPVersion(HTTPVersion3), WithRunStats(runStats),
+		WithSingleIpDownloadTimes(1))
+	downloadHttpConfig.TLSHandshakeTimeout = 100 * time.Millisecond
+	assert.Nil(t, downloadHttpConfig.DoHttpDownload(context.Background()))
+	assert.Equal(t, int64(1), runStats.Collector().Snapshot().Total.FailureClasses[FailureTimeout])
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"HttpBenchmark/Metrics"
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// defaultConnectionAttemptDelay is the head start of the IPv6 connect before the IPv4 one, the value RFC 8305 recommends
+const defaultConnectionAttemptDelay = 250 * time.Millisecond
+
+// HappyEyeballs races the IPv6 and IPv4 connects to a dual-stack host as RFC 8305 describes and counts how the
+// families fared. IPv6 starts first, IPv4 follows only when IPv6 has not connected after Delay or as soon as IPv6 fails,
+// the first connection wins and the other attempt is cancelled.
+// MeasureBoth starts IPv4 after Delay even when IPv6 already won and lets the loser finish, so that the margin of every
+// win and the success rate of both families are known, at the cost of a second connect per race.
+type HappyEyeballs struct {
+	Delay       time.Duration
+	MeasureBoth bool
+
+	races       atomic.Int64
+	failedRaces atomic.Int64
+	// families holds the counters of FamilyIPv6 and FamilyIPv4, the map itself is never written after construction
+	families map[string]*RaceFamilyStats
+	// margins holds how much earlier the winner connected than the loser in nanoseconds, for races both families connected
+	margins *Metrics.Histogram
+}
+
+// RaceFamilyStats are the connect attempts of one IP family in the races
+type RaceFamilyStats struct {
+	attempts  atomic.Int64
+	successes atomic.Int64
+	wins      atomic.Int64
+	// connect holds the connect durations of the successful attempts in nanoseconds, from the start of the attempt
+	connect *Metrics.Histogram
+}
+
+// RaceDialer connects to remoteIP, it is called once per family of a race
+type RaceDialer func(ctx context.Context, remoteIP net.IP) (net.Conn, error)
+
+// raceConn is a connection that won a race, it carries the IP family it went to so that the requests over it are
+// counted for that family
+type raceConn struct {
+	net.Conn
+	family string
+}
+
+// raceFamily returns the family of the race that established conn, unwrapping TLS and tracked connections, and an
+// empty string for a connection that was not raced
+func raceFamily(conn net.Conn) string {
+	for {
+		switch wrapped := conn.(type) {
+		case *raceConn:
+			return wrapped.family
+		case interface{ NetConn() net.Conn }:
+			conn = wrapped.NetConn()
+		default:
+			return ""
+		}
+	}
+}
+
+// raceAttempt is the outcome of the connect of one family, at is when it finished
+type raceAttempt struct {
+	family   string
+	remoteIP net.IP
+	conn     net.Conn
+	err      error
+	at       time.Time
+}
+
+func NewHappyEyeballs(delay time.Duration, measureBoth bool) *HappyEyeballs {
+	return &HappyEyeballs{
+		Delay:       delay,
+		MeasureBoth: measureBoth,
+		families: map[string]*RaceFamilyStats{
+			FamilyIPv6: {connect: Metrics.NewHistogram()},
+			FamilyIPv4: {connect: Metrics.NewHistogram()},
+		},
+		margins: Metrics.NewHistogram(),
+	}
+}
+
+// Race connects to ipv6 and ipv4 with dial and returns the connection that was established first and its remote IP.
+// It returns once there is a winner, the other attempt is finished in the background.
+func (happyEyeballs *HappyEyeballs) Race(ctx context.Context, ipv6, ipv4 net.IP, dial RaceDialer) (net.Conn, net.IP, error) {
+	happyEyeballs.races.Add(1)
+	winner := make(chan raceAttempt)
+	go happyEyeballs.referee(ctx, ipv6, ipv4, dial, winner)
+	select {
+	case result := <-winner:
+		return result.conn, result.remoteIP, result.err
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+// referee runs the attempts of a race and hands the first connection to winner, or the last error when both failed.
+// When ctx is done before the hand-over the connection is closed instead.
+func (happyEyeballs *HappyEyeballs) referee(ctx context.Context, ipv6, ipv4 net.IP, dial RaceDialer, winner chan<- raceAttempt) {
+	// The winner cancels the losing attempt, with MeasureBoth it outlives the request that started the race and only
+	// the dial timeout bounds it
+	attemptCtx, cancel := context.WithCancel(ctx)
+	if happyEyeballs.MeasureBoth {
+		attemptCtx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+	}
+	defer cancel()
+	results := make(chan raceAttempt, 2)
+	attempt := func(family string, remoteIP net.IP) {
+		start := time.Now()
+		conn, err := dial(attemptCtx, remoteIP)
+		// An attempt cancelled because the race was decided or abandoned tells nothing about its family
+		if err != nil && attemptCtx.Err() != nil {
+			results <- raceAttempt{family: family, remoteIP: remoteIP, err: err, at: time.Now()}
+			return
+		}
+		happyEyeballs.families[family].attempts.Add(1)
+		if err == nil {
+			happyEyeballs.families[family].successes.Add(1)
+			happyEyeballs.families[family].connect.Record(int64(time.Since(start)))
+		}
+		results <- raceAttempt{family: family, remoteIP: remoteIP, conn: conn, err: err, at: time.Now()}
+	}
+	go attempt(FamilyIPv6, ipv6)
+	timer := time.NewTimer(happyEyeballs.Delay)
+	defer timer.Stop()
+	pending, ipv4Started := 1, false
+	startIPv4 := func() {
+		if !ipv4Started {
+			ipv4Started = true
+			pending++
+			go attempt(FamilyIPv4, ipv4)
+		}
+	}
+
+	var first *raceAttempt
+	var lastErr error
+	for pending > 0 || (happyEyeballs.MeasureBoth && !ipv4Started) {
+		select {
+		case <-timer.C:
+			if first == nil || happyEyeballs.MeasureBoth {
+				startIPv4()
+			}
+		case result := <-results:
+			pending--
+			if result.err != nil {
+				lastErr = result.err
+				// A failed attempt starts the next one at once instead of waiting out the delay
+				if first == nil {
+					startIPv4()
+				}
+				continue
+			}
+			if first != nil {
+				happyEyeballs.margins.Record(int64(result.at.Sub(first.at)))
+				_ = result.conn.Close()
+				continue
+			}
+			first = &result
+			happyEyeballs.families[result.family].wins.Add(1)
+			if !happyEyeballs.MeasureBoth {
+				cancel()
+			}
+			select {
+			case winner <- result:
+			case <-ctx.Done():
+				_ = result.conn.Close()
+			}
+		}
+	}
+	if first == nil {
+		happyEyeballs.failedRaces.Add(1)
+		if lastErr == nil {
+			lastErr = errors.New("happy eyeballs race without attempts")
+		}
+		select {
+		case winner <- raceAttempt{err: lastErr}:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// Races returns the number of races started
+func (happyEyeballs *HappyEyeballs) Races() int64 {
+	return happyEyeballs.races.Load()
+}
+
+// FailedRaces returns the number of races neither family connected in
+func (happyEyeballs *HappyEyeballs) FailedRaces() int64 {
+	return happyEyeballs.failedRaces.Load()
+}
+
+// Family returns the counters of FamilyIPv6 or FamilyIPv4
+func (happyEyeballs *HappyEyeballs) Family(family string) *RaceFamilyStats {
+	return happyEyeballs.families[family]
+}
+
+// Margins returns the distribution of the winning margins in nanoseconds
+func (happyEyeballs *HappyEyeballs) Margins() Metrics.HistogramSnapshot {
+	return happyEyeballs.margins.Snapshot()
+}
+
+func (raceFamilyStats *RaceFamilyStats) Attempts() int64 {
+	return raceFamilyStats.attempts.Load()
+}
+
+func (raceFamilyStats *RaceFamilyStats) Successes() int64 {
+	return raceFamilyStats.successes.Load()
+}
+
+func (raceFamilyStats *RaceFamilyStats) Wins() int64 {
+	return raceFamilyStats.wins.Load()
+}
+
+// SuccessRate returns the share of the connect attempts that succeeded, 0 without attempts
+func (raceFamilyStats *RaceFamilyStats) SuccessRate() float64 {
+	attempts := raceFamilyStats.Attempts()
+	if attempts == 0 {
+		return 0
+	}
+	return float64(raceFamilyStats.Successes()) / float64(attempts)
+}
+
+// Connect returns the distribution of the successful connect durations in nanoseconds
+func (raceFamilyStats *RaceFamilyStats) Connect() Metrics.HistogramSnapshot {
+	return raceFamilyStats.connect.Snapshot()
+}
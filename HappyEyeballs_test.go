@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	raceIPv6 = net.ParseIP("2001:db8::1")
+	raceIPv4 = net.ParseIP("192.0.2.1")
+)
+
+// fakeRaceDialer connects after the delay of the remote IP unless ctx is cancelled first, or fails with err when it is set
+func fakeRaceDialer(delays map[string]time.Duration, errs map[string]error) RaceDialer {
+	return func(ctx context.Context, remoteIP net.IP) (net.Conn, error) {
+		select {
+		case <-time.After(delays[remoteIP.String()]):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if err := errs[remoteIP.String()]; err != nil {
+			return nil, err
+		}
+		conn, _ := net.Pipe()
+		return conn, nil
+	}
+}
+
+func TestHappyEyeballsIPv6Wins(t *testing.T) {
+	happyEyeballs := NewHappyEyeballs(20*time.Millisecond, false)
+	conn, remoteIP, err := happyEyeballs.Race(context.Background(), raceIPv6, raceIPv4,
+		fakeRaceDialer(map[string]time.Duration{raceIPv6.String(): time.Millisecond}, nil))
+	assert.Nil(t, err)
+	assert.NotNil(t, conn)
+	assert.Equal(t, raceIPv6, remoteIP)
+	// IPv6 connected within the delay, IPv4 is never tried
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(1), happyEyeballs.Family(FamilyIPv6).Wins())
+	assert.Equal(t, int64(0), happyEyeballs.Family(FamilyIPv4).Attempts())
+	assert.Equal(t, int64(0), happyEyeballs.Margins().Count)
+}
+
+func TestHappyEyeballsMeasureBoth(t *testing.T) {
+	happyEyeballs := NewHappyEyeballs(20*time.Millisecond, true)
+	_, remoteIP, err := happyEyeballs.Race(context.Background(), raceIPv6, raceIPv4,
+		fakeRaceDialer(map[string]time.Duration{raceIPv6.String(): time.Millisecond}, nil))
+	assert.Nil(t, err)
+	assert.Equal(t, raceIPv6, remoteIP)
+	// The IPv4 connect still starts after the delay, so that the margin of the win is known
+	assert.Eventually(t, func() bool { return happyEyeballs.Margins().Count == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, int64(1), happyEyeballs.Family(FamilyIPv6).Wins())
+	assert.Equal(t, int64(1), happyEyeballs.Family(FamilyIPv4).Successes())
+	assert.GreaterOrEqual(t, happyEyeballs.Margins().Min, int64(15*time.Millisecond))
+}
+
+func TestHappyEyeballsBrokenIPv6(t *testing.T) {
+	happyEyeballs := NewHappyEyeballs(time.Hour, false)
+	start := time.Now()
+	_, remoteIP, err := happyEyeballs.Race(context.Background(), raceIPv6, raceIPv4,
+		fakeRaceDialer(nil, map[string]error{raceIPv6.String(): errors.New("no route to host")}))
+	assert.Nil(t, err)
+	assert.Equal(t, raceIPv4, remoteIP)
+	// A failed IPv6 connect starts IPv4 at once instead of waiting out the delay
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, 0.0, happyEyeballs.Family(FamilyIPv6).SuccessRate())
+	assert.Equal(t, 1.0, happyEyeballs.Family(FamilyIPv4).SuccessRate())
+	assert.Equal(t, int64(1), happyEyeballs.Family(FamilyIPv4).Wins())
+}
+
+func TestHappyEyeballsSlowIPv6(t *testing.T) {
+	happyEyeballs := NewHappyEyeballs(10*time.Millisecond, false)
+	_, remoteIP, err := happyEyeballs.Race(context.Background(), raceIPv6, raceIPv4,
+		fakeRaceDialer(map[string]time.Duration{raceIPv6.String(): 200 * time.Millisecond}, nil))
+	assert.Nil(t, err)
+	assert.Equal(t, raceIPv4, remoteIP)
+	assert.Equal(t, int64(1), happyEyeballs.Family(FamilyIPv4).Wins())
+	// The IPv4 win cancels the IPv6 connect, it is not counted against IPv6
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(0), happyEyeballs.Family(FamilyIPv6).Attempts())
+	assert.Equal(t, int64(0), happyEyeballs.Margins().Count)
+}
+
+func TestHappyEyeballsBothFail(t *testing.T) {
+	happyEyeballs := NewHappyEyeballs(time.Millisecond, false)
+	refused := errors.New("connection refused")
+	_, _, err := happyEyeballs.Race(context.Background(), raceIPv6, raceIPv4,
+		fakeRaceDialer(nil, map[string]error{raceIPv6.String(): refused, raceIPv4.String(): refused}))
+	assert.Equal(t, refused, err)
+	assert.Equal(t, int64(1), happyEyeballs.Races())
+	assert.Equal(t, int64(1), happyEyeballs.FailedRaces())
+
+	runStats := NewRunStats()
+	runStats.SetHappyEyeballs(happyEyeballs)
+	report := runStats.Report(ReportConfig{}, StopReasonLimit)
+	assert.Equal(t, int64(1), report.HappyEyeballs.FailedRaces)
+	assert.Len(t, report.HappyEyeballs.Families, 2)
+	assert.Equal(t, int64(1), report.HappyEyeballs.Families[0].Attempts)
+	assert.Contains(t, runStats.Summary(), "Happy eyeballs: 1 races, IPv6 won 0, IPv4 won 0, 1 failed")
+}
+
+func TestCreateDownloadTasksPairsRaceIPs(t *testing.T) {
+	picker := NewLocalIPPicker([]LocalAddress{{IP: net.ParseIP("10.0.0.2"), Weight: 1}, {IP: net.ParseIP("2001:db8::2"), Weight: 1}})
+	downloadHttpConfig := NewDownloadHttpConfig(WithRunStats(NewRunStats()), WithHappyEyeballs(NewHappyEyeballs(defaultConnectionAttemptDelay, false)))
+	tasks := createDownloadTasks(downloadHttpConfig, []*net.IP{&raceIPv4, &raceIPv6}, 2, picker,
+		&url.URL{Scheme: "https", Host: "download.invalid"})
+	for _, task := range tasks {
+		assert.True(t, task.racing())
+		assert.Equal(t, raceIPv6, *task.RemoteIP)
+		assert.Equal(t, raceIPv4, *task.raceIP)
+		assert.Equal(t, "2001:db8::2", task.LocalIP.String())
+		assert.Equal(t, "10.0.0.2", task.raceLocalIP.String())
+	}
+
+	// A host with a single family is not raced
+	tasks = createDownloadTasks(downloadHttpConfig, []*net.IP{&raceIPv4}, 1, picker, &url.URL{Scheme: "https", Host: "download.invalid"})
+	assert.False(t, tasks[0].racing())
+}
+
+func TestRaceConnSelectsShard(t *testing.T) {
+	runStats := NewRunStats()
+	downloadHttpConfig := NewDownloadHttpConfig(WithRunStats(runStats), WithHappyEyeballs(NewHappyEyeballs(defaultConnectionAttemptDelay, false)))
+	picker := NewLocalIPPicker([]LocalAddress{{IP: net.ParseIP("10.0.0.2"), Weight: 1}, {IP: net.ParseIP("2001:db8::2"), Weight: 1}})
+	task := createDownloadTasks(downloadHttpConfig, []*net.IP{&raceIPv4, &raceIPv6}, 1, picker,
+		&url.URL{Scheme: "https", Host: "download.invalid"})[0]
+	task.metricsShard = runStats.Shard(task.metricsLabels())
+	task.raceShard = runStats.Shard(task.raceLabels())
+
+	// Every connection carries the family it went to, the streams of a worker can be connected to either
+	ipv4Conn, _ := net.Pipe()
+	ipv6Conn, _ := net.Pipe()
+	ipv4Won := tls.Client(&trackedConn{Conn: &raceConn{Conn: ipv4Conn, family: FamilyIPv4}, onClose: func() {}}, &tls.Config{})
+	ipv6Won := tls.Client(&raceConn{Conn: ipv6Conn, family: FamilyIPv6}, &tls.Config{})
+	assert.Equal(t, FamilyIPv4, raceFamily(ipv4Won))
+	assert.Same(t, task.raceShard, task.shard(ipv4Won))
+	assert.Same(t, task.metricsShard, task.shard(ipv6Won))
+	assert.Same(t, task.metricsShard, task.shard(nil))
+	assert.Equal(t, "", raceFamily(ipv4Conn))
+}
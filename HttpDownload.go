@@ -2,19 +2,27 @@ package main
 
 import (
 	"HttpBenchmark/Common"
+	"HttpBenchmark/Metrics"
 	"HttpBenchmark/Utils"
 	"context"
 	"crypto/tls"
 	_ "embed"
+	"errors"
+	"fmt"
 	utls "github.com/sagernet/utls"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
 	"strconv"
 
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,18 +31,64 @@ var cidrData string
 
 type DownloadHttpConfig struct {
 	Common.HttpBaseConfig
-	url                   *url.URL
-	RemoteIP              *net.IP
-	RemotePort            int
-	PostBody              string
+	url        *url.URL
+	RemoteIP   *net.IP
+	RemotePort int
+	PostBody   string
+	// BodyFile and BodySize are the other request body sources, a file sent as is or a random payload of BodySize bytes
+	BodyFile string
+	BodySize int64
+	// Upload marks an upload benchmark, the request body is the payload and the response only its acknowledgement
+	Upload bool
+	// Chunked sends the request body with chunked transfer encoding instead of a Content-Length
+	Chunked bool
+	// HTTPVersion is 1.1, 2, 3 or auto, Streams is the number of requests a worker keeps in flight at once,
+	// over HTTP/2 and HTTP/3 they are streams of its connection
+	HTTPVersion           string
+	Streams               int
 	Referer               string
 	XForwardFor           string
 	SingleIpDownloadTimes int
-	DownloadSpeed         int64
-	TotalDownloadedBytes  int64
+	runLimit              *RunLimit
+	runStats              *RunStats
+	retryPolicy           *RetryPolicy
+	// bandwidthLimiter throttles the request and response bodies, nil leaves them uncapped
+	bandwidthLimiter *BandwidthLimiter
+	// schedule paces the requests in open-loop mode, nil runs closed-loop
+	schedule *RequestSchedule
+	// loadProfile limits the requests in flight of all workers to the current stage, nil leaves every worker running
+	loadProfile *LoadProfile
+	// happyEyeballs races the IPv6 RemoteIP against the IPv4 raceIP from raceLocalIP on every connect, nil or a nil
+	// raceIP connects to RemoteIP only. raceShard counts the requests over connections that IPv4 won.
+	happyEyeballs *HappyEyeballs
+	raceIP        *net.IP
+	raceLocalIP   net.IP
+	raceShard     *Metrics.Shard
+	metricsShard  *Metrics.Shard
+	streamTracker *streamTracker
+	// quicStats counts the QUIC handshakes and packets of HTTP/3 downloads and shares their session tickets
+	quicStats *QUICStats
 }
 type DownloadHttpConfigOption func(*DownloadHttpConfig)
 
+// Failure classes of a download request
+const (
+	FailureDNS     = "dns"
+	FailureConnect = "connect"
+	FailureTLS     = "tls"
+	FailureTimeout = "timeout"
+	FailureStatus  = "http_status"
+	FailureBody    = "body"
+	// FailureRequest holds the failures that fit no other class
+	FailureRequest = "request"
+)
+
+// Connection modes, keep-alive reuses the connection of a worker across its requests, new dials one for every request
+const (
+	ConnModeKeepAlive = "keepalive"
+	ConnModeNew       = "new"
+)
+
 func WithUrl(url *url.URL) DownloadHttpConfigOption {
 	return func(config *DownloadHttpConfig) {
 		config.url = url
@@ -53,6 +107,60 @@ func WithRemotePort(port int) DownloadHttpConfigOption {
 	}
 }
 
+func WithHttpBaseConfig(httpBaseConfig Common.HttpBaseConfig) DownloadHttpConfigOption {
+	return func(config *DownloadHttpConfig) {
+		config.HttpBaseConfig = httpBaseConfig
+	}
+}
+
+func WithPostBody(postBody string) DownloadHttpConfigOption {
+	return func(config *DownloadHttpConfig) {
+		config.PostBody = postBody
+	}
+}
+
+func WithBodyFile(bodyFile string) DownloadHttpConfigOption {
+	return func(config *DownloadHttpConfig) {
+		config.BodyFile = bodyFile
+	}
+}
+
+func WithBodySize(bodySize int64) DownloadHttpConfigOption {
+	return func(config *DownloadHttpConfig) {
+		config.BodySize = bodySize
+	}
+}
+
+func WithUpload(upload bool) DownloadHttpConfigOption {
+	return func(config *DownloadHttpConfig) {
+		config.Upload = upload
+	}
+}
+
+func WithChunked(chunked bool) DownloadHttpConfigOption {
+	return func(config *DownloadHttpConfig) {
+		config.Chunked = chunked
+	}
+}
+
+func WithHTTPVersion(httpVersion string) DownloadHttpConfigOption {
+	return func(config *DownloadHttpConfig) {
+		config.HTTPVersion = httpVersion
+	}
+}
+
+func WithStreams(streams int) DownloadHttpConfigOption {
+	return func(config *DownloadHttpConfig) {
+		config.Streams = streams
+	}
+}
+
+func WithQUICStats(quicStats *QUICStats) DownloadHttpConfigOption {
+	return func(config *DownloadHttpConfig) {
+		config.quicStats = quicStats
+	}
+}
+
 func WithReferer(referer string) DownloadHttpConfigOption {
 	return func(config *DownloadHttpConfig) {
 		config.Referer = referer
@@ -71,14 +179,65 @@ func WithSingleIpDownloadTimes(times int) DownloadHttpConfigOption {
 	}
 }
 
+func WithRunLimit(runLimit *RunLimit) DownloadHttpConfigOption {
+	return func(config *DownloadHttpConfig) {
+		config.runLimit = runLimit
+	}
+}
+
+func WithRetryPolicy(retryPolicy *RetryPolicy) DownloadHttpConfigOption {
+	return func(config *DownloadHttpConfig) {
+		config.retryPolicy = retryPolicy
+	}
+}
+
+func WithSchedule(schedule *RequestSchedule) DownloadHttpConfigOption {
+	return func(config *DownloadHttpConfig) {
+		config.schedule = schedule
+	}
+}
+
+func WithLoadProfile(loadProfile *LoadProfile) DownloadHttpConfigOption {
+	return func(config *DownloadHttpConfig) {
+		config.loadProfile = loadProfile
+	}
+}
+
+func WithBandwidthLimiter(bandwidthLimiter *BandwidthLimiter) DownloadHttpConfigOption {
+	return func(config *DownloadHttpConfig) {
+		config.bandwidthLimiter = bandwidthLimiter
+	}
+}
+
+func WithHappyEyeballs(happyEyeballs *HappyEyeballs) DownloadHttpConfigOption {
+	return func(config *DownloadHttpConfig) {
+		config.happyEyeballs = happyEyeballs
+	}
+}
+
+// WithRaceIP makes the worker race its IPv6 remote IP against ipv4, connecting to it from localIP
+func WithRaceIP(ipv4 *net.IP, localIP net.IP) DownloadHttpConfigOption {
+	return func(config *DownloadHttpConfig) {
+		config.raceIP = ipv4
+		config.raceLocalIP = localIP
+	}
+}
+
+func WithRunStats(runStats *RunStats) DownloadHttpConfigOption {
+	return func(config *DownloadHttpConfig) {
+		config.runStats = runStats
+	}
+}
+
 func NewDownloadHttpConfig(opts ...DownloadHttpConfigOption) *DownloadHttpConfig {
 	downloadHttpConfig := &DownloadHttpConfig{
 		HttpBaseConfig:        *Common.NewHttpBaseConfig(),
 		RemotePort:            443,
 		XForwardFor:           Utils.GenerateRandomIPAddress(),
 		SingleIpDownloadTimes: 128,
-		DownloadSpeed:         0,
-		TotalDownloadedBytes:  0,
+		HTTPVersion:           HTTPVersion11,
+		Streams:               1,
+		streamTracker:         newStreamTracker(),
 	}
 	for _, opt := range opts {
 		opt(downloadHttpConfig)
@@ -86,93 +245,462 @@ func NewDownloadHttpConfig(opts ...DownloadHttpConfigOption) *DownloadHttpConfig
 	return downloadHttpConfig
 }
 
-func (downloadHttpConfig *DownloadHttpConfig) DoHttpDownload(wg *sync.WaitGroup) {
-	defer func() {
-		if r := recover(); r != nil {
-			go downloadHttpConfig.DoHttpDownload(wg)
-			log.Fatalln("Recovered in DoHttpDownload", r)
+// ConnMode returns the connection mode that ReuseConn selects
+func (downloadHttpConfig *DownloadHttpConfig) ConnMode() string {
+	if downloadHttpConfig.ReuseConn {
+		return ConnModeKeepAlive
+	}
+	return ConnModeNew
+}
+
+// DoHttpDownload sends up to SingleIpDownloadTimes requests to the remote IP. Failed requests are counted and retried,
+// an error is only returned when no request can be built at all, Supervisor decides what happens to the worker then.
+func (downloadHttpConfig *DownloadHttpConfig) DoHttpDownload(ctx context.Context) error {
+	if downloadHttpConfig.runStats != nil {
+		downloadHttpConfig.metricsShard = downloadHttpConfig.runStats.Shard(downloadHttpConfig.metricsLabels())
+		if downloadHttpConfig.racing() {
+			downloadHttpConfig.raceShard = downloadHttpConfig.runStats.Shard(downloadHttpConfig.raceLabels())
 		}
-	}()
+		downloadHttpConfig.runStats.Collector().WorkerStarted()
+		defer downloadHttpConfig.runStats.Collector().WorkerDone()
+	}
 	log.Infof("Download URL: %s", downloadHttpConfig.url.String())
 	log.Debugf("Download %s started", downloadHttpConfig.RemoteIP.String())
-	for i := 0; i < downloadHttpConfig.SingleIpDownloadTimes; i++ {
-		log.Debugf("Download times: %d ", i+1)
+	// One transport per worker keeps its connection alive across the requests, unless keep-alive is disabled
+	var transport http.RoundTripper
+	if downloadHttpConfig.HTTPVersion == HTTPVersion3 {
+		transport = downloadHttpConfig.createHTTP3Transport()
+	} else {
+		transport = downloadHttpConfig.createTransport()
+	}
+	client := downloadHttpConfig.createHttpClient(transport)
+	defer client.CloseIdleConnections()
+	if downloadHttpConfig.runStats != nil {
+		defer downloadHttpConfig.streamTracker.Flush(downloadHttpConfig.runStats)
+	}
+	var remaining atomic.Int64
+	remaining.Store(int64(downloadHttpConfig.SingleIpDownloadTimes))
+	var err error
+	if downloadHttpConfig.Streams <= 1 {
+		err = downloadHttpConfig.runStream(ctx, client, &remaining)
+	} else {
+		err = downloadHttpConfig.runStreams(ctx, client, &remaining)
+	}
+	if err != nil {
+		return err
+	}
+	log.Infof("Download %s done", downloadHttpConfig.RemoteIP.String())
+	return nil
+}
 
-		transport := downloadHttpConfig.createTransport()
+// runStreams keeps Streams requests in flight until remaining runs out. A panic of a stream is raised again in the
+// worker goroutine, so that the supervisor sees it like the panic of a single stream worker.
+func (downloadHttpConfig *DownloadHttpConfig) runStreams(ctx context.Context, client *http.Client, remaining *atomic.Int64) error {
+	var waitGroup sync.WaitGroup
+	errs := make(chan error, downloadHttpConfig.Streams)
+	panics := make(chan any, downloadHttpConfig.Streams)
+	for stream := 0; stream < downloadHttpConfig.Streams; stream++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					panics <- r
+				}
+			}()
+			errs <- downloadHttpConfig.runStream(ctx, client, remaining)
+		}()
+	}
+	waitGroup.Wait()
+	close(errs)
+	close(panics)
+	if r, ok := <-panics; ok {
+		panic(r)
+	}
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		request := downloadHttpConfig.createHttpRequest()
+// runStream sends requests one after the other while remaining lasts, the streams of a worker share remaining
+func (downloadHttpConfig *DownloadHttpConfig) runStream(ctx context.Context, client *http.Client, remaining *atomic.Int64) error {
+	for remaining.Add(-1) >= 0 {
+		if ctx.Err() != nil {
+			log.Debugf("Download %s cancelled", downloadHttpConfig.RemoteIP.String())
+			return nil
+		}
+		log.Debugf("Download times: %d ", int64(downloadHttpConfig.SingleIpDownloadTimes)-remaining.Load())
+		more, err := downloadHttpConfig.nextRequest(ctx, client)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
 
-		client := downloadHttpConfig.createHttpClient(transport)
+// nextRequest waits for the load profile, the run limit and the open-loop schedule to let the next request through
+// and sends it, it returns false when one of them ends the worker
+func (downloadHttpConfig *DownloadHttpConfig) nextRequest(ctx context.Context, client *http.Client) (bool, error) {
+	if downloadHttpConfig.loadProfile != nil {
+		if !downloadHttpConfig.loadProfile.Acquire(ctx) {
+			log.Debugf("Load profile over, stop downloading from %s", downloadHttpConfig.RemoteIP.String())
+			return false, nil
+		}
+		defer downloadHttpConfig.loadProfile.Release()
+	}
+	if downloadHttpConfig.runLimit != nil && !downloadHttpConfig.runLimit.Acquire() {
+		log.Debugf("Run limit reached, stop downloading from %s", downloadHttpConfig.RemoteIP.String())
+		return false, nil
+	}
+	var scheduledAt time.Time
+	if downloadHttpConfig.schedule != nil {
+		scheduledAt = downloadHttpConfig.schedule.Next()
+		if !sleepUntil(ctx, scheduledAt) {
+			return false, nil
+		}
+	}
+	return true, downloadHttpConfig.doRequestWithRetry(ctx, client, scheduledAt)
+}
 
-		startTime := time.Now() // 记录开始时间
-		response, err := client.Do(request)
+// doRequestWithRetry sends a request and retries it as the retry policy allows, every failed attempt is counted under its class.
+// Attempts cut short by the cancellation of ctx are not counted. Only the errors of building the request are returned.
+// In open-loop mode every attempt is timed from scheduledAt, the time the request was due, so that the latency of a
+// retried request includes its failed attempts and backoffs.
+func (downloadHttpConfig *DownloadHttpConfig) doRequestWithRetry(ctx context.Context, client *http.Client, scheduledAt time.Time) error {
+	maxAttempts := 1
+	if downloadHttpConfig.retryPolicy != nil {
+		maxAttempts = max(downloadHttpConfig.retryPolicy.MaxAttempts, 1)
+	}
+	for attempt := 1; ; attempt++ {
+		err := downloadHttpConfig.doRequest(ctx, client, scheduledAt)
+		if err == nil || ctx.Err() != nil {
+			return nil
+		}
+		var failure *downloadFailure
+		if !errors.As(err, &failure) {
+			downloadHttpConfig.recordFailure(nil, FailureRequest)
+			return err
+		}
+		downloadHttpConfig.recordFailure(failure.conn, failure.class)
+		if attempt >= maxAttempts || !downloadHttpConfig.retryPolicy.Retryable(err) {
+			log.Debugf("Download from %s failed after %d attempts: %s", downloadHttpConfig.RemoteIP.String(), attempt, err)
+			return nil
+		}
+		backoff := downloadHttpConfig.retryPolicy.Backoff(attempt)
+		log.Debugf("Download from %s failed: %s, retrying in %s", downloadHttpConfig.RemoteIP.String(), err, backoff)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		downloadHttpConfig.recordRetry(failure.conn)
+	}
+}
 
-		if err != nil {
-			if response != nil && response.TLS != nil {
-				state := response.TLS
-				// 打印出服务器的证书信息
-				for _, cert := range state.PeerCertificates {
-					log.Debugln("Issuer Name:", cert.Issuer)
-					log.Debugln("Common Name:", cert.Subject.CommonName)
-					log.Debugln("Not Before:", cert.NotBefore)
-					log.Debugln("Not After:", cert.NotAfter)
-					log.Debugln("Signature Algorithm:", cert.SignatureAlgorithm)
-					log.Debugln("Public Key Algorithm:", cert.PublicKeyAlgorithm)
-					log.Debugln("Version:", cert.Version)
-					log.Debugln("Serial Number:", cert.SerialNumber)
-					log.Debugln("-----")
-				}
-			}
-			if response != nil && response.StatusCode != 200 {
-				// 打印响应主体
-				responseBody, _ := io.ReadAll(response.Body)
-				log.Errorln("Response body: ", string(responseBody))
-				break
-			}
-			log.Println("Error in client.Do:", err)
-			break
+// doRequest sends one request and reads its response, a failure is returned as a *downloadFailure
+// and an error building the request as is.
+// The request runs under its own context, cancelled after RequestTimeout or when the body stalls for IdleReadTimeout.
+func (downloadHttpConfig *DownloadHttpConfig) doRequest(ctx context.Context, client *http.Client, scheduledAt time.Time) error {
+	body, contentLength, err := downloadHttpConfig.createRequestBody()
+	if err != nil {
+		return fmt.Errorf("creating the request body: %w", err)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	if downloadHttpConfig.RequestTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, downloadHttpConfig.RequestTimeout, errRequestTimeout)
+		defer cancelTimeout()
+	}
+	requestTiming := NewRequestTiming() // 记录开始时间
+	var uploadCounter *Metrics.CountingReader
+	if body != nil {
+		// The body is sent once the request got its connection
+		uploadCounter = Metrics.NewCountingReader(body, func(n int64) {
+			downloadHttpConfig.recordUploadedBytes(requestTiming.Conn(), n)
+		})
+		body = countingBody{Reader: downloadHttpConfig.throttle(ctx, requestTiming.Conn, uploadCounter, nil), Closer: body}
+	}
+	if !scheduledAt.IsZero() {
+		requestTiming.ScheduledAt(scheduledAt)
+	}
+	// A stream counts as in flight on its connection from getting the connection to the end of its body
+	traceCtx := httptrace.WithClientTrace(httptrace.WithClientTrace(ctx, requestTiming.ClientTrace()), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			downloadHttpConfig.streamTracker.Started(info.Conn)
+		},
+	})
+	var protocol string
+	defer func() {
+		if conn := requestTiming.Conn(); conn != nil {
+			downloadHttpConfig.streamTracker.Done(conn, protocol)
 		}
-		var written int64
-		written, err = io.Copy(io.Discard, response.Body)
-		if err != nil {
-			continue
+	}()
+	request, err := downloadHttpConfig.createHttpRequest(traceCtx, body, contentLength)
+	if err != nil {
+		if body != nil {
+			_ = body.Close()
+		}
+		return fmt.Errorf("creating the request: %w", err)
+	}
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		return &downloadFailure{class: classifyFailure(ctx, err, false), err: err, conn: requestTiming.Conn()}
+	}
+	conn := requestTiming.Conn()
+	downloadHttpConfig.recordStatus(conn, response.StatusCode)
+	requestTiming.Protocol = response.Proto
+	protocol = response.Proto
+	requestTiming.GotResponse()
+	idleReadTimeout := downloadHttpConfig.OrTimeout(downloadHttpConfig.IdleReadTimeout)
+	idleTimer := time.AfterFunc(idleReadTimeout, func() {
+		cancel(errIdleReadTimeout)
+	})
+	responseBody := downloadHttpConfig.throttle(ctx, requestTiming.Conn, Metrics.NewCountingReader(response.Body, func(n int64) {
+		idleTimer.Reset(idleReadTimeout)
+		downloadHttpConfig.recordBytes(conn, n)
+	}), func(waiting bool) {
+		// Waiting for the bandwidth limiter is not an idle read
+		if waiting {
+			idleTimer.Stop()
 		} else {
-			elapsed := time.Since(startTime) // 计算时间差
-			elapsedSeconds := elapsed.Seconds()
-			if elapsedSeconds != 0 {
-				downloadHttpConfig.DownloadSpeed = (written / 1024) / (int64(elapsedSeconds) + 1)
-			} else {
-				downloadHttpConfig.DownloadSpeed = 0 // 或者其他默认值
-			}
-			downloadHttpConfig.TotalDownloadedBytes += written
-			log.Debugf("Download %s %d bytes,took %ss", downloadHttpConfig.RemoteIP.String(), written, elapsed.String())
+			idleTimer.Reset(idleReadTimeout)
 		}
-		err = response.Body.Close()
-		if err != nil {
-			log.Errorln("Error in Body.Close: %s", err)
-			continue
+	})
+	written, err := io.Copy(io.Discard, responseBody)
+	idleTimer.Stop()
+	// The body is closed on read errors too, so that the shared transport can drop or reuse the connection
+	if closeErr := response.Body.Close(); closeErr != nil {
+		log.Errorf("Error in Body.Close: %s", closeErr)
+	}
+	if err != nil {
+		return &downloadFailure{class: classifyFailure(ctx, err, true), err: err, conn: conn}
+	}
+	if response.StatusCode >= http.StatusBadRequest {
+		err = &statusError{statusCode: response.StatusCode}
+		return &downloadFailure{class: FailureStatus, err: err, conn: conn}
+	}
+	requestTiming.Done()
+	var uploaded int64
+	if uploadCounter != nil {
+		uploaded = uploadCounter.Count()
+	}
+	downloadHttpConfig.recordRequest(conn, written, uploaded, requestTiming)
+	elapsed := requestTiming.Total // 计算时间差
+	log.Debugf("Download %s %d bytes,took %s (connect %s, tls %s, ttfb %s, transfer %s, reused %t)",
+		downloadHttpConfig.RemoteIP.String(), written, elapsed.String(), requestTiming.Connect, requestTiming.TLSHandshake,
+		requestTiming.TTFB, requestTiming.Transfer, requestTiming.ConnReused)
+	return nil
+}
+
+// throttle caps reader to the bandwidth of the run and of the local IP, it returns reader as is without a limiter.
+// conn returns the connection of the request, a racing worker only knows its local IP once the request got one,
+// so its limiter is picked on the first read.
+func (downloadHttpConfig *DownloadHttpConfig) throttle(ctx context.Context, conn func() net.Conn, reader io.Reader,
+	onWait func(waiting bool)) io.Reader {
+	if downloadHttpConfig.bandwidthLimiter == nil {
+		return reader
+	}
+	if !downloadHttpConfig.racing() {
+		return downloadHttpConfig.bandwidthLimiter.Reader(ctx, downloadHttpConfig.metricsLabels().LocalIP, reader, onWait)
+	}
+	return &lazyReader{newReader: func() io.Reader {
+		localIP := downloadHttpConfig.metricsLabels().LocalIP
+		if downloadHttpConfig.wonByIPv4(conn()) {
+			localIP = downloadHttpConfig.raceLabels().LocalIP
 		}
+		return downloadHttpConfig.bandwidthLimiter.Reader(ctx, localIP, reader, onWait)
+	}}
+}
+
+// lazyReader reads from the reader newReader returns on the first read
+type lazyReader struct {
+	newReader func() io.Reader
+	reader    io.Reader
+}
+
+func (reader *lazyReader) Read(p []byte) (int, error) {
+	if reader.reader == nil {
+		reader.reader = reader.newReader()
+	}
+	return reader.reader.Read(p)
+}
+
+func (downloadHttpConfig *DownloadHttpConfig) recordBytes(conn net.Conn, written int64) {
+	if downloadHttpConfig.runStats != nil {
+		downloadHttpConfig.runStats.AddBytes(downloadHttpConfig.shard(conn), written)
+	}
+}
+
+func (downloadHttpConfig *DownloadHttpConfig) recordUploadedBytes(conn net.Conn, uploaded int64) {
+	if downloadHttpConfig.runStats != nil {
+		downloadHttpConfig.runStats.AddUploadedBytes(downloadHttpConfig.shard(conn), uploaded)
+	}
+}
+
+func (downloadHttpConfig *DownloadHttpConfig) recordRequest(conn net.Conn, written, uploaded int64, requestTiming *RequestTiming) {
+	if downloadHttpConfig.runStats != nil {
+		downloadHttpConfig.runStats.AddRequest(downloadHttpConfig.shard(conn), written, uploaded, requestTiming)
 	}
-	wg.Done()
-	log.Infof("Download %s done", downloadHttpConfig.RemoteIP.String())
 }
 
-func (downloadHttpConfig *DownloadHttpConfig) createHttpClient(transport *http.Transport) *http.Client {
+func (downloadHttpConfig *DownloadHttpConfig) recordStatus(conn net.Conn, code int) {
+	if downloadHttpConfig.runStats != nil {
+		downloadHttpConfig.shard(conn).AddStatus(code)
+	}
+}
+
+// recordTLS counts the negotiated TLS state of a new connection to remoteAddr
+func (downloadHttpConfig *DownloadHttpConfig) recordTLS(remoteAddr net.Addr, state tls.ConnectionState) {
+	if downloadHttpConfig.runStats != nil {
+		downloadHttpConfig.runStats.AddTLSConnection(remoteAddr, state)
+	}
+}
+
+func (downloadHttpConfig *DownloadHttpConfig) recordRetry(conn net.Conn) {
+	if downloadHttpConfig.runStats != nil {
+		downloadHttpConfig.shard(conn).AddRetry()
+	}
+}
+
+func (downloadHttpConfig *DownloadHttpConfig) recordFailure(conn net.Conn, class string) {
+	if downloadHttpConfig.runStats != nil {
+		downloadHttpConfig.runStats.AddFailure(downloadHttpConfig.shard(conn), class)
+	}
+}
+
+// metricsLabels identifies the counters of this worker by local IP, remote IP and URL
+func (downloadHttpConfig *DownloadHttpConfig) metricsLabels() Metrics.Labels {
+	labels := Metrics.Labels{
+		RemoteIP: downloadHttpConfig.RemoteIP.String(),
+		URL:      downloadHttpConfig.url.String(),
+	}
+	if downloadHttpConfig.LocalIP != nil {
+		labels.LocalIP = downloadHttpConfig.LocalIP.String()
+	}
+	return labels
+}
+
+// raceLabels identifies the counters of the IPv4 side of a racing worker
+func (downloadHttpConfig *DownloadHttpConfig) raceLabels() Metrics.Labels {
+	labels := Metrics.Labels{
+		RemoteIP: downloadHttpConfig.raceIP.String(),
+		URL:      downloadHttpConfig.url.String(),
+	}
+	if downloadHttpConfig.raceLocalIP != nil {
+		labels.LocalIP = downloadHttpConfig.raceLocalIP.String()
+	}
+	return labels
+}
+
+// racing reports whether the worker races IPv6 against IPv4 on every connect
+func (downloadHttpConfig *DownloadHttpConfig) racing() bool {
+	return downloadHttpConfig.happyEyeballs != nil && downloadHttpConfig.raceIP != nil
+}
+
+// wonByIPv4 reports whether conn is the IPv4 winner of a race of the worker, the streams of a worker race independently
+func (downloadHttpConfig *DownloadHttpConfig) wonByIPv4(conn net.Conn) bool {
+	return downloadHttpConfig.racing() && raceFamily(conn) == FamilyIPv4
+}
+
+// shard returns the counters of the remote IP of conn, the IPv4 one for a connection IPv4 won. A request without a
+// connection is counted for RemoteIP.
+func (downloadHttpConfig *DownloadHttpConfig) shard(conn net.Conn) *Metrics.Shard {
+	if downloadHttpConfig.raceShard != nil && downloadHttpConfig.wonByIPv4(conn) {
+		return downloadHttpConfig.raceShard
+	}
+	return downloadHttpConfig.metricsShard
+}
+
+// trackedConn tells the collector when a connection to the download target is closed
+type trackedConn struct {
+	net.Conn
+	closeOnce sync.Once
+	onClose   func()
+}
+
+// NetConn returns the wrapped connection
+func (conn *trackedConn) NetConn() net.Conn {
+	return conn.Conn
+}
+
+func (conn *trackedConn) Close() error {
+	err := conn.Conn.Close()
+	conn.closeOnce.Do(conn.onClose)
+	return err
+}
+
+// trackConn counts conn as open until it is closed
+func (downloadHttpConfig *DownloadHttpConfig) trackConn(conn net.Conn) net.Conn {
+	if downloadHttpConfig.runStats == nil {
+		return conn
+	}
+	collector := downloadHttpConfig.runStats.Collector()
+	collector.ConnOpened()
+	return &trackedConn{
+		Conn:    conn,
+		onClose: collector.ConnClosed,
+	}
+}
+
+func (downloadHttpConfig *DownloadHttpConfig) createHttpClient(transport http.RoundTripper) *http.Client {
+	// RequestTimeout and IdleReadTimeout are applied per request by doRequest, a client timeout would cut off large bodies
 	client := &http.Client{
 		Transport: transport,
-		Timeout:   downloadHttpConfig.Timeout * 2,
 	}
 	return client
 }
 
-func (downloadHttpConfig *DownloadHttpConfig) createHttpRequest() *http.Request {
+// countingBody counts the request body as net/http reads it and closes the underlying body
+type countingBody struct {
+	io.Reader
+	io.Closer
+}
+
+// createRequestBody opens the body of the next request from PostBody, BodyFile or BodySize in that order,
+// the body is nil when none of them is set
+func (downloadHttpConfig *DownloadHttpConfig) createRequestBody() (io.ReadCloser, int64, error) {
+	switch {
+	case downloadHttpConfig.PostBody != "":
+		return io.NopCloser(strings.NewReader(downloadHttpConfig.PostBody)), int64(len(downloadHttpConfig.PostBody)), nil
+	case downloadHttpConfig.BodyFile != "":
+		file, err := os.Open(downloadHttpConfig.BodyFile)
+		if err != nil {
+			return nil, 0, err
+		}
+		fileInfo, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			return nil, 0, err
+		}
+		return file, fileInfo.Size(), nil
+	case downloadHttpConfig.BodySize > 0:
+		return io.NopCloser(Utils.NewRandomPayload(downloadHttpConfig.BodySize)), downloadHttpConfig.BodySize, nil
+	default:
+		return nil, 0, nil
+	}
+}
+
+func (downloadHttpConfig *DownloadHttpConfig) createHttpRequest(ctx context.Context, body io.ReadCloser, contentLength int64) (*http.Request, error) {
 	var request *http.Request
 	var requestErr error
-	request, requestErr = http.NewRequest("GET", downloadHttpConfig.url.String(), nil)
+	request, requestErr = http.NewRequestWithContext(ctx, downloadHttpConfig.HTTPMethod, downloadHttpConfig.url.String(), body)
 	if requestErr != nil {
-		log.Fatalf("Error creating new request: %s", requestErr)
-		return nil
+		return nil, requestErr
 	} else {
+		if body != nil {
+			request.ContentLength = contentLength
+			if downloadHttpConfig.Chunked {
+				// An unknown length makes net/http send the body chunked
+				request.ContentLength = -1
+			}
+			request.Header.Set("Content-Type", "application/octet-stream")
+		}
 		request.Header.Add("Cookie", Utils.GenerateRRandStringBytesMaskImper(12))
 		request.Header.Add("User-Agent", downloadHttpConfig.HttpBaseConfig.HTTPUserAgent)
 		request.Header.Add("Referer", downloadHttpConfig.Referer)
@@ -181,13 +709,16 @@ func (downloadHttpConfig *DownloadHttpConfig) createHttpRequest() *http.Request
 			request.Header.Add("X-Real-IP", downloadHttpConfig.XForwardFor)
 		}
 		request.Host = downloadHttpConfig.url.Host
+		if downloadHttpConfig.HTTPVersion == HTTPVersion3 {
+			request.Method = zeroRTTMethod(request.Method, body != nil)
+		}
 	}
-	return request
+	return request, nil
 }
 
 func (downloadHttpConfig *DownloadHttpConfig) createTransport() *http.Transport {
 	dialer := &net.Dialer{
-		Timeout:   30 * time.Second,
+		Timeout:   downloadHttpConfig.OrTimeout(downloadHttpConfig.ConnectTimeout),
 		KeepAlive: 30 * time.Second,
 	}
 	if downloadHttpConfig.HttpBaseConfig.LocalIP != nil {
@@ -196,10 +727,14 @@ func (downloadHttpConfig *DownloadHttpConfig) createTransport() *http.Transport
 		}
 	}
 
+	tlsHandshakeTimeout := downloadHttpConfig.OrTimeout(downloadHttpConfig.TLSHandshakeTimeout)
+	// The connections of a worker share their session tickets, so that a new connection can resume the session of
+	// an earlier one to the same server. The utls dialer of the redirects to https keeps its own cache.
 	tlsConfig := &tls.Config{
-		InsecureSkipVerify:     true,
-		SessionTicketsDisabled: true,
+		InsecureSkipVerify: true,
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
 	}
+	uTLSSessionCache := utls.NewLRUClientSessionCache(0)
 
 	transport := &http.Transport{
 		Proxy:                 nil,
@@ -207,48 +742,83 @@ func (downloadHttpConfig *DownloadHttpConfig) createTransport() *http.Transport
 		MaxIdleConns:          downloadHttpConfig.SingleIpDownloadTimes + 16,
 		MaxIdleConnsPerHost:   downloadHttpConfig.SingleIpDownloadTimes * 2,
 		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: downloadHttpConfig.OrTimeout(downloadHttpConfig.ResponseHeaderTimeout),
 		ExpectContinueTimeout: 1 * time.Second,
-		DisableKeepAlives:     false,
+		DisableKeepAlives:     !downloadHttpConfig.ReuseConn,
 		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			// Override the addr with your own remote IP and port
-			addr = net.JoinHostPort(downloadHttpConfig.RemoteIP.String(), strconv.Itoa(downloadHttpConfig.RemotePort))
-			return dialer.DialContext(ctx, network, addr)
+			return downloadHttpConfig.dialRemote(ctx, dialer, network)
 		},
+		// An http URL that redirects to https reaches this dialer, it connects to the host of the redirect and not to
+		// the remote IP of the worker, which only serves the http port
 		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
 			// 创建一个utls.Config对象
 			config := &utls.Config{
-				InsecureSkipVerify:     true,
-				SessionTicketsDisabled: true,
+				InsecureSkipVerify: true,
+				ClientSessionCache: uTLSSessionCache,
 			}
 			// 创建一个普通的net.Conn
-			conn, err := net.Dial("tcp", addr)
+			conn, err := dialer.DialContext(ctx, network, addr)
 			if err != nil {
 				return nil, err
 			}
+			conn = downloadHttpConfig.trackConn(conn)
 			// 创建一个utls.UClient对象，使用HelloChrome_Auto指纹
 			uConn := utls.UClient(conn, config, utls.HelloChrome_Auto)
 
 			// 执行TLS握手
-			err = uConn.Handshake()
+			handshakeCtx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+			defer cancel()
+			err = uConn.HandshakeContext(handshakeCtx)
 			if err != nil {
 				log.Error("uConn.Handshake error: ", err)
+				_ = conn.Close()
 				return nil, err
 			}
+			downloadHttpConfig.recordTLS(conn.RemoteAddr(), uTLSConnectionState(uConn.ConnectionState()))
 			return uConn, nil
 		},
 	}
 	if downloadHttpConfig.url.Scheme == "https" {
 		transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-			// Override the addr with your own remote IP and port
-			addr = net.JoinHostPort(downloadHttpConfig.RemoteIP.String(), strconv.Itoa(downloadHttpConfig.RemotePort))
-			return tls.DialWithDialer(dialer, network, addr, tlsConfig)
+			conn, err := downloadHttpConfig.dialRemote(ctx, dialer, network)
+			if err != nil {
+				return nil, err
+			}
+			conn = downloadHttpConfig.trackConn(conn)
+			tlsConn := tls.Client(conn, tlsConfig)
+			// net/http leaves the handshake timeout of a custom DialTLSContext to the dialer
+			handshakeCtx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+			defer cancel()
+			err = tlsHandshakeWithTrace(httptrace.ContextClientTrace(ctx), func() error {
+				return tlsConn.HandshakeContext(handshakeCtx)
+			}, tlsConn.ConnectionState)
+			if err == nil {
+				downloadHttpConfig.recordTLS(conn.RemoteAddr(), tlsConn.ConnectionState())
+				err = checkNegotiatedProtocol(downloadHttpConfig.HTTPVersion, tlsConn.ConnectionState())
+			}
+			if err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		}
+		if downloadHttpConfig.HTTPVersion == HTTPVersion2 || downloadHttpConfig.HTTPVersion == HTTPVersionAuto {
+			// net/http hands the connections that negotiated h2 to the HTTP/2 transport, a worker with several
+			// streams multiplexes them over one connection up to the limit of the server
+			if _, err := http2.ConfigureTransports(transport); err != nil {
+				log.Errorf("Error configuring HTTP/2: %s", err)
+			}
 		}
+		// ConfigureTransports offers h2 and http/1.1, the version decides
+		tlsConfig.NextProtos = nextProtos(downloadHttpConfig.HTTPVersion)
 	} else {
 		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-			// Override the addr with your own remote IP and port
-			addr = net.JoinHostPort(downloadHttpConfig.RemoteIP.String(), strconv.Itoa(downloadHttpConfig.RemotePort))
-			return dialer.DialContext(ctx, network, addr)
+			conn, err := downloadHttpConfig.dialRemote(ctx, dialer, network)
+			if err != nil {
+				return nil, err
+			}
+			return downloadHttpConfig.trackConn(conn), nil
 		}
 	}
 	log.Debugln("CipherSuites:", transport.TLSClientConfig.CipherSuites)
@@ -258,3 +828,28 @@ func (downloadHttpConfig *DownloadHttpConfig) createTransport() *http.Transport
 	log.Debugln("NextProtos:", transport.TLSClientConfig.NextProtos)
 	return transport
 }
+
+// dialRemote connects to the remote IP and port of the worker instead of the address of the URL. A racing worker
+// connects to the winner of a happy eyeballs race between its IPv6 and IPv4 remote IPs.
+func (downloadHttpConfig *DownloadHttpConfig) dialRemote(ctx context.Context, dialer *net.Dialer, network string) (net.Conn, error) {
+	port := strconv.Itoa(downloadHttpConfig.RemotePort)
+	if !downloadHttpConfig.racing() {
+		return dialer.DialContext(ctx, network, net.JoinHostPort(downloadHttpConfig.RemoteIP.String(), port))
+	}
+	ipv4Dialer := *dialer
+	ipv4Dialer.LocalAddr = nil
+	if downloadHttpConfig.raceLocalIP != nil {
+		ipv4Dialer.LocalAddr = &net.TCPAddr{IP: downloadHttpConfig.raceLocalIP}
+	}
+	conn, remoteIP, err := downloadHttpConfig.happyEyeballs.Race(ctx, *downloadHttpConfig.RemoteIP, *downloadHttpConfig.raceIP,
+		func(ctx context.Context, remoteIP net.IP) (net.Conn, error) {
+			if remoteIP.To4() != nil {
+				return ipv4Dialer.DialContext(ctx, network, net.JoinHostPort(remoteIP.String(), port))
+			}
+			return dialer.DialContext(ctx, network, net.JoinHostPort(remoteIP.String(), port))
+		})
+	if err != nil {
+		return nil, err
+	}
+	return &raceConn{Conn: conn, family: ipFamily(remoteIP)}, nil
+}
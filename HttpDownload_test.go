@@ -0,0 +1,251 @@
+package main
+
+import (
+	"HttpBenchmark/Common"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestBody(t *testing.T) {
+	type received struct {
+		method        string
+		contentLength int64
+		contentType   string
+		body          []byte
+	}
+	requests := make(chan received, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{r.Method, r.ContentLength, r.Header.Get("Content-Type"), body}
+	}))
+	defer server.Close()
+
+	bodyFile := filepath.Join(t.TempDir(), "body")
+	assert.Nil(t, os.WriteFile(bodyFile, []byte("file body"), 0o600))
+
+	tests := []struct {
+		name     string
+		method   string
+		options  []DownloadHttpConfigOption
+		wantBody string
+		wantSize int64
+	}{
+		{name: "get", method: http.MethodGet},
+		{name: "post body", method: http.MethodPost, options: []DownloadHttpConfigOption{WithPostBody("a=1")}, wantBody: "a=1", wantSize: 3},
+		{name: "body file", method: http.MethodPut, options: []DownloadHttpConfigOption{WithBodyFile(bodyFile)}, wantBody: "file body", wantSize: 9},
+		{name: "random body", method: http.MethodPost, options: []DownloadHttpConfigOption{WithBodySize(200 * 1024)}, wantSize: 200 * 1024},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			downloadHttpConfig := newTestDownloadHttpConfig(t, server)
+			downloadHttpConfig.HTTPMethod = test.method
+			for _, opt := range test.options {
+				opt(downloadHttpConfig)
+			}
+			body, contentLength, err := downloadHttpConfig.createRequestBody()
+			assert.Nil(t, err)
+			assert.Equal(t, test.wantSize, contentLength)
+			client := downloadHttpConfig.createHttpClient(downloadHttpConfig.createTransport())
+			request, err := downloadHttpConfig.createHttpRequest(context.Background(), body, contentLength)
+			assert.Nil(t, err)
+			response, err := client.Do(request)
+			assert.Nil(t, err)
+			_ = response.Body.Close()
+
+			got := <-requests
+			assert.Equal(t, test.method, got.method)
+			assert.Equal(t, test.wantSize, int64(len(got.body)))
+			if test.wantSize == 0 {
+				assert.Empty(t, got.contentType)
+				return
+			}
+			assert.Equal(t, test.wantSize, got.contentLength)
+			assert.Equal(t, "application/octet-stream", got.contentType)
+			if test.wantBody != "" {
+				assert.Equal(t, test.wantBody, string(got.body))
+			}
+		})
+	}
+}
+
+func TestRequestBodyFileMissing(t *testing.T) {
+	downloadHttpConfig := NewDownloadHttpConfig(WithBodyFile(filepath.Join(t.TempDir(), "missing")))
+	_, _, err := downloadHttpConfig.createRequestBody()
+	assert.NotNil(t, err)
+}
+
+func TestUploadChunked(t *testing.T) {
+	transferEncodings := make(chan []string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		transferEncodings <- r.TransferEncoding
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	runStats := NewRunStats()
+	downloadHttpConfig := newTestDownloadHttpConfig(t, server)
+	downloadHttpConfig.HTTPMethod = http.MethodPut
+	for _, opt := range []DownloadHttpConfigOption{WithRunStats(runStats), WithSingleIpDownloadTimes(2), WithUpload(true),
+		WithChunked(true), WithBodySize(100 * 1024)} {
+		opt(downloadHttpConfig)
+	}
+	assert.Nil(t, downloadHttpConfig.DoHttpDownload(context.Background()))
+
+	assert.Equal(t, []string{"chunked"}, <-transferEncodings)
+	assert.Equal(t, []string{"chunked"}, <-transferEncodings)
+	snapshot := runStats.Collector().Snapshot()
+	assert.Equal(t, int64(2), snapshot.Total.Requests)
+	assert.Equal(t, int64(200*1024), snapshot.Total.UploadedBytes)
+	assert.Equal(t, int64(4), snapshot.Total.Bytes)
+	assert.Equal(t, int64(2), runStats.phaseHistograms[PhaseUpload].Snapshot().Count)
+	assert.Equal(t, int64(2), runStats.uploadThroughputHistogram.Snapshot().Count)
+	assert.Contains(t, runStats.Summary(), "Total uploaded: 200.00KiB")
+}
+
+func TestConnModes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 1024))
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		reuseConn  bool
+		connMode   string
+		wantReused int64
+	}{
+		{reuseConn: true, connMode: ConnModeKeepAlive, wantReused: 3},
+		{reuseConn: false, connMode: ConnModeNew, wantReused: 0},
+	} {
+		t.Run(test.connMode, func(t *testing.T) {
+			runStats := NewRunStats()
+			downloadHttpConfig := newTestDownloadHttpConfig(t, server)
+			downloadHttpConfig.ReuseConn = test.reuseConn
+			WithRunStats(runStats)(downloadHttpConfig)
+			WithSingleIpDownloadTimes(4)(downloadHttpConfig)
+			assert.Equal(t, test.connMode, downloadHttpConfig.ConnMode())
+			assert.Nil(t, downloadHttpConfig.DoHttpDownload(context.Background()))
+
+			snapshot := runStats.Collector().Snapshot()
+			assert.Equal(t, int64(4), snapshot.Total.Requests)
+			assert.Equal(t, test.wantReused, snapshot.Total.ReusedConns)
+			// The worker closes its transport when it is done, no connection is left open
+			assert.Equal(t, int64(0), snapshot.OpenConns)
+		})
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/headers" {
+			<-release
+			return
+		}
+		// Send part of the body, then stall
+		_, _ = w.Write(make([]byte, 1024))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	for _, test := range []struct {
+		name      string
+		path      string
+		options   []Common.HttpBaseConfigOption
+		wantClass string
+	}{
+		{name: "response header", path: "/headers", options: []Common.HttpBaseConfigOption{Common.WithResponseHeaderTimeout(100 * time.Millisecond)}, wantClass: FailureTimeout},
+		{name: "idle read", path: "/body", options: []Common.HttpBaseConfigOption{Common.WithIdleReadTimeout(100 * time.Millisecond)}, wantClass: FailureTimeout},
+		{name: "request", path: "/body", options: []Common.HttpBaseConfigOption{Common.WithRequestTimeout(100 * time.Millisecond)}, wantClass: FailureTimeout},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			runStats := NewRunStats()
+			downloadHttpConfig := newTestDownloadHttpConfig(t, server)
+			downloadHttpConfig.url.Path = test.path
+			downloadHttpConfig.Timeout = time.Minute
+			for _, opt := range test.options {
+				opt(&downloadHttpConfig.HttpBaseConfig)
+			}
+			WithRunStats(runStats)(downloadHttpConfig)
+			WithSingleIpDownloadTimes(1)(downloadHttpConfig)
+			start := time.Now()
+			assert.Nil(t, downloadHttpConfig.DoHttpDownload(context.Background()))
+
+			assert.Less(t, time.Since(start), 10*time.Second)
+			assert.Equal(t, map[string]int64{test.wantClass: 1}, runStats.Collector().Snapshot().Total.FailureClasses)
+		})
+	}
+}
+
+func TestTLSHandshakeHonoursContext(t *testing.T) {
+	// The listener accepts the connection but never answers the client hello
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	remoteIP := net.ParseIP("127.0.0.1")
+	downloadHttpConfig := NewDownloadHttpConfig(WithUrl(&url.URL{Scheme: "https", Host: "download.invalid"}), WithRemoteIP(&remoteIP),
+		WithRemotePort(listener.Addr().(*net.TCPAddr).Port))
+	downloadHttpConfig.Timeout = time.Minute
+	transport := downloadHttpConfig.createTransport()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = transport.DialTLSContext(ctx, "tcp", "download.invalid:443")
+	assert.NotNil(t, err)
+	assert.Less(t, time.Since(start), 10*time.Second)
+
+	// The handshake timeout applies without a context deadline
+	downloadHttpConfig.TLSHandshakeTimeout = 100 * time.Millisecond
+	start = time.Now()
+	_, err = downloadHttpConfig.createTransport().DialTLSContext(context.Background(), "tcp", "download.invalid:443")
+	assert.NotNil(t, err)
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 1024))
+	}))
+	defer tlsServer.Close()
+	server := httptest.NewServer(http.RedirectHandler(tlsServer.URL+"/download", http.StatusFound))
+	defer server.Close()
+
+	// The worker is pinned to the http server, the redirect goes to the host and port of the https one
+	runStats := NewRunStats()
+	downloadHttpConfig := newTestDownloadHttpConfig(t, server)
+	WithRunStats(runStats)(downloadHttpConfig)
+	WithSingleIpDownloadTimes(1)(downloadHttpConfig)
+	assert.Nil(t, downloadHttpConfig.DoHttpDownload(context.Background()))
+
+	total := runStats.Collector().Snapshot().Total
+	assert.Equal(t, int64(1), total.Requests)
+	assert.Equal(t, int64(1024), total.Bytes)
+	assert.Empty(t, total.FailureClasses)
+	// The TLS connection of the redirect is recorded like those of an https worker
+	tlsSessions := runStats.TLSSessions()
+	assert.Len(t, tlsSessions, 1)
+	assert.Equal(t, "127.0.0.1", tlsSessions[0].RemoteIP)
+	assert.Equal(t, int64(1), tlsSessions[0].Connections)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"HttpBenchmark/Metrics"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// HTTP versions of the downloads, auto offers both over ALPN and takes what the server picks
+const (
+	HTTPVersion11   = "1.1"
+	HTTPVersion2    = "2"
+	HTTPVersionAuto = "auto"
+)
+
+// nextProtos returns the ALPN protocols offered for httpVersion, HTTP/1.1 offers none like a plain TLS client
+func nextProtos(httpVersion string) []string {
+	switch httpVersion {
+	case HTTPVersion2:
+		return []string{"h2"}
+	case HTTPVersionAuto:
+		return []string{"h2", "http/1.1"}
+	default:
+		return nil
+	}
+}
+
+// checkNegotiatedProtocol fails a connection that did not negotiate h2 when HTTP/2 is required
+func checkNegotiatedProtocol(httpVersion string, state tls.ConnectionState) error {
+	if httpVersion == HTTPVersion2 && state.NegotiatedProtocol != "h2" {
+		return fmt.Errorf("the server did not negotiate h2 but %q", state.NegotiatedProtocol)
+	}
+	return nil
+}
+
+// ProtocolStats are the requests and connections of one negotiated protocol like HTTP/1.1 or HTTP/2.0
+type ProtocolStats struct {
+	requests    atomic.Int64
+	bytes       atomic.Int64
+	connections atomic.Int64
+	// connectionRequests is the number of requests of the connections in connections
+	connectionRequests atomic.Int64
+	// peakStreams holds the most streams in flight at once on each connection
+	peakStreams *Metrics.Histogram
+	// streamThroughput holds the throughput of every request, a stream of its connection, in bytes per second
+	streamThroughput *Metrics.Histogram
+}
+
+func newProtocolStats() *ProtocolStats {
+	return &ProtocolStats{
+		peakStreams:      Metrics.NewHistogram(),
+		streamThroughput: Metrics.NewHistogram(),
+	}
+}
+
+// StreamsPerConnection returns the average number of requests a connection carried, 0 before a connection is counted
+func (protocolStats *ProtocolStats) StreamsPerConnection() float64 {
+	connections := protocolStats.connections.Load()
+	if connections == 0 {
+		return 0
+	}
+	return float64(protocolStats.connectionRequests.Load()) / float64(connections)
+}
+
+// protocolStats returns the counters of protocol, creating them on first use
+func (runStats *RunStats) protocolStats(protocol string) *ProtocolStats {
+	runStats.mutex.Lock()
+	defer runStats.mutex.Unlock()
+	protocolStats, ok := runStats.protocols[protocol]
+	if !ok {
+		protocolStats = newProtocolStats()
+		runStats.protocols[protocol] = protocolStats
+	}
+	return protocolStats
+}
+
+// Protocols returns the negotiated protocols seen so far in order
+func (runStats *RunStats) Protocols() []string {
+	runStats.mutex.Lock()
+	defer runStats.mutex.Unlock()
+	protocols := make([]string, 0, len(runStats.protocols))
+	for protocol := range runStats.protocols {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+	return protocols
+}
+
+// AddConnection counts a connection of protocol that carried requests, at most peak of them at once
+func (runStats *RunStats) AddConnection(protocol string, requests, peak int) {
+	protocolStats := runStats.protocolStats(protocol)
+	protocolStats.connections.Add(1)
+	protocolStats.connectionRequests.Add(int64(requests))
+	protocolStats.peakStreams.Record(int64(peak))
+}
+
+// connStreams counts the streams of one connection of a worker
+type connStreams struct {
+	protocol string
+	active   int
+	peak     int
+	requests int
+}
+
+// streamTracker follows the streams on the connections of a worker, so that the streams per connection are known
+type streamTracker struct {
+	mutex sync.Mutex
+	conns map[net.Conn]*connStreams
+}
+
+func newStreamTracker() *streamTracker {
+	return &streamTracker{
+		conns: make(map[net.Conn]*connStreams),
+	}
+}
+
+// Started counts a stream on conn
+func (tracker *streamTracker) Started(conn net.Conn) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	streams, ok := tracker.conns[conn]
+	if !ok {
+		streams = &connStreams{}
+		tracker.conns[conn] = streams
+	}
+	streams.active++
+	streams.requests++
+	streams.peak = max(streams.peak, streams.active)
+}
+
+// Done marks a stream on conn as finished, protocol is the protocol of its response and empty when it failed before one
+func (tracker *streamTracker) Done(conn net.Conn, protocol string) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if streams, ok := tracker.conns[conn]; ok {
+		streams.active--
+		if streams.protocol == "" {
+			streams.protocol = protocol
+		}
+	}
+}
+
+// Flush adds the connections of the worker to runStats, it is called once the worker is done
+func (tracker *streamTracker) Flush(runStats *RunStats) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	for conn, streams := range tracker.conns {
+		// A connection without a single response has no protocol to count it under
+		if streams.protocol != "" {
+			runStats.AddConnection(streams.protocol, streams.requests, streams.peak)
+		}
+		delete(tracker.conns, conn)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newHTTP2TestServer(handler http.Handler) *httptest.Server {
+	server := httptest.NewUnstartedServer(handler)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	return server
+}
+
+func TestHTTP2Streams(t *testing.T) {
+	const streams = 4
+	// The first requests wait for each other, so that the streams are in flight on the connection at once
+	var waitGroup sync.WaitGroup
+	waitGroup.Add(streams)
+	server := newHTTP2TestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		waitGroup.Done()
+		done := make(chan struct{})
+		go func() {
+			waitGroup.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+		}
+		_, _ = w.Write(make([]byte, 64*1024))
+	}))
+	defer server.Close()
+
+	runStats := NewRunStats()
+	downloadHttpConfig := newTestDownloadHttpConfig(t, server)
+	downloadHttpConfig.ReuseConn = true
+	WithRunStats(runStats)(downloadHttpConfig)
+	WithHTTPVersion(HTTPVersion2)(downloadHttpConfig)
+	WithStreams(streams)(downloadHttpConfig)
+	WithSingleIpDownloadTimes(streams)(downloadHttpConfig)
+	assert.Nil(t, downloadHttpConfig.DoHttpDownload(context.Background()))
+
+	report := runStats.Report(ReportConfig{}, StopReasonLimit)
+	assert.Equal(t, int64(streams), report.Totals.Requests)
+	assert.Len(t, report.Protocols, 1)
+	protocol := report.Protocols[0]
+	assert.Equal(t, "HTTP/2.0", protocol.Name)
+	assert.Equal(t, int64(streams), protocol.Requests)
+	// All streams share a single connection
+	assert.Equal(t, int64(1), protocol.Connections)
+	assert.Equal(t, float64(streams), protocol.StreamsPerConnection)
+	assert.Equal(t, float64(streams), protocol.PeakStreams.Max)
+	assert.Equal(t, int64(streams), protocol.StreamThroughput.Count)
+	assert.Contains(t, runStats.Summary(), "Protocols: HTTP/2.0 4 requests over 1 connections (4.0 streams per connection, peak 4)")
+}
+
+func TestHTTPVersionNegotiation(t *testing.T) {
+	h2Server := newHTTP2TestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 1024))
+	}))
+	defer h2Server.Close()
+	h1Server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 1024))
+	}))
+	defer h1Server.Close()
+
+	for _, test := range []struct {
+		name         string
+		server       *httptest.Server
+		httpVersion  string
+		wantProtocol string
+	}{
+		{name: "1.1 against h2", server: h2Server, httpVersion: HTTPVersion11, wantProtocol: "HTTP/1.1"},
+		{name: "auto against h2", server: h2Server, httpVersion: HTTPVersionAuto, wantProtocol: "HTTP/2.0"},
+		{name: "auto against h1", server: h1Server, httpVersion: HTTPVersionAuto, wantProtocol: "HTTP/1.1"},
+		{name: "2 against h1", server: h1Server, httpVersion: HTTPVersion2},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			runStats := NewRunStats()
+			downloadHttpConfig := newTestDownloadHttpConfig(t, test.server)
+			WithRunStats(runStats)(downloadHttpConfig)
+			WithHTTPVersion(test.httpVersion)(downloadHttpConfig)
+			WithSingleIpDownloadTimes(2)(downloadHttpConfig)
+			assert.Nil(t, downloadHttpConfig.DoHttpDownload(context.Background()))
+
+			snapshot := runStats.Collector().Snapshot()
+			if test.wantProtocol == "" {
+				// A server without h2 fails the handshake of a run that requires HTTP/2
+				assert.Equal(t, int64(0), snapshot.Total.Requests)
+				assert.Equal(t, int64(2), snapshot.Total.FailureClasses[FailureTLS])
+				return
+			}
+			assert.Equal(t, []string{test.wantProtocol}, runStats.Protocols())
+			assert.Equal(t, int64(2), snapshot.Total.Requests)
+		})
+	}
+}
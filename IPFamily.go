@@ -0,0 +1,128 @@
+package main
+
+import (
+	"HttpBenchmark/Metrics"
+	"HttpBenchmark/Utils"
+	_ "embed"
+	"net"
+)
+
+//go:embed Utils/all_cn_cidr6.txt
+var cidr6Data string
+
+// IP families of a run, dual resolves and downloads over both
+const (
+	IPFamily4    = "4"
+	IPFamily6    = "6"
+	IPFamilyDual = "dual"
+)
+
+// Names of the IP families in the report
+const (
+	FamilyIPv4 = "IPv4"
+	FamilyIPv6 = "IPv6"
+)
+
+// ipv6ClientSubnetLen is the length of the IPv6 client subnets, resolvers use at most a /56 and the pools are far wider
+const ipv6ClientSubnetLen = 48
+
+// ipFamily returns the family name of ip
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return FamilyIPv4
+	}
+	return FamilyIPv6
+}
+
+// familyIncludes reports whether the IP family of a run includes ip
+func familyIncludes(family string, ip net.IP) bool {
+	switch family {
+	case IPFamily4:
+		return ip.To4() != nil
+	case IPFamily6:
+		return ip.To4() == nil
+	default:
+		return true
+	}
+}
+
+// dnsTypes returns the record types that resolve the download targets of family
+func dnsTypes(family string) []string {
+	switch family {
+	case IPFamily6:
+		return []string{"AAAA"}
+	case IPFamilyDual:
+		return []string{"A", "AAAA"}
+	default:
+		return []string{"A"}
+	}
+}
+
+// filterIPFamily keeps the IPs of family
+func filterIPFamily(ips []*net.IP, family string) []*net.IP {
+	var filtered []*net.IP
+	for _, ip := range ips {
+		if familyIncludes(family, *ip) {
+			filtered = append(filtered, ip)
+		}
+	}
+	return filtered
+}
+
+// clientSubnets picks count random EDNS client subnets from the pools of family, a dual run alternates between
+// IPv4 and IPv6 subnets
+func clientSubnets(family string, count int) ([]string, error) {
+	switch family {
+	case IPFamily6:
+		return ipv6ClientSubnets(count)
+	case IPFamilyDual:
+		ipv4Subnets, err := Utils.GetIpSubnetFromEmbedFile(cidrData, count-count/2)
+		if err != nil {
+			return nil, err
+		}
+		ipv6Subnets, err := ipv6ClientSubnets(count / 2)
+		if err != nil {
+			return nil, err
+		}
+		subnets := make([]string, 0, len(ipv4Subnets)+len(ipv6Subnets))
+		for i := 0; i < max(len(ipv4Subnets), len(ipv6Subnets)); i++ {
+			if i < len(ipv4Subnets) {
+				subnets = append(subnets, ipv4Subnets[i])
+			}
+			if i < len(ipv6Subnets) {
+				subnets = append(subnets, ipv6Subnets[i])
+			}
+		}
+		return subnets, nil
+	default:
+		return Utils.GetIpSubnetFromEmbedFile(cidrData, count)
+	}
+}
+
+// ipv6ClientSubnets picks count random /48 networks out of the IPv6 pools, the pools may repeat
+func ipv6ClientSubnets(count int) ([]string, error) {
+	pools, err := Utils.GetIpSubnetFromEmbedFile(cidr6Data, count)
+	if err != nil {
+		return nil, err
+	}
+	subnets := make([]string, 0, count)
+	for i := 0; len(pools) > 0 && i < count; i++ {
+		subnet, err := Utils.RandomSubnet(pools[i%len(pools)], ipv6ClientSubnetLen)
+		if err != nil {
+			return nil, err
+		}
+		subnets = append(subnets, subnet)
+	}
+	return subnets, nil
+}
+
+// familySnapshot keeps the shards of snapshot whose remote IP is of the family name, FamilyIPv4 or FamilyIPv6
+func familySnapshot(snapshot Metrics.Snapshot, family string) Metrics.Snapshot {
+	var filtered Metrics.Snapshot
+	for _, shard := range snapshot.Shards {
+		if ip := net.ParseIP(shard.Labels.RemoteIP); ip != nil && ipFamily(ip) == family {
+			filtered.Shards = append(filtered.Shards, shard)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterIPFamily(t *testing.T) {
+	ipv4, ipv6 := net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")
+	ips := []*net.IP{&ipv4, &ipv6}
+	assert.Equal(t, []*net.IP{&ipv4}, filterIPFamily(ips, IPFamily4))
+	assert.Equal(t, []*net.IP{&ipv6}, filterIPFamily(ips, IPFamily6))
+	assert.Equal(t, ips, filterIPFamily(ips, IPFamilyDual))
+	assert.Equal(t, []string{"A"}, dnsTypes(IPFamily4))
+	assert.Equal(t, []string{"AAAA"}, dnsTypes(IPFamily6))
+	assert.Equal(t, []string{"A", "AAAA"}, dnsTypes(IPFamilyDual))
+}
+
+func TestClientSubnets(t *testing.T) {
+	subnets, err := clientSubnets(IPFamily6, 4)
+	assert.Nil(t, err)
+	assert.Len(t, subnets, 4)
+	for _, subnet := range subnets {
+		ip, ipNet, err := net.ParseCIDR(subnet)
+		assert.Nil(t, err, subnet)
+		assert.Nil(t, ip.To4(), subnet)
+		ones, _ := ipNet.Mask.Size()
+		assert.Equal(t, ipv6ClientSubnetLen, ones)
+	}
+
+	subnets, err = clientSubnets(IPFamilyDual, 4)
+	assert.Nil(t, err)
+	assert.Len(t, subnets, 4)
+	// The families alternate, so that every batch of workers resolves over both
+	for i, subnet := range subnets {
+		assert.Equal(t, i%2 == 1, strings.Contains(subnet, ":"), subnet)
+	}
+}
+
+func TestLocalIPPickerMatchesFamily(t *testing.T) {
+	picker := NewLocalIPPicker([]LocalAddress{{IP: net.ParseIP("10.0.0.2"), Weight: 1}, {IP: net.ParseIP("2001:db8::2"), Weight: 1},
+		{IP: net.ParseIP("2001:db8::3"), Weight: 1}})
+	ipv4, ipv6 := net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")
+	assert.Equal(t, "10.0.0.2", picker.Next(ipv4).String())
+	assert.Equal(t, "2001:db8::2", picker.Next(ipv6).String())
+	assert.Equal(t, "2001:db8::3", picker.Next(ipv6).String())
+	assert.Equal(t, "10.0.0.2", picker.First(FamilyIPv4).String())
+	assert.Nil(t, NewLocalIPPicker([]LocalAddress{{IP: net.ParseIP("10.0.0.2"), Weight: 1}}).Next(ipv6))
+}
+
+func TestReportComparesIPFamilies(t *testing.T) {
+	ipv4, ipv6 := net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")
+	picker := NewLocalIPPicker([]LocalAddress{{IP: net.ParseIP("10.0.0.2"), Weight: 1}, {IP: net.ParseIP("2001:db8::2"), Weight: 1}})
+	runStats := NewRunStats()
+	tasks := createDownloadTasks(NewDownloadHttpConfig(WithRunStats(runStats)), []*net.IP{&ipv4, &ipv6}, 4, picker,
+		&url.URL{Scheme: "https", Host: "download.invalid"})
+	for _, task := range tasks {
+		assert.Equal(t, ipFamily(*task.RemoteIP), ipFamily(task.LocalIP), task.RemoteIP.String())
+		if task.RemoteIP.To4() == nil {
+			assert.Nil(t, net.ParseIP(task.XForwardFor).To4(), task.XForwardFor)
+			runStats.Shard(task.metricsLabels()).AddBytes(300)
+		} else {
+			runStats.Shard(task.metricsLabels()).AddBytes(100)
+		}
+	}
+
+	report := runStats.Report(ReportConfig{}, StopReasonLimit)
+	assert.Len(t, report.IPFamilies, 2)
+	assert.Equal(t, FamilyIPv4, report.IPFamilies[0].Name)
+	assert.Equal(t, int64(200), report.IPFamilies[0].Bytes)
+	assert.Equal(t, FamilyIPv6, report.IPFamilies[1].Name)
+	assert.Equal(t, int64(600), report.IPFamilies[1].Bytes)
+	assert.Equal(t, report.IPFamilies[0].URL, report.IPFamilies[1].URL)
+	assert.Contains(t, runStats.Summary(), "IPv4 vs IPv6 for https://download.invalid: IPv4 200B")
+}
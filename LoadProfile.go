@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stage is a part of a load profile, it holds Concurrency requests in flight for Duration. A ramp stage moves linearly
+// from the concurrency of the stage before it to its own, a stage of zero Duration lasts until the run limit stops the run.
+type Stage struct {
+	Name        string
+	Concurrency int
+	Duration    time.Duration
+	Ramp        bool
+}
+
+// ParseStages parses step stages like 8:30s,16:1m into (concurrency, duration) pairs
+func ParseStages(value string) ([]Stage, error) {
+	var stages []Stage
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		concurrencyValue, durationValue, found := strings.Cut(field, ":")
+		concurrency, err := strconv.Atoi(concurrencyValue)
+		if !found || err != nil || concurrency <= 0 {
+			return nil, fmt.Errorf("invalid stage %q, use concurrency:duration like 16:30s", field)
+		}
+		duration, err := time.ParseDuration(durationValue)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid stage %q, use concurrency:duration like 16:30s", field)
+		}
+		stages = append(stages, Stage{Name: fmt.Sprintf("step-%d", len(stages)+1), Concurrency: concurrency, Duration: duration})
+	}
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("no stages in %q", value)
+	}
+	return stages, nil
+}
+
+// BuildStages puts the ramp-up, the steps and the ramp-down of a run together. Without steps the run holds parallel
+// requests in flight for what is left of duration after the ramps, or until the run limit stops it when duration is 0.
+func BuildStages(parallel int, duration, rampUp, rampDown time.Duration, steps []Stage) ([]Stage, error) {
+	if len(steps) == 0 {
+		steady := Stage{Name: "steady", Concurrency: parallel}
+		if duration > 0 {
+			steady.Duration = duration - rampUp - rampDown
+			if steady.Duration <= 0 {
+				return nil, fmt.Errorf("the ramps of %s and %s leave nothing of the duration %s", rampUp, rampDown, duration)
+			}
+		} else if rampDown > 0 {
+			return nil, fmt.Errorf("a ramp-down needs stages or a duration to know when the run ends")
+		}
+		steps = []Stage{steady}
+	}
+	var stages []Stage
+	if rampUp > 0 {
+		stages = append(stages, Stage{Name: "ramp-up", Concurrency: steps[0].Concurrency, Duration: rampUp, Ramp: true})
+	}
+	stages = append(stages, steps...)
+	if rampDown > 0 {
+		stages = append(stages, Stage{Name: "ramp-down", Duration: rampDown, Ramp: true})
+	}
+	return stages, nil
+}
+
+// LoadProfile limits the requests in flight across all workers to the concurrency of the current stage.
+// The timeline starts with the first request and ends after the last stage.
+type LoadProfile struct {
+	Stages []Stage
+	// start is the Unix time in nanoseconds the first request was let through, 0 before it
+	start atomic.Int64
+
+	mutex  sync.Mutex
+	active int
+	// released is closed and replaced whenever a request finishes, so that the waiting workers look again
+	released chan struct{}
+}
+
+func NewLoadProfile(stages []Stage) *LoadProfile {
+	return &LoadProfile{
+		Stages:   stages,
+		released: make(chan struct{}),
+	}
+}
+
+// Duration returns the length of the whole profile, 0 when its last stage lasts until the run limit stops the run
+func (loadProfile *LoadProfile) Duration() time.Duration {
+	var duration time.Duration
+	for _, stage := range loadProfile.Stages {
+		if stage.Duration == 0 {
+			return 0
+		}
+		duration += stage.Duration
+	}
+	return duration
+}
+
+// MaxConcurrency returns the highest concurrency of the profile, the workers needed to reach it
+func (loadProfile *LoadProfile) MaxConcurrency() int {
+	maxConcurrency := 0
+	for _, stage := range loadProfile.Stages {
+		maxConcurrency = max(maxConcurrency, stage.Concurrency)
+	}
+	return maxConcurrency
+}
+
+// StageAt returns the index of the stage at elapsed into the profile and its concurrency, the index is
+// len(Stages) once the profile is over
+func (loadProfile *LoadProfile) StageAt(elapsed time.Duration) (int, int) {
+	previous := 0
+	for index, stage := range loadProfile.Stages {
+		if stage.Duration == 0 || elapsed < stage.Duration {
+			if !stage.Ramp || stage.Duration == 0 {
+				return index, stage.Concurrency
+			}
+			progress := float64(elapsed) / float64(stage.Duration)
+			return index, int(math.Ceil(float64(previous) + float64(stage.Concurrency-previous)*progress))
+		}
+		elapsed -= stage.Duration
+		previous = stage.Concurrency
+	}
+	return len(loadProfile.Stages), 0
+}
+
+// StageStart returns the offset of a stage from the start of the profile
+func (loadProfile *LoadProfile) StageStart(index int) time.Duration {
+	var start time.Duration
+	for _, stage := range loadProfile.Stages[:index] {
+		start += stage.Duration
+	}
+	return start
+}
+
+// Current returns the index of the current stage and its concurrency, the index is -1 before the profile started
+func (loadProfile *LoadProfile) Current() (int, int) {
+	start := loadProfile.start.Load()
+	if start == 0 {
+		return -1, 0
+	}
+	return loadProfile.StageAt(time.Since(time.Unix(0, start)))
+}
+
+// Over reports whether the last stage has ended
+func (loadProfile *LoadProfile) Over() bool {
+	index, _ := loadProfile.Current()
+	return index >= len(loadProfile.Stages)
+}
+
+// Started returns the time the profile started, the zero time before it
+func (loadProfile *LoadProfile) Started() time.Time {
+	start := loadProfile.start.Load()
+	if start == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, start)
+}
+
+// Active returns the number of requests in flight
+func (loadProfile *LoadProfile) Active() int {
+	loadProfile.mutex.Lock()
+	defer loadProfile.mutex.Unlock()
+	return loadProfile.active
+}
+
+// Acquire waits until the current stage lets one more request through and counts it as in flight.
+// It returns false when the profile is over or ctx is cancelled, the worker stops then.
+func (loadProfile *LoadProfile) Acquire(ctx context.Context) bool {
+	loadProfile.start.CompareAndSwap(0, time.Now().UnixNano())
+	for {
+		index, concurrency := loadProfile.Current()
+		if index >= len(loadProfile.Stages) {
+			return false
+		}
+		loadProfile.mutex.Lock()
+		if loadProfile.active < concurrency {
+			loadProfile.active++
+			loadProfile.mutex.Unlock()
+			return true
+		}
+		released := loadProfile.released
+		loadProfile.mutex.Unlock()
+		// Ramps raise the concurrency over time, so the workers look again after a while even without a release
+		timer := time.NewTimer(50 * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-released:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Release marks a request acquired with Acquire as finished
+func (loadProfile *LoadProfile) Release() {
+	loadProfile.mutex.Lock()
+	defer loadProfile.mutex.Unlock()
+	loadProfile.active--
+	close(loadProfile.released)
+	loadProfile.released = make(chan struct{})
+}
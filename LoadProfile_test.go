@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStages(t *testing.T) {
+	stages, err := ParseStages("8:30s, 16:1m")
+	assert.Nil(t, err)
+	assert.Equal(t, []Stage{{Name: "step-1", Concurrency: 8, Duration: 30 * time.Second},
+		{Name: "step-2", Concurrency: 16, Duration: time.Minute}}, stages)
+	for _, value := range []string{"", "8", "0:30s", "8:", "8:-1s", "x:30s"} {
+		_, err := ParseStages(value)
+		assert.NotNil(t, err, value)
+	}
+}
+
+func TestBuildStages(t *testing.T) {
+	stages, err := BuildStages(16, time.Minute, 10*time.Second, 5*time.Second, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []Stage{
+		{Name: "ramp-up", Concurrency: 16, Duration: 10 * time.Second, Ramp: true},
+		{Name: "steady", Concurrency: 16, Duration: 45 * time.Second},
+		{Name: "ramp-down", Duration: 5 * time.Second, Ramp: true},
+	}, stages)
+	assert.Equal(t, time.Minute, NewLoadProfile(stages).Duration())
+
+	// Without a duration the steady stage lasts until the run limit stops the run
+	stages, err = BuildStages(16, 0, 10*time.Second, 0, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), NewLoadProfile(stages).Duration())
+	_, err = BuildStages(16, 0, 0, 5*time.Second, nil)
+	assert.NotNil(t, err)
+	_, err = BuildStages(16, 10*time.Second, 5*time.Second, 5*time.Second, nil)
+	assert.NotNil(t, err)
+}
+
+func TestStageAt(t *testing.T) {
+	steps, _ := ParseStages("10:10s,20:10s")
+	stages, _ := BuildStages(0, 0, 10*time.Second, 10*time.Second, steps)
+	loadProfile := NewLoadProfile(stages)
+	assert.Equal(t, 20, loadProfile.MaxConcurrency())
+	for _, test := range []struct {
+		elapsed         time.Duration
+		wantIndex       int
+		wantConcurrency int
+	}{
+		{0, 0, 0},
+		{5 * time.Second, 0, 5},
+		{10 * time.Second, 1, 10},
+		{25 * time.Second, 2, 20},
+		{35 * time.Second, 3, 10},
+		{40 * time.Second, 4, 0},
+	} {
+		index, concurrency := loadProfile.StageAt(test.elapsed)
+		assert.Equal(t, test.wantIndex, index, test.elapsed)
+		assert.Equal(t, test.wantConcurrency, concurrency, test.elapsed)
+	}
+	assert.Equal(t, 30*time.Second, loadProfile.StageStart(3))
+}
+
+func TestLoadProfileStages(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int64
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		mutex.Lock()
+		maxInFlight.Store(max(maxInFlight.Load(), current))
+		mutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		_, _ = w.Write(make([]byte, 1024))
+	}))
+	defer server.Close()
+
+	steps, _ := ParseStages("1:200ms,3:200ms")
+	loadProfile := NewLoadProfile(steps)
+	runStats := NewRunStats()
+	runStats.SetLoadProfile(loadProfile)
+	runLimit := NewRunLimit(runStats, WithLoadProfileEnd(loadProfile))
+	var waitGroup sync.WaitGroup
+	for i := 0; i < loadProfile.MaxConcurrency(); i++ {
+		downloadHttpConfig := newTestDownloadHttpConfig(t, server)
+		for _, opt := range []DownloadHttpConfigOption{WithRunStats(runStats), WithRunLimit(runLimit), WithSingleIpDownloadTimes(1000),
+			WithLoadProfile(loadProfile)} {
+			opt(downloadHttpConfig)
+		}
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			assert.Nil(t, downloadHttpConfig.DoHttpDownload(context.Background()))
+		}()
+	}
+	waitGroup.Wait()
+
+	assert.True(t, runLimit.Reached())
+	assert.Equal(t, int64(3), maxInFlight.Load())
+	assert.Equal(t, 0, loadProfile.Active())
+	report := runStats.Report(ReportConfig{}, StopReasonLimit)
+	assert.Len(t, report.Stages, 2)
+	first, second := report.Stages[0], report.Stages[1]
+	assert.Equal(t, "step-1", first.Name)
+	assert.Equal(t, 0.2, first.DurationSeconds)
+	assert.Equal(t, 0.2, second.StartSeconds)
+	// Three requests in flight finish about three times as many requests as one
+	assert.Greater(t, second.Requests, 2*first.Requests)
+	// A request on the boundary may count its bytes and its completion in different stages
+	assert.InDelta(t, first.Requests*1024, first.Bytes, 1024)
+	assert.Greater(t, first.AverageBitsPerSecond, 0.0)
+	assert.Equal(t, first.Requests, first.Latency.Count)
+	assert.Contains(t, runStats.Summary(), "step-2")
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LocalAddress is a source address of the download workers, Weight is its share of the workers
+type LocalAddress struct {
+	IP     net.IP
+	Weight int
+}
+
+// ParseLocalIPs parses a comma separated list of local IPs and CIDRs, an entry may end in @weight like 10.0.0.2@3.
+// A CIDR stands for the addresses of this host inside it, systemIPs lists them.
+func ParseLocalIPs(value string, systemIPs []net.IP) ([]LocalAddress, error) {
+	var addresses []LocalAddress
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		weight := 1
+		if address, weightValue, found := strings.Cut(field, "@"); found {
+			var err error
+			if weight, err = strconv.Atoi(weightValue); err != nil || weight <= 0 {
+				return nil, fmt.Errorf("invalid weight in %q, use a positive integer like 10.0.0.2@3", field)
+			}
+			field = address
+		}
+		if _, ipNet, err := net.ParseCIDR(field); err == nil {
+			matched := 0
+			for _, ip := range systemIPs {
+				if ipNet.Contains(ip) {
+					addresses = append(addresses, LocalAddress{IP: ip, Weight: weight})
+					matched++
+				}
+			}
+			if matched == 0 {
+				return nil, fmt.Errorf("no local IP of this host is in %s", field)
+			}
+			continue
+		}
+		ip := net.ParseIP(field)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid local IP %q", field)
+		}
+		if !containsIP(systemIPs, ip) {
+			return nil, fmt.Errorf("%s is not an IP of this host", field)
+		}
+		addresses = append(addresses, LocalAddress{IP: ip, Weight: weight})
+	}
+	return dedupLocalAddresses(addresses), nil
+}
+
+// InterfaceLocalIPs returns the addresses of family of the named network interface, link-local addresses
+// are left out since they cannot reach the download targets
+func InterfaceLocalIPs(name, family string) ([]LocalAddress, error) {
+	networkInterface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := interfaceIPs(*networkInterface)
+	if err != nil {
+		return nil, err
+	}
+	var addresses []LocalAddress
+	for _, ip := range ips {
+		if familyIncludes(family, ip) && !ip.IsLinkLocalUnicast() {
+			addresses = append(addresses, LocalAddress{IP: ip, Weight: 1})
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("interface %s has no address of IP family %s", name, family)
+	}
+	return addresses, nil
+}
+
+// SystemIPs returns the addresses of all network interfaces of this host
+func SystemIPs() ([]net.IP, error) {
+	networkInterfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var systemIPs []net.IP
+	for _, networkInterface := range networkInterfaces {
+		ips, err := interfaceIPs(networkInterface)
+		if err != nil {
+			return nil, err
+		}
+		systemIPs = append(systemIPs, ips...)
+	}
+	return systemIPs, nil
+}
+
+func interfaceIPs(networkInterface net.Interface) ([]net.IP, error) {
+	addresses, err := networkInterface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, address := range addresses {
+		switch v := address.(type) {
+		case *net.IPNet:
+			ips = append(ips, v.IP)
+		case *net.IPAddr:
+			ips = append(ips, v.IP)
+		}
+	}
+	return ips, nil
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, candidate := range ips {
+		if candidate.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// dedupLocalAddresses merges the entries of the same IP, their weights add up
+func dedupLocalAddresses(addresses []LocalAddress) []LocalAddress {
+	var deduped []LocalAddress
+	for _, address := range addresses {
+		merged := false
+		for i := range deduped {
+			if deduped[i].IP.Equal(address.IP) {
+				deduped[i].Weight += address.Weight
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			deduped = append(deduped, address)
+		}
+	}
+	return deduped
+}
+
+// filterLocalAddresses keeps the addresses of family
+func filterLocalAddresses(addresses []LocalAddress, family string) []LocalAddress {
+	var filtered []LocalAddress
+	for _, address := range addresses {
+		if familyIncludes(family, address.IP) {
+			filtered = append(filtered, address)
+		}
+	}
+	return filtered
+}
+
+// LocalIPPicker spreads the download workers over the local addresses by their weights with a smooth weighted
+// round-robin, equal weights take turns
+type LocalIPPicker struct {
+	Addresses []LocalAddress
+
+	mutex   sync.Mutex
+	current []int
+}
+
+func NewLocalIPPicker(addresses []LocalAddress) *LocalIPPicker {
+	return &LocalIPPicker{
+		Addresses: addresses,
+		current:   make([]int, len(addresses)),
+	}
+}
+
+// Next returns the local IP of the next worker, an address of the family of remoteIP. It returns nil when there is
+// none, the system picks the source address then.
+func (picker *LocalIPPicker) Next(remoteIP net.IP) net.IP {
+	picker.mutex.Lock()
+	defer picker.mutex.Unlock()
+	total, best := 0, -1
+	for i, address := range picker.Addresses {
+		if remoteIP != nil && ipFamily(address.IP) != ipFamily(remoteIP) {
+			continue
+		}
+		picker.current[i] += address.Weight
+		total += address.Weight
+		if best < 0 || picker.current[i] > picker.current[best] {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	picker.current[best] -= total
+	return picker.Addresses[best].IP
+}
+
+// First returns the first address of the family of the given IP, or the first address when there is none
+func (picker *LocalIPPicker) First(family string) net.IP {
+	for _, address := range picker.Addresses {
+		if ipFamily(address.IP) == family {
+			return address.IP
+		}
+	}
+	return picker.Addresses[0].IP
+}
+
+// IPs returns the local addresses as strings
+func (picker *LocalIPPicker) IPs() []string {
+	ips := make([]string, 0, len(picker.Addresses))
+	for _, address := range picker.Addresses {
+		ips = append(ips, address.IP.String())
+	}
+	return ips
+}
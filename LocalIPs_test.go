@@ -0,0 +1,78 @@
+package main
+
+import (
+	"HttpBenchmark/Metrics"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLocalIPs(t *testing.T) {
+	systemIPs := []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3"), net.ParseIP("10.0.1.5"), net.ParseIP("fe80::1")}
+	addresses, err := ParseLocalIPs("10.0.0.2, 10.0.1.0/24@2,10.0.0.2@3", systemIPs)
+	assert.Nil(t, err)
+	assert.Equal(t, []LocalAddress{{IP: net.ParseIP("10.0.0.2"), Weight: 4}, {IP: net.ParseIP("10.0.1.5"), Weight: 2}}, addresses)
+
+	addresses, err = ParseLocalIPs("10.0.0.0/30", systemIPs)
+	assert.Nil(t, err)
+	assert.Equal(t, []LocalAddress{{IP: net.ParseIP("10.0.0.2"), Weight: 1}, {IP: net.ParseIP("10.0.0.3"), Weight: 1}}, addresses)
+
+	for _, value := range []string{"10.0.0.9", "10.0.2.0/24", "local", "10.0.0.2@0", "10.0.0.2@x"} {
+		_, err := ParseLocalIPs(value, systemIPs)
+		assert.NotNil(t, err, value)
+	}
+}
+
+func TestInterfaceLocalIPs(t *testing.T) {
+	networkInterfaces, err := net.Interfaces()
+	assert.Nil(t, err)
+	for _, networkInterface := range networkInterfaces {
+		if networkInterface.Flags&net.FlagLoopback == 0 {
+			continue
+		}
+		addresses, err := InterfaceLocalIPs(networkInterface.Name, IPFamily4)
+		assert.Nil(t, err)
+		var ips []string
+		for _, address := range addresses {
+			ips = append(ips, address.IP.String())
+		}
+		assert.Contains(t, ips, "127.0.0.1")
+		return
+	}
+	t.Skip("no loopback interface")
+}
+
+func TestLocalIPPicker(t *testing.T) {
+	picker := NewLocalIPPicker([]LocalAddress{{IP: net.ParseIP("10.0.0.2"), Weight: 1}, {IP: net.ParseIP("10.0.0.3"), Weight: 3}})
+	var picked []string
+	for i := 0; i < 8; i++ {
+		picked = append(picked, picker.Next(nil).String())
+	}
+	// The smooth round-robin interleaves the heavier address instead of sending it a run of workers
+	assert.Equal(t, []string{"10.0.0.3", "10.0.0.2", "10.0.0.3", "10.0.0.3", "10.0.0.3", "10.0.0.2", "10.0.0.3", "10.0.0.3"}, picked)
+	assert.Equal(t, []string{"10.0.0.2", "10.0.0.3"}, picker.IPs())
+}
+
+func TestCreateDownloadTasksSpreadsLocalIPs(t *testing.T) {
+	remoteIP := net.ParseIP("192.0.2.1")
+	picker := NewLocalIPPicker([]LocalAddress{{IP: net.ParseIP("10.0.0.2"), Weight: 1}, {IP: net.ParseIP("10.0.0.3"), Weight: 1}})
+	runStats := NewRunStats()
+	tasks := createDownloadTasks(NewDownloadHttpConfig(WithRunStats(runStats)), []*net.IP{&remoteIP}, 4, picker,
+		&url.URL{Scheme: "https", Host: "download.invalid"})
+	var localIPs []string
+	for _, task := range tasks {
+		localIPs = append(localIPs, task.LocalIP.String())
+		runStats.Shard(task.metricsLabels()).AddBytes(100)
+	}
+	assert.Equal(t, []string{"10.0.0.2", "10.0.0.3", "10.0.0.2", "10.0.0.3"}, localIPs)
+
+	report := runStats.Report(ReportConfig{}, StopReasonLimit)
+	assert.Len(t, report.LocalIPs, 2)
+	assert.Equal(t, "10.0.0.3", report.LocalIPs[1].Name)
+	assert.Equal(t, int64(200), report.LocalIPs[1].Bytes)
+	assert.Contains(t, runStats.Summary(), "Local IPs: 10.0.0.2 200B")
+	snapshot := runStats.Collector().Snapshot()
+	assert.Len(t, snapshot.GroupBy(func(labels Metrics.Labels) string { return labels.LocalIP }), 2)
+}
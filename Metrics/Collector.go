@@ -0,0 +1,425 @@
+package Metrics
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Collector is the registry of counters shared by the download workers, the DNS queries and the reporter.
+// A worker resolves its shard once and updates it without any lock shared with the other shards, the totals are
+// summed from the shards when they are read. The DNS updates run under the read lock and Snapshot takes the write
+// lock, so a snapshot never sees half of a DNS update.
+type Collector struct {
+	mutex  sync.RWMutex
+	shards map[Labels]*Shard
+	// shardList holds the shards in creation order, it is replaced on every new shard so that it can be read
+	// without the lock
+	shardList     atomic.Pointer[[]*Shard]
+	dnsQueries    Counter
+	dnsFailures   Counter
+	dnsServers    map[string]*dnsServerStats
+	dnsServerList []string
+	activeWorkers Gauge
+	openConns     Gauge
+	// Worker failures seen by the supervisor
+	workerPanics   Counter
+	workerErrors   Counter
+	workerRestarts Counter
+	retiredWorkers Counter
+}
+
+// dnsServerStats holds the queries sent to one DoH server
+type dnsServerStats struct {
+	queries  Counter
+	failures Counter
+	// latency holds the query durations in nanoseconds
+	latency *Histogram
+}
+
+// Labels identify the workers that share a shard
+type Labels struct {
+	LocalIP  string
+	RemoteIP string
+	URL      string
+}
+
+// Shard holds the counters of the workers that share the same labels
+type Shard struct {
+	labels   Labels
+	counters shardCounters
+}
+
+type shardCounters struct {
+	bytes Counter
+	// uploadedBytes counts the request body bytes, bytes only the response body bytes
+	uploadedBytes Counter
+	requests      Counter
+	failures      Counter
+	retries       Counter
+	reusedConns   Counter
+	// failureClasses maps a failure class to its *Counter
+	failureClasses sync.Map
+	// statusCodes maps an HTTP status code to its *Counter
+	statusCodes sync.Map
+	rate        *RateEstimator
+	uploadRate  *RateEstimator
+}
+
+// ShardSnapshot is a copy of the counters of one shard, of a group of shards or of the totals
+type ShardSnapshot struct {
+	Labels   Labels
+	Bytes    int64
+	Requests int64
+	Failures int64
+	// Retries counts the attempts that repeated a failed request
+	Retries     int64
+	ReusedConns int64
+	// FailureClasses breaks Failures down by class
+	FailureClasses map[string]int64
+	// StatusCodes counts the responses by HTTP status code
+	StatusCodes map[int]int64
+	// Rate is the bytes per second over the sliding window, AverageRate since the shard was created
+	Rate        float64
+	AverageRate float64
+	// UploadedBytes, UploadRate and AverageUploadRate are the request body counterparts of Bytes, Rate and AverageRate
+	UploadedBytes     int64
+	UploadRate        float64
+	AverageUploadRate float64
+}
+
+// Snapshot is a copy of all counters of a Collector, Total always equals the sum of Shards
+type Snapshot struct {
+	Total         ShardSnapshot
+	Shards        []ShardSnapshot
+	DNSQueries    int64
+	DNSFailures   int64
+	DNSServers    []DNSServerSnapshot
+	ActiveWorkers int64
+	OpenConns     int64
+	// WorkerPanics and WorkerErrors count the failed worker runs, WorkerRestarts and RetiredWorkers what the supervisor did about them
+	WorkerPanics   int64
+	WorkerErrors   int64
+	WorkerRestarts int64
+	RetiredWorkers int64
+}
+
+// DNSServerSnapshot is a copy of the counters of one DoH server, Latency is in nanoseconds
+type DNSServerSnapshot struct {
+	Server   string
+	Queries  int64
+	Failures int64
+	Latency  HistogramSnapshot
+}
+
+func NewCollector() *Collector {
+	return &Collector{
+		shards:     make(map[Labels]*Shard),
+		dnsServers: make(map[string]*dnsServerStats),
+	}
+}
+
+// Shard returns the shard with the given labels, creating it on first use
+func (collector *Collector) Shard(labels Labels) *Shard {
+	collector.mutex.RLock()
+	shard, ok := collector.shards[labels]
+	collector.mutex.RUnlock()
+	if ok {
+		return shard
+	}
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	if shard, ok = collector.shards[labels]; ok {
+		return shard
+	}
+	shard = &Shard{
+		labels:   labels,
+		counters: newShardCounters(),
+	}
+	collector.shards[labels] = shard
+	var shardList []*Shard
+	if current := collector.shardList.Load(); current != nil {
+		shardList = append(shardList, *current...)
+	}
+	shardList = append(shardList, shard)
+	collector.shardList.Store(&shardList)
+	return shard
+}
+
+// allShards returns the shards in creation order
+func (collector *Collector) allShards() []*Shard {
+	if shardList := collector.shardList.Load(); shardList != nil {
+		return *shardList
+	}
+	return nil
+}
+
+// AddDNSQuery counts a DNS query to server that took duration, failed tells whether it returned an error
+func (collector *Collector) AddDNSQuery(server string, duration time.Duration, failed bool) {
+	dnsServer := collector.dnsServer(server)
+	collector.mutex.RLock()
+	defer collector.mutex.RUnlock()
+	collector.dnsQueries.Inc()
+	dnsServer.queries.Inc()
+	dnsServer.latency.Record(int64(duration))
+	if failed {
+		collector.dnsFailures.Inc()
+		dnsServer.failures.Inc()
+	}
+}
+
+func (collector *Collector) dnsServer(server string) *dnsServerStats {
+	collector.mutex.RLock()
+	dnsServer, ok := collector.dnsServers[server]
+	collector.mutex.RUnlock()
+	if ok {
+		return dnsServer
+	}
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	if dnsServer, ok = collector.dnsServers[server]; ok {
+		return dnsServer
+	}
+	dnsServer = &dnsServerStats{
+		latency: NewHistogram(),
+	}
+	collector.dnsServers[server] = dnsServer
+	collector.dnsServerList = append(collector.dnsServerList, server)
+	return dnsServer
+}
+
+// WorkerStarted and WorkerDone track the number of running download workers
+func (collector *Collector) WorkerStarted() {
+	collector.activeWorkers.Inc()
+}
+
+func (collector *Collector) WorkerDone() {
+	collector.activeWorkers.Dec()
+}
+
+// WorkerFailed counts a worker run that ended with a panic or an error
+func (collector *Collector) WorkerFailed(panicked bool) {
+	if panicked {
+		collector.workerPanics.Inc()
+	} else {
+		collector.workerErrors.Inc()
+	}
+}
+
+// WorkerRestarted and WorkerRetired count what the supervisor did with a failed worker
+func (collector *Collector) WorkerRestarted() {
+	collector.workerRestarts.Inc()
+}
+
+func (collector *Collector) WorkerRetired() {
+	collector.retiredWorkers.Inc()
+}
+
+// ConnOpened and ConnClosed track the number of open connections to the download targets
+func (collector *Collector) ConnOpened() {
+	collector.openConns.Inc()
+}
+
+func (collector *Collector) ConnClosed() {
+	collector.openConns.Dec()
+}
+
+// TotalBytes and TotalRequests sum a single counter of the shards without taking a snapshot
+func (collector *Collector) TotalBytes() int64 {
+	return collector.sum(func(counters *shardCounters) int64 { return counters.bytes.Load() })
+}
+
+func (collector *Collector) TotalUploadedBytes() int64 {
+	return collector.sum(func(counters *shardCounters) int64 { return counters.uploadedBytes.Load() })
+}
+
+func (collector *Collector) TotalRequests() int64 {
+	return collector.sum(func(counters *shardCounters) int64 { return counters.requests.Load() })
+}
+
+func (collector *Collector) sum(counter func(*shardCounters) int64) int64 {
+	var total int64
+	for _, shard := range collector.allShards() {
+		total += counter(&shard.counters)
+	}
+	return total
+}
+
+// Snapshot copies all counters, shards are in creation order. The shards are updated while they are copied, so a
+// request can be in a snapshot without all of its bytes, but Total is summed from the copies and always matches them.
+func (collector *Collector) Snapshot() Snapshot {
+	shards := collector.allShards()
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	snapshot := Snapshot{
+		Total: ShardSnapshot{
+			FailureClasses: make(map[string]int64),
+			StatusCodes:    make(map[int]int64),
+		},
+		Shards:         make([]ShardSnapshot, 0, len(shards)),
+		DNSQueries:     collector.dnsQueries.Load(),
+		DNSFailures:    collector.dnsFailures.Load(),
+		ActiveWorkers:  collector.activeWorkers.Load(),
+		OpenConns:      collector.openConns.Load(),
+		WorkerPanics:   collector.workerPanics.Load(),
+		WorkerErrors:   collector.workerErrors.Load(),
+		WorkerRestarts: collector.workerRestarts.Load(),
+		RetiredWorkers: collector.retiredWorkers.Load(),
+	}
+	for _, shard := range shards {
+		shardSnapshot := shard.counters.snapshot(shard.labels)
+		snapshot.Shards = append(snapshot.Shards, shardSnapshot)
+		snapshot.Total.add(shardSnapshot)
+	}
+	// add keeps the labels all shards share, the totals have none
+	snapshot.Total.Labels = Labels{}
+	for _, server := range collector.dnsServerList {
+		dnsServer := collector.dnsServers[server]
+		snapshot.DNSServers = append(snapshot.DNSServers, DNSServerSnapshot{
+			Server:   server,
+			Queries:  dnsServer.queries.Load(),
+			Failures: dnsServer.failures.Load(),
+			Latency:  dnsServer.latency.Snapshot(),
+		})
+	}
+	return snapshot
+}
+
+// GroupBy sums the shards that share the key returned by key, the groups are sorted by key.
+// Only the labels that all shards of a group share are kept.
+func (snapshot Snapshot) GroupBy(key func(Labels) string) []ShardSnapshot {
+	groups := make(map[string]*ShardSnapshot)
+	var keys []string
+	for _, shard := range snapshot.Shards {
+		groupKey := key(shard.Labels)
+		group, ok := groups[groupKey]
+		if !ok {
+			group = &ShardSnapshot{
+				Labels:         shard.Labels,
+				FailureClasses: make(map[string]int64),
+				StatusCodes:    make(map[int]int64),
+			}
+			groups[groupKey] = group
+			keys = append(keys, groupKey)
+		}
+		group.add(shard)
+	}
+	sort.Strings(keys)
+	grouped := make([]ShardSnapshot, 0, len(keys))
+	for _, groupKey := range keys {
+		grouped = append(grouped, *groups[groupKey])
+	}
+	return grouped
+}
+
+func (group *ShardSnapshot) add(shard ShardSnapshot) {
+	if group.Labels.LocalIP != shard.Labels.LocalIP {
+		group.Labels.LocalIP = ""
+	}
+	if group.Labels.RemoteIP != shard.Labels.RemoteIP {
+		group.Labels.RemoteIP = ""
+	}
+	if group.Labels.URL != shard.Labels.URL {
+		group.Labels.URL = ""
+	}
+	group.Bytes += shard.Bytes
+	group.Requests += shard.Requests
+	group.Failures += shard.Failures
+	group.Retries += shard.Retries
+	group.ReusedConns += shard.ReusedConns
+	for class, count := range shard.FailureClasses {
+		group.FailureClasses[class] += count
+	}
+	for code, count := range shard.StatusCodes {
+		group.StatusCodes[code] += count
+	}
+	group.Rate += shard.Rate
+	group.AverageRate += shard.AverageRate
+	group.UploadedBytes += shard.UploadedBytes
+	group.UploadRate += shard.UploadRate
+	group.AverageUploadRate += shard.AverageUploadRate
+}
+
+func newShardCounters() shardCounters {
+	return shardCounters{
+		rate:       NewRateEstimator(DefaultRateWindow, DefaultRateSlots),
+		uploadRate: NewRateEstimator(DefaultRateWindow, DefaultRateSlots),
+	}
+}
+
+func (counters *shardCounters) snapshot(labels Labels) ShardSnapshot {
+	snapshot := ShardSnapshot{
+		Labels:            labels,
+		Bytes:             counters.bytes.Load(),
+		Requests:          counters.requests.Load(),
+		Failures:          counters.failures.Load(),
+		Retries:           counters.retries.Load(),
+		ReusedConns:       counters.reusedConns.Load(),
+		FailureClasses:    make(map[string]int64),
+		StatusCodes:       make(map[int]int64),
+		Rate:              counters.rate.Rate(),
+		AverageRate:       counters.rate.Average(),
+		UploadedBytes:     counters.uploadedBytes.Load(),
+		UploadRate:        counters.uploadRate.Rate(),
+		AverageUploadRate: counters.uploadRate.Average(),
+	}
+	counters.failureClasses.Range(func(class, counter any) bool {
+		snapshot.FailureClasses[class.(string)] = counter.(*Counter).Load()
+		return true
+	})
+	counters.statusCodes.Range(func(code, counter any) bool {
+		snapshot.StatusCodes[code.(int)] = counter.(*Counter).Load()
+		return true
+	})
+	return snapshot
+}
+
+func (counters *shardCounters) addFailure(class string) {
+	counters.failures.Inc()
+	counter, _ := counters.failureClasses.LoadOrStore(class, &Counter{})
+	counter.(*Counter).Inc()
+}
+
+func (counters *shardCounters) addStatus(code int) {
+	counter, _ := counters.statusCodes.LoadOrStore(code, &Counter{})
+	counter.(*Counter).Inc()
+}
+
+func (shard *Shard) Labels() Labels {
+	return shard.labels
+}
+
+func (shard *Shard) AddBytes(n int64) {
+	shard.counters.bytes.Add(n)
+	shard.counters.rate.Add(n)
+}
+
+// AddUploadedBytes counts request body bytes as they are written to the connection
+func (shard *Shard) AddUploadedBytes(n int64) {
+	shard.counters.uploadedBytes.Add(n)
+	shard.counters.uploadRate.Add(n)
+}
+
+// AddRequest counts a successful request, reused tells whether it went over a kept-alive connection
+func (shard *Shard) AddRequest(reused bool) {
+	shard.counters.requests.Inc()
+	if reused {
+		shard.counters.reusedConns.Inc()
+	}
+}
+
+// AddFailure counts a failed request under the given class, like "request" or "body"
+func (shard *Shard) AddFailure(class string) {
+	shard.counters.addFailure(class)
+}
+
+// AddRetry counts a retry of a failed request
+func (shard *Shard) AddRetry() {
+	shard.counters.retries.Inc()
+}
+
+// AddStatus counts a response with the given HTTP status code
+func (shard *Shard) AddStatus(code int) {
+	shard.counters.addStatus(code)
+}
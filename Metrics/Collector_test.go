@@ -0,0 +1,116 @@
+package Metrics
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCollectorShards(t *testing.T) {
+	collector := NewCollector()
+	first := collector.Shard(Labels{RemoteIP: "10.0.0.1"})
+	assert.Same(t, first, collector.Shard(Labels{RemoteIP: "10.0.0.1"}))
+	first.AddBytes(100)
+	first.AddRequest(true)
+	first.AddStatus(200)
+	collector.Shard(Labels{RemoteIP: "10.0.0.2"}).AddFailure("body")
+	collector.AddDNSQuery("https://223.5.5.5/dns-query", 10*time.Millisecond, false)
+	collector.AddDNSQuery("https://223.5.5.5/dns-query", 30*time.Millisecond, true)
+
+	snapshot := collector.Snapshot()
+	assert.Greater(t, snapshot.Total.Rate, 0.0)
+	assert.Greater(t, snapshot.Shards[0].Rate, 0.0)
+	assert.Equal(t, 0.0, snapshot.Shards[1].Rate)
+	// The rates depend on the clock, compare the counters only
+	snapshot.Total.Rate, snapshot.Total.AverageRate = 0, 0
+	for i := range snapshot.Shards {
+		snapshot.Shards[i].Rate, snapshot.Shards[i].AverageRate = 0, 0
+	}
+	assert.Equal(t, ShardSnapshot{Bytes: 100, Requests: 1, Failures: 1, ReusedConns: 1,
+		FailureClasses: map[string]int64{"body": 1}, StatusCodes: map[int]int64{200: 1}}, snapshot.Total)
+	assert.Equal(t, []ShardSnapshot{
+		{Labels: Labels{RemoteIP: "10.0.0.1"}, Bytes: 100, Requests: 1, ReusedConns: 1,
+			FailureClasses: map[string]int64{}, StatusCodes: map[int]int64{200: 1}},
+		{Labels: Labels{RemoteIP: "10.0.0.2"}, Failures: 1, FailureClasses: map[string]int64{"body": 1}, StatusCodes: map[int]int64{}},
+	}, snapshot.Shards)
+	assert.Equal(t, int64(100), collector.TotalBytes())
+	assert.Equal(t, int64(1), collector.TotalRequests())
+	assert.Equal(t, int64(2), snapshot.DNSQueries)
+	assert.Equal(t, int64(1), snapshot.DNSFailures)
+	assert.Len(t, snapshot.DNSServers, 1)
+	assert.Equal(t, int64(2), snapshot.DNSServers[0].Queries)
+	assert.Equal(t, int64(1), snapshot.DNSServers[0].Failures)
+	assert.Equal(t, int64(30*time.Millisecond), snapshot.DNSServers[0].Latency.Max)
+}
+
+func TestCollectorSnapshotConsistent(t *testing.T) {
+	collector := NewCollector()
+	var waitGroup sync.WaitGroup
+	done := make(chan struct{})
+	for worker := 0; worker < 8; worker++ {
+		waitGroup.Add(1)
+		go func(worker int) {
+			defer waitGroup.Done()
+			collector.WorkerStarted()
+			defer collector.WorkerDone()
+			shard := collector.Shard(Labels{RemoteIP: strconv.Itoa(worker % 4)})
+			for i := 0; i < 1000; i++ {
+				shard.AddBytes(1)
+			}
+		}(worker)
+	}
+	go func() {
+		waitGroup.Wait()
+		close(done)
+	}()
+	for {
+		snapshot := collector.Snapshot()
+		var sum int64
+		for _, shard := range snapshot.Shards {
+			sum += shard.Bytes
+		}
+		assert.Equal(t, snapshot.Total.Bytes, sum)
+		select {
+		case <-done:
+			snapshot = collector.Snapshot()
+			assert.Equal(t, int64(8000), snapshot.Total.Bytes)
+			assert.Equal(t, int64(0), snapshot.ActiveWorkers)
+			return
+		default:
+		}
+	}
+}
+
+func TestSnapshotGroupBy(t *testing.T) {
+	collector := NewCollector()
+	collector.Shard(Labels{LocalIP: "192.168.1.2", RemoteIP: "10.0.0.1", URL: "https://a/"}).AddBytes(1)
+	collector.Shard(Labels{LocalIP: "192.168.1.2", RemoteIP: "10.0.0.1", URL: "https://b/"}).AddBytes(2)
+	collector.Shard(Labels{LocalIP: "192.168.1.2", RemoteIP: "10.0.0.2", URL: "https://a/"}).AddFailure("body")
+
+	byRemoteIP := collector.Snapshot().GroupBy(func(labels Labels) string {
+		return labels.RemoteIP
+	})
+	assert.Len(t, byRemoteIP, 2)
+	assert.Equal(t, Labels{LocalIP: "192.168.1.2", RemoteIP: "10.0.0.1"}, byRemoteIP[0].Labels)
+	assert.Equal(t, int64(3), byRemoteIP[0].Bytes)
+	assert.Equal(t, map[string]int64{"body": 1}, byRemoteIP[1].FailureClasses)
+}
+
+func TestCollectorUploadedBytes(t *testing.T) {
+	collector := NewCollector()
+	shard := collector.Shard(Labels{RemoteIP: "10.0.0.1"})
+	shard.AddUploadedBytes(300)
+	shard.AddBytes(20)
+	collector.Shard(Labels{RemoteIP: "10.0.0.2"}).AddUploadedBytes(100)
+
+	snapshot := collector.Snapshot()
+	assert.Equal(t, int64(400), snapshot.Total.UploadedBytes)
+	assert.Equal(t, int64(20), snapshot.Total.Bytes)
+	assert.Equal(t, int64(400), collector.TotalUploadedBytes())
+	assert.Greater(t, snapshot.Total.UploadRate, snapshot.Total.Rate)
+	assert.Equal(t, int64(300), snapshot.Shards[0].UploadedBytes)
+	grouped := snapshot.GroupBy(func(Labels) string { return "" })
+	assert.Equal(t, int64(400), grouped[0].UploadedBytes)
+}
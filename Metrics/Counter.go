@@ -0,0 +1,45 @@
+package Metrics
+
+import "sync/atomic"
+
+// Counter is a monotonically increasing int64 that is safe for concurrent use
+type Counter struct {
+	value atomic.Int64
+}
+
+func (counter *Counter) Add(n int64) {
+	counter.value.Add(n)
+}
+
+func (counter *Counter) Inc() {
+	counter.value.Add(1)
+}
+
+func (counter *Counter) Load() int64 {
+	return counter.value.Load()
+}
+
+// Gauge is an int64 that can go up and down, like the number of active workers
+type Gauge struct {
+	value atomic.Int64
+}
+
+func (gauge *Gauge) Set(value int64) {
+	gauge.value.Store(value)
+}
+
+func (gauge *Gauge) Add(n int64) {
+	gauge.value.Add(n)
+}
+
+func (gauge *Gauge) Inc() {
+	gauge.value.Add(1)
+}
+
+func (gauge *Gauge) Dec() {
+	gauge.value.Add(-1)
+}
+
+func (gauge *Gauge) Load() int64 {
+	return gauge.value.Load()
+}
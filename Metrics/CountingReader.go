@@ -0,0 +1,34 @@
+package Metrics
+
+import "io"
+
+// CountingReader passes reads through and reports every chunk to onRead as soon as it arrives,
+// so counters and rates follow a body while it is being read rather than after it is done
+type CountingReader struct {
+	reader io.Reader
+	onRead func(n int64)
+	count  int64
+}
+
+func NewCountingReader(reader io.Reader, onRead func(n int64)) *CountingReader {
+	return &CountingReader{
+		reader: reader,
+		onRead: onRead,
+	}
+}
+
+func (countingReader *CountingReader) Read(p []byte) (int, error) {
+	n, err := countingReader.reader.Read(p)
+	if n > 0 {
+		countingReader.count += int64(n)
+		if countingReader.onRead != nil {
+			countingReader.onRead(int64(n))
+		}
+	}
+	return n, err
+}
+
+// Count returns the number of bytes read so far
+func (countingReader *CountingReader) Count() int64 {
+	return countingReader.count
+}
@@ -0,0 +1,226 @@
+package Metrics
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+)
+
+const (
+	// subBucketBits sets the precision, every power of two range is split into 2^(subBucketBits-1) buckets,
+	// so a recorded value is off by less than 1/64 of itself
+	subBucketBits     = 7
+	subBucketCount    = 1 << subBucketBits
+	subBucketHalf     = subBucketCount / 2
+	maxBucketIndex    = subBucketCount + (64-subBucketBits)*subBucketHalf
+	defaultBucketSize = subBucketCount * 4
+)
+
+// Histogram is a thread-safe HDR-style histogram of non-negative int64 values with log-linear buckets
+type Histogram struct {
+	mutex  sync.Mutex
+	counts []int64
+	count  int64
+	min    int64
+	max    int64
+	sum    float64
+	sumSq  float64
+}
+
+// HistogramSnapshot is a consistent copy of the aggregates of a Histogram
+type HistogramSnapshot struct {
+	Count  int64
+	Min    int64
+	Max    int64
+	Mean   float64
+	StdDev float64
+	P50    int64
+	P90    int64
+	P95    int64
+	P99    int64
+	P999   int64
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{
+		counts: make([]int64, defaultBucketSize),
+	}
+}
+
+// bucketIndex maps a value to its bucket, values below subBucketCount get a bucket of their own
+func bucketIndex(value int64) int {
+	if value < subBucketCount {
+		return int(value)
+	}
+	shift := bits.Len64(uint64(value)) - subBucketBits
+	top := int(value >> shift)
+	return subBucketCount + (shift-1)*subBucketHalf + top - subBucketHalf
+}
+
+// bucketValue returns the value that represents a bucket, the middle of its range
+func bucketValue(index int) int64 {
+	if index < subBucketCount {
+		return int64(index)
+	}
+	shift := (index-subBucketCount)/subBucketHalf + 1
+	top := int64((index-subBucketCount)%subBucketHalf + subBucketHalf)
+	lower := top << shift
+	return lower + (int64(1)<<shift-1)/2
+}
+
+// Record adds a value to the histogram, negative values are recorded as zero
+func (histogram *Histogram) Record(value int64) {
+	if value < 0 {
+		value = 0
+	}
+	index := bucketIndex(value)
+	histogram.mutex.Lock()
+	defer histogram.mutex.Unlock()
+	if index >= len(histogram.counts) {
+		size := len(histogram.counts) * 2
+		for size <= index {
+			size *= 2
+		}
+		if size > maxBucketIndex {
+			size = maxBucketIndex
+		}
+		counts := make([]int64, size)
+		copy(counts, histogram.counts)
+		histogram.counts = counts
+	}
+	histogram.counts[index]++
+	if histogram.count == 0 || value < histogram.min {
+		histogram.min = value
+	}
+	if value > histogram.max {
+		histogram.max = value
+	}
+	histogram.count++
+	histogram.sum += float64(value)
+	histogram.sumSq += float64(value) * float64(value)
+}
+
+// Merge adds all values recorded in other to the histogram
+func (histogram *Histogram) Merge(other *Histogram) {
+	other.mutex.Lock()
+	counts := make([]int64, len(other.counts))
+	copy(counts, other.counts)
+	count, min, max, sum, sumSq := other.count, other.min, other.max, other.sum, other.sumSq
+	other.mutex.Unlock()
+	if count == 0 {
+		return
+	}
+
+	histogram.mutex.Lock()
+	defer histogram.mutex.Unlock()
+	if len(counts) > len(histogram.counts) {
+		grown := make([]int64, len(counts))
+		copy(grown, histogram.counts)
+		histogram.counts = grown
+	}
+	for i, c := range counts {
+		histogram.counts[i] += c
+	}
+	if histogram.count == 0 || min < histogram.min {
+		histogram.min = min
+	}
+	if max > histogram.max {
+		histogram.max = max
+	}
+	histogram.count += count
+	histogram.sum += sum
+	histogram.sumSq += sumSq
+}
+
+// Count returns the number of recorded values
+func (histogram *Histogram) Count() int64 {
+	histogram.mutex.Lock()
+	defer histogram.mutex.Unlock()
+	return histogram.count
+}
+
+// Percentile returns the value below which the given percentage (0-100) of the recorded values fall
+func (histogram *Histogram) Percentile(percentile float64) int64 {
+	histogram.mutex.Lock()
+	defer histogram.mutex.Unlock()
+	return histogram.percentile(percentile)
+}
+
+func (histogram *Histogram) percentile(percentile float64) int64 {
+	if histogram.count == 0 {
+		return 0
+	}
+	if percentile >= 100 {
+		return histogram.max
+	}
+	if percentile <= 0 {
+		return histogram.min
+	}
+	rank := int64(math.Ceil(percentile / 100 * float64(histogram.count)))
+	var seen int64
+	for index, c := range histogram.counts {
+		seen += c
+		if seen >= rank {
+			value := bucketValue(index)
+			// The bucket middle can fall outside the recorded range for the lowest and highest buckets
+			if value < histogram.min {
+				return histogram.min
+			}
+			if value > histogram.max {
+				return histogram.max
+			}
+			return value
+		}
+	}
+	return histogram.max
+}
+
+// Snapshot returns the aggregates and the common percentiles taken under a single lock
+func (histogram *Histogram) Snapshot() HistogramSnapshot {
+	histogram.mutex.Lock()
+	defer histogram.mutex.Unlock()
+	snapshot := HistogramSnapshot{
+		Count: histogram.count,
+		Min:   histogram.min,
+		Max:   histogram.max,
+	}
+	if histogram.count == 0 {
+		return snapshot
+	}
+	n := float64(histogram.count)
+	snapshot.Mean = histogram.sum / n
+	if variance := histogram.sumSq/n - snapshot.Mean*snapshot.Mean; variance > 0 {
+		snapshot.StdDev = math.Sqrt(variance)
+	}
+	snapshot.P50 = histogram.percentile(50)
+	snapshot.P90 = histogram.percentile(90)
+	snapshot.P95 = histogram.percentile(95)
+	snapshot.P99 = histogram.percentile(99)
+	snapshot.P999 = histogram.percentile(99.9)
+	return snapshot
+}
+
+// CumulativeCounts returns for every bound the number of recorded values up to it, along with the count and sum
+// of all values. Bounds must be ascending, a value is compared by its bucket, so it is placed within 1/64 of itself.
+func (histogram *Histogram) CumulativeCounts(bounds []int64) (counts []int64, count int64, sum float64) {
+	histogram.mutex.Lock()
+	defer histogram.mutex.Unlock()
+	counts = make([]int64, len(bounds))
+	boundIndex := 0
+	var seen int64
+	for index, c := range histogram.counts {
+		if c == 0 {
+			continue
+		}
+		value := bucketValue(index)
+		for boundIndex < len(bounds) && value > bounds[boundIndex] {
+			counts[boundIndex] = seen
+			boundIndex++
+		}
+		seen += c
+	}
+	for ; boundIndex < len(bounds); boundIndex++ {
+		counts[boundIndex] = seen
+	}
+	return counts, histogram.count, histogram.sum
+}
@@ -0,0 +1,75 @@
+package Metrics
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestBucketIndexRoundTrip(t *testing.T) {
+	for _, value := range []int64{0, 1, 127, 128, 129, 1000, 123456, 1 << 40, math.MaxInt64} {
+		represented := bucketValue(bucketIndex(value))
+		relativeError := math.Abs(float64(represented-value)) / math.Max(float64(value), 1)
+		assert.LessOrEqual(t, relativeError, 1.0/64, "value %d represented as %d", value, represented)
+	}
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	histogram := NewHistogram()
+	for i := int64(1); i <= 10000; i++ {
+		histogram.Record(i)
+	}
+	snapshot := histogram.Snapshot()
+	assert.Equal(t, int64(10000), snapshot.Count)
+	assert.Equal(t, int64(1), snapshot.Min)
+	assert.Equal(t, int64(10000), snapshot.Max)
+	assert.InDelta(t, 5000.5, snapshot.Mean, 0.001)
+	assert.InDelta(t, 2886.75, snapshot.StdDev, 0.1)
+	assert.InEpsilon(t, 5000, snapshot.P50, 1.0/64)
+	assert.InEpsilon(t, 9000, snapshot.P90, 1.0/64)
+	assert.InEpsilon(t, 9500, snapshot.P95, 1.0/64)
+	assert.InEpsilon(t, 9900, snapshot.P99, 1.0/64)
+	assert.InEpsilon(t, 9990, snapshot.P999, 1.0/64)
+}
+
+func TestHistogramEmpty(t *testing.T) {
+	snapshot := NewHistogram().Snapshot()
+	assert.Equal(t, HistogramSnapshot{}, snapshot)
+}
+
+func TestHistogramMergeConcurrent(t *testing.T) {
+	total := NewHistogram()
+	var waitGroup sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			shard := NewHistogram()
+			for i := int64(0); i < 1000; i++ {
+				shard.Record(i * 1000)
+			}
+			total.Merge(shard)
+		}()
+	}
+	waitGroup.Wait()
+	snapshot := total.Snapshot()
+	assert.Equal(t, int64(8000), snapshot.Count)
+	assert.Equal(t, int64(0), snapshot.Min)
+	assert.Equal(t, int64(999000), snapshot.Max)
+}
+
+func TestHistogramCumulativeCounts(t *testing.T) {
+	histogram := NewHistogram()
+	for i := int64(1); i <= 1000; i++ {
+		histogram.Record(i)
+	}
+	counts, count, sum := histogram.CumulativeCounts([]int64{0, 10, 100, 500, 2000})
+	assert.Equal(t, int64(1000), count)
+	assert.Equal(t, 500500.0, sum)
+	assert.Equal(t, int64(0), counts[0])
+	assert.Equal(t, int64(10), counts[1])
+	assert.Equal(t, int64(100), counts[2])
+	assert.InDelta(t, 500, counts[3], 500.0/64)
+	assert.Equal(t, int64(1000), counts[4])
+}
@@ -0,0 +1,186 @@
+package Metrics
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLatencyBuckets are the upper bounds in seconds of the exported latency histograms
+var DefaultLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// PrometheusWriter writes metrics in the Prometheus text exposition format, the first write error is kept in Err
+type PrometheusWriter struct {
+	writer io.Writer
+	err    error
+}
+
+func NewPrometheusWriter(writer io.Writer) *PrometheusWriter {
+	return &PrometheusWriter{
+		writer: writer,
+	}
+}
+
+func (prometheusWriter *PrometheusWriter) Err() error {
+	return prometheusWriter.err
+}
+
+func (prometheusWriter *PrometheusWriter) printf(format string, args ...any) {
+	if prometheusWriter.err != nil {
+		return
+	}
+	_, prometheusWriter.err = fmt.Fprintf(prometheusWriter.writer, format, args...)
+}
+
+// Header writes the HELP and TYPE lines of a metric, kind is counter, gauge or histogram
+func (prometheusWriter *PrometheusWriter) Header(name, kind, help string) {
+	prometheusWriter.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Sample writes one sample, labels are name and value pairs
+func (prometheusWriter *PrometheusWriter) Sample(name string, labels []string, value float64) {
+	prometheusWriter.printf("%s%s %s\n", name, formatLabels(labels), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// Histogram writes the buckets, sum and count of histogram. Bounds are in the exported unit,
+// unit is the number of recorded units in one exported unit, like 1e9 for nanoseconds exported as seconds.
+func (prometheusWriter *PrometheusWriter) Histogram(name string, labels []string, histogram *Histogram, bounds []float64, unit float64) {
+	recordedBounds := make([]int64, len(bounds))
+	for i, bound := range bounds {
+		recordedBounds[i] = int64(bound * unit)
+	}
+	counts, count, sum := histogram.CumulativeCounts(recordedBounds)
+	for i, bound := range bounds {
+		prometheusWriter.Sample(name+"_bucket", append(labels[:len(labels):len(labels)], "le", strconv.FormatFloat(bound, 'g', -1, 64)), float64(counts[i]))
+	}
+	prometheusWriter.Sample(name+"_bucket", append(labels[:len(labels):len(labels)], "le", "+Inf"), float64(count))
+	prometheusWriter.Sample(name+"_sum", labels, sum/unit)
+	prometheusWriter.Sample(name+"_count", labels, float64(count))
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var builder strings.Builder
+	builder.WriteString("{")
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			builder.WriteString(",")
+		}
+		builder.WriteString(labels[i])
+		builder.WriteString(`="`)
+		builder.WriteString(labelEscaper.Replace(labels[i+1]))
+		builder.WriteString(`"`)
+	}
+	builder.WriteString("}")
+	return builder.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// urlHost returns the host of a URL label, or the label itself when it does not parse
+func urlHost(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil || parsedURL.Host == "" {
+		return rawURL
+	}
+	return parsedURL.Host
+}
+
+// WritePrometheus writes the counters of the collector labeled by local IP, remote IP and URL host
+func (collector *Collector) WritePrometheus(prometheusWriter *PrometheusWriter) {
+	snapshot := collector.Snapshot()
+	for i := range snapshot.Shards {
+		snapshot.Shards[i].Labels.URL = urlHost(snapshot.Shards[i].Labels.URL)
+	}
+	groups := snapshot.GroupBy(func(labels Labels) string {
+		return labels.LocalIP + " " + labels.RemoteIP + " " + labels.URL
+	})
+	groupLabels := func(group ShardSnapshot, extra ...string) []string {
+		return append([]string{"local_ip", group.Labels.LocalIP, "remote_ip", group.Labels.RemoteIP, "host", group.Labels.URL}, extra...)
+	}
+
+	prometheusWriter.Header("httpbenchmark_downloaded_bytes_total", "counter", "Bytes downloaded.")
+	for _, group := range groups {
+		prometheusWriter.Sample("httpbenchmark_downloaded_bytes_total", groupLabels(group), float64(group.Bytes))
+	}
+	prometheusWriter.Header("httpbenchmark_uploaded_bytes_total", "counter", "Request body bytes uploaded.")
+	for _, group := range groups {
+		prometheusWriter.Sample("httpbenchmark_uploaded_bytes_total", groupLabels(group), float64(group.UploadedBytes))
+	}
+	prometheusWriter.Header("httpbenchmark_requests_total", "counter", "Requests completed successfully.")
+	for _, group := range groups {
+		prometheusWriter.Sample("httpbenchmark_requests_total", groupLabels(group), float64(group.Requests))
+	}
+	prometheusWriter.Header("httpbenchmark_retries_total", "counter", "Retries of failed requests.")
+	for _, group := range groups {
+		prometheusWriter.Sample("httpbenchmark_retries_total", groupLabels(group), float64(group.Retries))
+	}
+	prometheusWriter.Header("httpbenchmark_reused_connections_total", "counter", "Requests sent over a reused connection.")
+	for _, group := range groups {
+		prometheusWriter.Sample("httpbenchmark_reused_connections_total", groupLabels(group), float64(group.ReusedConns))
+	}
+	prometheusWriter.Header("httpbenchmark_responses_total", "counter", "Responses by HTTP status code.")
+	for _, group := range groups {
+		for _, code := range sortedKeys(group.StatusCodes) {
+			prometheusWriter.Sample("httpbenchmark_responses_total", groupLabels(group, "code", strconv.Itoa(code)), float64(group.StatusCodes[code]))
+		}
+	}
+	prometheusWriter.Header("httpbenchmark_errors_total", "counter", "Failed requests by error class.")
+	for _, group := range groups {
+		for _, class := range sortedKeys(group.FailureClasses) {
+			prometheusWriter.Sample("httpbenchmark_errors_total", groupLabels(group, "class", class), float64(group.FailureClasses[class]))
+		}
+	}
+	prometheusWriter.Header("httpbenchmark_throughput_bytes_per_second", "gauge", "Download rate over the sliding window.")
+	for _, group := range groups {
+		prometheusWriter.Sample("httpbenchmark_throughput_bytes_per_second", groupLabels(group), group.Rate)
+	}
+	prometheusWriter.Header("httpbenchmark_upload_throughput_bytes_per_second", "gauge", "Upload rate over the sliding window.")
+	for _, group := range groups {
+		prometheusWriter.Sample("httpbenchmark_upload_throughput_bytes_per_second", groupLabels(group), group.UploadRate)
+	}
+
+	prometheusWriter.Header("httpbenchmark_dns_queries_total", "counter", "DNS queries by DoH server.")
+	for _, dnsServer := range snapshot.DNSServers {
+		prometheusWriter.Sample("httpbenchmark_dns_queries_total", []string{"server", dnsServer.Server}, float64(dnsServer.Queries))
+	}
+	prometheusWriter.Header("httpbenchmark_dns_errors_total", "counter", "Failed DNS queries by DoH server.")
+	for _, dnsServer := range snapshot.DNSServers {
+		prometheusWriter.Sample("httpbenchmark_dns_errors_total", []string{"server", dnsServer.Server}, float64(dnsServer.Failures))
+	}
+	prometheusWriter.Header("httpbenchmark_dns_query_duration_seconds", "histogram", "DNS query latency by DoH server.")
+	collector.mutex.RLock()
+	for _, server := range collector.dnsServerList {
+		prometheusWriter.Histogram("httpbenchmark_dns_query_duration_seconds", []string{"server", server},
+			collector.dnsServers[server].latency, DefaultLatencyBuckets, 1e9)
+	}
+	collector.mutex.RUnlock()
+
+	prometheusWriter.Header("httpbenchmark_active_workers", "gauge", "Download workers running.")
+	prometheusWriter.Sample("httpbenchmark_active_workers", nil, float64(snapshot.ActiveWorkers))
+	prometheusWriter.Header("httpbenchmark_open_connections", "gauge", "Connections open to the download targets.")
+	prometheusWriter.Sample("httpbenchmark_open_connections", nil, float64(snapshot.OpenConns))
+	prometheusWriter.Header("httpbenchmark_worker_failures_total", "counter", "Download worker runs that ended with a panic or an error.")
+	prometheusWriter.Sample("httpbenchmark_worker_failures_total", []string{"kind", "panic"}, float64(snapshot.WorkerPanics))
+	prometheusWriter.Sample("httpbenchmark_worker_failures_total", []string{"kind", "error"}, float64(snapshot.WorkerErrors))
+	prometheusWriter.Header("httpbenchmark_worker_restarts_total", "counter", "Failed download workers restarted by the supervisor.")
+	prometheusWriter.Sample("httpbenchmark_worker_restarts_total", nil, float64(snapshot.WorkerRestarts))
+	prometheusWriter.Header("httpbenchmark_retired_workers_total", "counter", "Failed download workers retired by the supervisor.")
+	prometheusWriter.Sample("httpbenchmark_retired_workers_total", nil, float64(snapshot.RetiredWorkers))
+}
+
+func sortedKeys[K int | string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	return keys
+}
@@ -0,0 +1,35 @@
+package Metrics
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectorWritePrometheus(t *testing.T) {
+	collector := NewCollector()
+	collector.Shard(Labels{LocalIP: "192.168.1.2", RemoteIP: "10.0.0.1", URL: "https://example.com/a"}).AddBytes(100)
+	collector.Shard(Labels{LocalIP: "192.168.1.2", RemoteIP: "10.0.0.1", URL: "https://example.com/b"}).AddBytes(50)
+	collector.Shard(Labels{LocalIP: "192.168.1.2", RemoteIP: "10.0.0.1", URL: "https://example.com/b"}).AddStatus(200)
+	collector.Shard(Labels{LocalIP: "192.168.1.2", RemoteIP: "10.0.0.2", URL: "https://example.com/a"}).AddFailure("body")
+	collector.AddDNSQuery(`https://"doh"/dns-query`, 20*time.Millisecond, false)
+	collector.WorkerStarted()
+
+	var builder strings.Builder
+	prometheusWriter := NewPrometheusWriter(&builder)
+	collector.WritePrometheus(prometheusWriter)
+	assert.Nil(t, prometheusWriter.Err())
+	exposition := builder.String()
+	t.Log("\n" + exposition)
+
+	labels := `local_ip="192.168.1.2",remote_ip="10.0.0.1",host="example.com"`
+	assert.Contains(t, exposition, "# TYPE httpbenchmark_downloaded_bytes_total counter\n")
+	assert.Contains(t, exposition, "httpbenchmark_downloaded_bytes_total{"+labels+"} 150\n")
+	assert.Contains(t, exposition, "httpbenchmark_responses_total{"+labels+`,code="200"} 1`+"\n")
+	assert.Contains(t, exposition, `httpbenchmark_errors_total{local_ip="192.168.1.2",remote_ip="10.0.0.2",host="example.com",class="body"} 1`+"\n")
+	assert.Contains(t, exposition, `httpbenchmark_dns_query_duration_seconds_bucket{server="https://\"doh\"/dns-query",le="0.01"} 0`+"\n")
+	assert.Contains(t, exposition, `httpbenchmark_dns_query_duration_seconds_bucket{server="https://\"doh\"/dns-query",le="0.025"} 1`+"\n")
+	assert.Contains(t, exposition, `httpbenchmark_dns_query_duration_seconds_count{server="https://\"doh\"/dns-query"} 1`+"\n")
+	assert.Contains(t, exposition, "httpbenchmark_active_workers 1\n")
+}
@@ -0,0 +1,99 @@
+package Metrics
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRateWindow = 5 * time.Second
+	DefaultRateSlots  = 20
+)
+
+// RateEstimator measures a byte rate over a sliding window split into slots.
+// Old slots fall out of the window one at a time, so the rate follows the link without jumping at interval edges.
+type RateEstimator struct {
+	mutex     sync.Mutex
+	slotWidth time.Duration
+	slots     []int64
+	head      int
+	headStart time.Time
+	startTime time.Time
+	total     int64
+	now       func() time.Time
+}
+
+func NewRateEstimator(window time.Duration, slots int) *RateEstimator {
+	return newRateEstimator(window, slots, time.Now)
+}
+
+func newRateEstimator(window time.Duration, slots int, now func() time.Time) *RateEstimator {
+	if slots < 1 {
+		slots = 1
+	}
+	startTime := now()
+	return &RateEstimator{
+		slotWidth: window / time.Duration(slots),
+		slots:     make([]int64, slots),
+		headStart: startTime,
+		startTime: startTime,
+		now:       now,
+	}
+}
+
+// Add counts n bytes at the current time
+func (rateEstimator *RateEstimator) Add(n int64) {
+	rateEstimator.mutex.Lock()
+	defer rateEstimator.mutex.Unlock()
+	rateEstimator.advance(rateEstimator.now())
+	rateEstimator.slots[rateEstimator.head] += n
+	rateEstimator.total += n
+}
+
+// advance moves the head to the slot that contains now and clears the slots it passes
+func (rateEstimator *RateEstimator) advance(now time.Time) {
+	passed := int(now.Sub(rateEstimator.headStart) / rateEstimator.slotWidth)
+	if passed <= 0 {
+		return
+	}
+	if passed >= len(rateEstimator.slots) {
+		clear(rateEstimator.slots)
+	} else {
+		for i := 0; i < passed; i++ {
+			rateEstimator.head = (rateEstimator.head + 1) % len(rateEstimator.slots)
+			rateEstimator.slots[rateEstimator.head] = 0
+		}
+	}
+	rateEstimator.headStart = rateEstimator.headStart.Add(time.Duration(passed) * rateEstimator.slotWidth)
+}
+
+// Rate returns the bytes per second over the window, or over the lifetime of the estimator while it is shorter
+func (rateEstimator *RateEstimator) Rate() float64 {
+	rateEstimator.mutex.Lock()
+	defer rateEstimator.mutex.Unlock()
+	now := rateEstimator.now()
+	rateEstimator.advance(now)
+	var sum int64
+	for _, n := range rateEstimator.slots {
+		sum += n
+	}
+	span := time.Duration(len(rateEstimator.slots)-1)*rateEstimator.slotWidth + now.Sub(rateEstimator.headStart)
+	if lifetime := now.Sub(rateEstimator.startTime); lifetime < span {
+		span = lifetime
+	}
+	if span <= 0 {
+		return 0
+	}
+	return float64(sum) / span.Seconds()
+}
+
+// Average returns the bytes per second since the estimator was created
+func (rateEstimator *RateEstimator) Average() float64 {
+	rateEstimator.mutex.Lock()
+	defer rateEstimator.mutex.Unlock()
+	lifetime := rateEstimator.now().Sub(rateEstimator.startTime)
+	if lifetime <= 0 {
+		return 0
+	}
+	return float64(rateEstimator.total) / lifetime.Seconds()
+}
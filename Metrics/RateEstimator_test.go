@@ -0,0 +1,64 @@
+package Metrics
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *fakeClock) Advance(d time.Duration) {
+	clock.now = clock.now.Add(d)
+}
+
+func TestRateEstimatorShortTransfer(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	rateEstimator := newRateEstimator(5*time.Second, 10, clock.Now)
+	// 1 MB in 200ms is 5 MB/s, the old KB/(seconds+1) formula reported a fifth of that
+	for i := 0; i < 4; i++ {
+		clock.Advance(50 * time.Millisecond)
+		rateEstimator.Add(250000)
+	}
+	assert.InDelta(t, 5e6, rateEstimator.Rate(), 1)
+	assert.InDelta(t, 5e6, rateEstimator.Average(), 1)
+}
+
+func TestRateEstimatorSlidingWindow(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	rateEstimator := newRateEstimator(time.Second, 10, clock.Now)
+	for i := 0; i < 20; i++ {
+		rateEstimator.Add(100)
+		clock.Advance(100 * time.Millisecond)
+	}
+	assert.InDelta(t, 1000, rateEstimator.Rate(), 1)
+	// The link goes idle, the window drains while the average keeps the history.
+	// Only the four slots from 1.6s to 1.9s are left, over the 0.9s the window spans at a slot boundary.
+	clock.Advance(500 * time.Millisecond)
+	assert.InDelta(t, 400/0.9, rateEstimator.Rate(), 1)
+	clock.Advance(time.Second)
+	assert.Equal(t, 0.0, rateEstimator.Rate())
+	assert.InDelta(t, 2000/3.5, rateEstimator.Average(), 1)
+}
+
+func TestCountingReader(t *testing.T) {
+	var reported int64
+	countingReader := NewCountingReader(strings.NewReader("0123456789"), func(n int64) {
+		reported += n
+	})
+	buffer := make([]byte, 4)
+	for {
+		if _, err := countingReader.Read(buffer); err != nil {
+			break
+		}
+	}
+	assert.Equal(t, int64(10), countingReader.Count())
+	assert.Equal(t, int64(10), reported)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"HttpBenchmark/Metrics"
+	"context"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+// WritePrometheus writes the collector metrics and the request phase histograms
+func (runStats *RunStats) WritePrometheus(prometheusWriter *Metrics.PrometheusWriter) {
+	runStats.collector.WritePrometheus(prometheusWriter)
+	prometheusWriter.Header("httpbenchmark_request_phase_duration_seconds", "histogram", "Download request latency by phase.")
+	for _, phase := range phases {
+		prometheusWriter.Histogram("httpbenchmark_request_phase_duration_seconds", []string{"phase", phase},
+			runStats.phaseHistograms[phase], Metrics.DefaultLatencyBuckets, float64(time.Second))
+	}
+	if runStats.schedule != nil {
+		prometheusWriter.Header("httpbenchmark_schedule_backlog", "gauge", "Open-loop requests that are due but not yet sent.")
+		prometheusWriter.Sample("httpbenchmark_schedule_backlog", nil, float64(runStats.schedule.Backlog()))
+	}
+	if loadProfile := runStats.loadProfile; loadProfile != nil {
+		index, concurrency := loadProfile.Current()
+		prometheusWriter.Header("httpbenchmark_stage", "gauge", "The index of the running load profile stage, -1 before it started.")
+		prometheusWriter.Sample("httpbenchmark_stage", nil, float64(index))
+		prometheusWriter.Header("httpbenchmark_target_concurrency", "gauge", "The requests the running stage lets in flight.")
+		prometheusWriter.Sample("httpbenchmark_target_concurrency", nil, float64(concurrency))
+		prometheusWriter.Header("httpbenchmark_requests_in_flight", "gauge", "The requests in flight under the load profile.")
+		prometheusWriter.Sample("httpbenchmark_requests_in_flight", nil, float64(loadProfile.Active()))
+	}
+	if limiter := runStats.bandwidthLimiter; limiter != nil {
+		prometheusWriter.Header("httpbenchmark_bandwidth_limit_bytes_per_second", "gauge", "The bandwidth cap of the run, 0 when only local IPs are capped.")
+		prometheusWriter.Sample("httpbenchmark_bandwidth_limit_bytes_per_second", nil, limiter.Limit)
+		prometheusWriter.Header("httpbenchmark_throttled_seconds_total", "counter", "Time the transfers waited for the bandwidth cap.")
+		prometheusWriter.Sample("httpbenchmark_throttled_seconds_total", nil, limiter.Throttled().Seconds())
+		prometheusWriter.Header("httpbenchmark_throttled_transfers", "gauge", "Transfers waiting for the bandwidth cap.")
+		prometheusWriter.Sample("httpbenchmark_throttled_transfers", nil, float64(limiter.Waiting()))
+	}
+	if protocols := runStats.Protocols(); len(protocols) > 0 {
+		prometheusWriter.Header("httpbenchmark_protocol_requests_total", "counter", "Completed requests by negotiated protocol.")
+		for _, protocol := range protocols {
+			prometheusWriter.Sample("httpbenchmark_protocol_requests_total", []string{"protocol", protocol}, float64(runStats.protocolStats(protocol).requests.Load()))
+		}
+	}
+	if happyEyeballs := runStats.happyEyeballs; happyEyeballs != nil {
+		prometheusWriter.Header("httpbenchmark_happy_eyeballs_races_total", "counter", "Connect races between IPv6 and IPv4.")
+		prometheusWriter.Sample("httpbenchmark_happy_eyeballs_races_total", nil, float64(happyEyeballs.Races()))
+		families := []string{FamilyIPv6, FamilyIPv4}
+		prometheusWriter.Header("httpbenchmark_happy_eyeballs_wins_total", "counter", "Connect races won by the IP family.")
+		for _, family := range families {
+			prometheusWriter.Sample("httpbenchmark_happy_eyeballs_wins_total", []string{"family", family}, float64(happyEyeballs.Family(family).Wins()))
+		}
+		prometheusWriter.Header("httpbenchmark_happy_eyeballs_connect_attempts_total", "counter", "Connect attempts of the IP family in the races.")
+		for _, family := range families {
+			prometheusWriter.Sample("httpbenchmark_happy_eyeballs_connect_attempts_total", []string{"family", family}, float64(happyEyeballs.Family(family).Attempts()))
+		}
+		prometheusWriter.Header("httpbenchmark_happy_eyeballs_connect_successes_total", "counter", "Successful connects of the IP family in the races.")
+		for _, family := range families {
+			prometheusWriter.Sample("httpbenchmark_happy_eyeballs_connect_successes_total", []string{"family", family}, float64(happyEyeballs.Family(family).Successes()))
+		}
+	}
+	if tlsSessions := runStats.TLSSessions(); len(tlsSessions) > 0 {
+		prometheusWriter.Header("httpbenchmark_tls_connections_total", "counter", "TLS connections by remote IP and negotiated setup.")
+		for _, tlsSession := range tlsSessions {
+			prometheusWriter.Sample("httpbenchmark_tls_connections_total", []string{"remote_ip", tlsSession.RemoteIP, "version", tlsSession.Version,
+				"cipher_suite", tlsSession.CipherSuite, "alpn", tlsSession.ALPN, "curve", tlsSession.Curve, "fingerprint", tlsSession.Fingerprint},
+				float64(tlsSession.Connections))
+		}
+		prometheusWriter.Header("httpbenchmark_tls_certificate_expiry_timestamp_seconds", "gauge", "Expiry of the leaf certificate a remote IP served.")
+		// A certificate negotiated with several setups is still a single series
+		certificates := make(map[string]bool)
+		for _, tlsSession := range tlsSessions {
+			certificate := tlsSession.RemoteIP + " " + tlsSession.Fingerprint
+			if tlsSession.Fingerprint != "" && !certificates[certificate] {
+				certificates[certificate] = true
+				prometheusWriter.Sample("httpbenchmark_tls_certificate_expiry_timestamp_seconds", []string{"remote_ip", tlsSession.RemoteIP,
+					"subject", tlsSession.Subject, "fingerprint", tlsSession.Fingerprint}, float64(tlsSession.NotAfter.Unix()))
+			}
+		}
+	}
+	if quicStats := runStats.quicStats; quicStats != nil {
+		prometheusWriter.Header("httpbenchmark_quic_handshakes_total", "counter", "Completed QUIC handshakes.")
+		prometheusWriter.Sample("httpbenchmark_quic_handshakes_total", nil, float64(quicStats.Handshakes()))
+		prometheusWriter.Header("httpbenchmark_quic_0rtt_handshakes_total", "counter", "QUIC handshakes that resumed with 0-RTT.")
+		prometheusWriter.Sample("httpbenchmark_quic_0rtt_handshakes_total", nil, float64(quicStats.ZeroRTT()))
+		prometheusWriter.Header("httpbenchmark_quic_packets_sent_total", "counter", "QUIC packets sent.")
+		prometheusWriter.Sample("httpbenchmark_quic_packets_sent_total", nil, float64(quicStats.PacketsSent()))
+		prometheusWriter.Header("httpbenchmark_quic_packets_lost_total", "counter", "QUIC packets declared lost and retransmitted.")
+		prometheusWriter.Sample("httpbenchmark_quic_packets_lost_total", nil, float64(quicStats.PacketsLost()))
+	}
+}
+
+func newMetricsHandler(runStats *RunStats) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		prometheusWriter := Metrics.NewPrometheusWriter(w)
+		runStats.WritePrometheus(prometheusWriter)
+		if err := prometheusWriter.Err(); err != nil {
+			log.Debugf("Error writing metrics: %s", err)
+		}
+	})
+	return mux
+}
+
+// startMetricsServer serves the Prometheus metrics on listenAddr until stopMetricsServer is called
+func startMetricsServer(listenAddr string, runStats *RunStats) (stopMetricsServer func()) {
+	server := &http.Server{
+		Addr:              listenAddr,
+		Handler:           newMetricsHandler(runStats),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Infof("Serving Prometheus metrics on http://%s/metrics", listenAddr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("Error in metrics server: %s", err)
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Errorf("Error shutting down the metrics server: %s", err)
+		}
+	}
+}
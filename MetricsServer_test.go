@@ -0,0 +1,33 @@
+package main
+
+import (
+	"HttpBenchmark/Metrics"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	runStats := NewRunStats()
+	shard := runStats.Shard(Metrics.Labels{RemoteIP: "10.0.0.1", URL: "https://example.com/a"})
+	shard.AddBytes(1000)
+	runStats.AddRequest(shard, 1000, 0, &RequestTiming{Connect: 3 * time.Millisecond, Total: 40 * time.Millisecond})
+
+	server := httptest.NewServer(newMetricsHandler(runStats))
+	defer server.Close()
+	response, err := http.Get(server.URL + "/metrics")
+	assert.Nil(t, err)
+	body, err := io.ReadAll(response.Body)
+	assert.Nil(t, err)
+	assert.Nil(t, response.Body.Close())
+
+	exposition := string(body)
+	assert.Contains(t, response.Header.Get("Content-Type"), "text/plain")
+	assert.Contains(t, exposition, `httpbenchmark_downloaded_bytes_total{local_ip="",remote_ip="10.0.0.1",host="example.com"} 1000`)
+	assert.Contains(t, exposition, `httpbenchmark_request_phase_duration_seconds_bucket{phase="connect",le="0.0025"} 0`)
+	assert.Contains(t, exposition, `httpbenchmark_request_phase_duration_seconds_bucket{phase="connect",le="0.005"} 1`)
+	assert.Contains(t, exposition, `httpbenchmark_request_phase_duration_seconds_count{phase="total"} 1`)
+}
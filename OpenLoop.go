@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RequestSchedule is the fixed timeline of an open-loop run: the n-th request is due at start + n/rate,
+// however long the earlier ones take, and the timeline starts with the first reserved slot.
+// Workers reserve the slots in order, a slot that is due but not yet reserved is backlog,
+// the target or the worker pool cannot keep up with the rate.
+type RequestSchedule struct {
+	Rate float64
+	// start is the Unix time in nanoseconds of the first slot, 0 until a slot is reserved
+	start      atomic.Int64
+	reserved   atomic.Int64
+	maxBacklog atomic.Int64
+}
+
+func NewRequestSchedule(rate float64) *RequestSchedule {
+	return &RequestSchedule{
+		Rate: rate,
+	}
+}
+
+// ParseRate parses a request rate like 200, 200/s or 600/m into requests per second
+func ParseRate(value string) (float64, error) {
+	unit := time.Second
+	if number, suffix, found := strings.Cut(value, "/"); found {
+		switch suffix {
+		case "s":
+		case "m":
+			unit = time.Minute
+		case "h":
+			unit = time.Hour
+		default:
+			return 0, fmt.Errorf("invalid rate unit %q, use /s, /m or /h", suffix)
+		}
+		value = number
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 {
+		return 0, fmt.Errorf("invalid rate %q", value)
+	}
+	return rate / unit.Seconds(), nil
+}
+
+// Next reserves the next slot and returns the time it is due
+func (schedule *RequestSchedule) Next() time.Time {
+	now := time.Now()
+	schedule.start.CompareAndSwap(0, now.UnixNano())
+	slot := schedule.reserved.Add(1) - 1
+	// Slots reserved after their due time show how far the workers fall behind
+	backlog := schedule.due(now) - slot - 1
+	for {
+		maxBacklog := schedule.maxBacklog.Load()
+		if backlog <= maxBacklog || schedule.maxBacklog.CompareAndSwap(maxBacklog, backlog) {
+			break
+		}
+	}
+	return schedule.slotTime(slot)
+}
+
+// Backlog returns the number of slots that are due but not yet reserved
+func (schedule *RequestSchedule) Backlog() int64 {
+	return max(schedule.due(time.Now())-schedule.reserved.Load(), 0)
+}
+
+// MaxBacklog returns the largest backlog a worker found when it reserved a slot
+func (schedule *RequestSchedule) MaxBacklog() int64 {
+	return schedule.maxBacklog.Load()
+}
+
+// Scheduled returns the number of reserved slots
+func (schedule *RequestSchedule) Scheduled() int64 {
+	return schedule.reserved.Load()
+}
+
+// due returns the number of slots due at now
+func (schedule *RequestSchedule) due(now time.Time) int64 {
+	start := schedule.start.Load()
+	if start == 0 || now.UnixNano() < start {
+		return 0
+	}
+	return int64(float64(now.UnixNano()-start)/float64(time.Second)*schedule.Rate) + 1
+}
+
+func (schedule *RequestSchedule) slotTime(slot int64) time.Time {
+	return time.Unix(0, schedule.start.Load()).Add(time.Duration(float64(slot) / schedule.Rate * float64(time.Second)))
+}
+
+// sleepUntil waits for t, it returns false when ctx is cancelled first
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	wait := time.Until(t)
+	if wait <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
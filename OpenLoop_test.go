@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRate(t *testing.T) {
+	for value, want := range map[string]float64{"200": 200, "200/s": 200, "600/m": 10, "7200/h": 2, "0.5": 0.5} {
+		rate, err := ParseRate(value)
+		assert.Nil(t, err, value)
+		assert.Equal(t, want, rate, value)
+	}
+	for _, value := range []string{"", "fast", "10/d", "-1/s"} {
+		_, err := ParseRate(value)
+		assert.NotNil(t, err, value)
+	}
+}
+
+func TestRequestScheduleTimeline(t *testing.T) {
+	schedule := NewRequestSchedule(100)
+	assert.Equal(t, int64(0), schedule.Backlog())
+	first := schedule.Next()
+	second := schedule.Next()
+	third := schedule.Next()
+	assert.Equal(t, 10*time.Millisecond, second.Sub(first))
+	assert.Equal(t, 20*time.Millisecond, third.Sub(first))
+	assert.Equal(t, int64(3), schedule.Scheduled())
+	assert.Equal(t, int64(0), schedule.MaxBacklog())
+
+	// Nobody reserves slots for 100ms, about ten of them fall due
+	time.Sleep(100 * time.Millisecond)
+	assert.InDelta(t, 8, schedule.Backlog(), 3)
+	late := schedule.Next()
+	assert.Equal(t, 30*time.Millisecond, late.Sub(first))
+	assert.InDelta(t, 7, schedule.MaxBacklog(), 3)
+}
+
+func TestOpenLoopLatencyFromScheduledTime(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every response takes longer than the interval of the schedule, the requests queue up
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	runStats := NewRunStats()
+	schedule := NewRequestSchedule(200)
+	runStats.SetSchedule(schedule)
+	downloadHttpConfig := newTestDownloadHttpConfig(t, server)
+	for _, opt := range []DownloadHttpConfigOption{WithRunStats(runStats), WithSingleIpDownloadTimes(5), WithSchedule(schedule)} {
+		opt(downloadHttpConfig)
+	}
+	assert.Nil(t, downloadHttpConfig.DoHttpDownload(context.Background()))
+
+	queue := runStats.phaseHistograms[PhaseQueue].Snapshot()
+	total := runStats.phaseHistograms[PhaseTotal].Snapshot()
+	assert.Equal(t, int64(5), queue.Count)
+	// The last request was due 20ms after the first one but could only start after four responses of 20ms
+	assert.Greater(t, queue.Max, int64(40*time.Millisecond))
+	assert.Greater(t, total.Max, queue.Max+int64(20*time.Millisecond))
+	assert.Greater(t, schedule.MaxBacklog(), int64(0))
+
+	report := runStats.Report(ReportConfig{}, StopReasonLimit)
+	assert.Equal(t, 200.0, report.OpenLoop.TargetRate)
+	assert.Equal(t, int64(5), report.OpenLoop.Scheduled)
+	assert.Contains(t, runStats.Summary(), "Open loop: target 200.00 req/s")
+}
+
+func TestOpenLoopRetryLatencyFromScheduledTime(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first attempt fails, the retry succeeds
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	const backoff = 50 * time.Millisecond
+	runStats := NewRunStats()
+	schedule := NewRequestSchedule(1)
+	downloadHttpConfig := newTestDownloadHttpConfig(t, server)
+	for _, opt := range []DownloadHttpConfigOption{WithRunStats(runStats), WithSingleIpDownloadTimes(1), WithSchedule(schedule),
+		WithRetryPolicy(NewRetryPolicy(WithMaxAttempts(2), WithBackoff(backoff, backoff)))} {
+		opt(downloadHttpConfig)
+	}
+	assert.Nil(t, downloadHttpConfig.DoHttpDownload(context.Background()))
+
+	// The latency of the retried request runs from the time it was due, across the failed attempt and the backoff,
+	// which is jittered down to half of it
+	total := runStats.phaseHistograms[PhaseTotal].Snapshot()
+	assert.Equal(t, int64(1), total.Count)
+	assert.GreaterOrEqual(t, total.Min, int64(backoff/2))
+}
@@ -4,6 +4,7 @@ param (
     $exePath = 'F:\Golang\HttpBenchmark\out\HttpBenchmark.exe', # Default executable path
     $processCount = 1, # Default process count
     $parallelCnt = 8,
+    $configPath = '', # Optional HttpBenchmark profile, like HttpBenchmark.yaml
     $source = 'winget', # Default source
     $name = @('Baidu', 'NetEase', 'Youku', 'iQIYI', 'Tencent', 'Bilibili', 'Sohu', 'Xunlei', 'Douyu', 'Alibaba', 'Xiaomi', 'JetBrains', 'Microsoft', 'Intel')
 )
@@ -21,7 +22,7 @@ function StopExistingProcesses($exeName) {
     }
 }
 
-function StartNewProcesses($exePath, $urls, $localIPs, $processCount, $parallelCnt, $crawlerMode) {
+function StartNewProcesses($exePath, $urls, $localIPs, $processCount, $parallelCnt, $crawlerMode, $configPath) {
     $processes = @()
     $localIPsCount = $localIPs.Length
     $urlsCount = $urls.Length
@@ -34,6 +35,9 @@ function StartNewProcesses($exePath, $urls, $localIPs, $processCount, $parallelC
             if ($crawlerMode) {
                 $command += ' -crawlerMode true'
             }
+            if ($configPath -ne '') {
+                $command += " -config '$configPath'"
+            }
             try {
                 $process = Start-Process -FilePath PowerShell -ArgumentList "-Command $command" -PassThru -NoNewWindow
                 $processes += $process
@@ -234,7 +238,7 @@ do {
 
 $allProcesses = @()
 
-$processes = StartNewProcesses $exePath $urls $localIPs $processCount $parallelCnt $crawlerMode
+$processes = StartNewProcesses $exePath $urls $localIPs $processCount $parallelCnt $crawlerMode $configPath
 $allProcesses += $processes
 
 
@@ -0,0 +1,650 @@
+package main
+
+import (
+	"HttpBenchmark/Metrics"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report formats
+const (
+	OutputJSON = "json"
+	OutputCSV  = "csv"
+	OutputText = "text"
+)
+
+// Why a run ended
+const (
+	StopReasonLimit  = "limit"
+	StopReasonSignal = "signal"
+)
+
+// Report is the structured end-of-run report written with -output json or csv
+type Report struct {
+	Config           ReportConfig         `json:"config"`
+	StartTime        time.Time            `json:"start_time"`
+	EndTime          time.Time            `json:"end_time"`
+	ElapsedSeconds   float64              `json:"elapsed_seconds"`
+	StopReason       string               `json:"stop_reason"`
+	Totals           ReportCounters       `json:"totals"`
+	LocalIPs         []ReportCounters     `json:"local_ips"`
+	RemoteIPs        []ReportCounters     `json:"remote_ips"`
+	URLs             []ReportCounters     `json:"urls"`
+	IPFamilies       []ReportFamily       `json:"ip_families"`
+	Protocols        []ReportProtocol     `json:"protocols"`
+	Errors           map[string]int64     `json:"errors"`
+	DNSQueries       int64                `json:"dns_queries"`
+	Latency          []ReportDistribution `json:"latency"`
+	Throughput       ReportDistribution   `json:"throughput"`
+	UploadThroughput ReportDistribution   `json:"upload_throughput"`
+	DNSAnswers       []DNSAnswer          `json:"dns_answers"`
+	TLSSessions      []TLSSession         `json:"tls_sessions"`
+	Workers          ReportWorkers        `json:"workers"`
+	OpenLoop         *ReportOpenLoop      `json:"open_loop,omitempty"`
+	Bandwidth        *ReportBandwidth     `json:"bandwidth,omitempty"`
+	Stages           []ReportStage        `json:"stages,omitempty"`
+	HappyEyeballs    *ReportHappyEyeballs `json:"happy_eyeballs,omitempty"`
+	QUIC             *ReportQUIC          `json:"quic,omitempty"`
+}
+
+// ReportQUIC holds the QUIC connections of an HTTP/3 run
+type ReportQUIC struct {
+	Handshakes int64              `json:"handshakes"`
+	ZeroRTT    int64              `json:"zero_rtt"`
+	Handshake  ReportDistribution `json:"handshake"`
+	// PacketsLost are the packets quic-go declared lost, their frames were retransmitted in later packets
+	PacketsSent   int64   `json:"packets_sent"`
+	PacketsLost   int64   `json:"packets_lost"`
+	LossRate      float64 `json:"loss_rate"`
+	ProbeTimeouts int64   `json:"probe_timeouts"`
+}
+
+// ReportHappyEyeballs shows which IP family won the connect races of -happy-eyeballs and by how much
+type ReportHappyEyeballs struct {
+	DelayMs     float64 `json:"delay_ms"`
+	MeasureBoth bool    `json:"measure_both"`
+	Races       int64   `json:"races"`
+	FailedRaces int64   `json:"failed_races"`
+	// Margin is how much earlier the winner connected than the loser, in races both families connected. Without
+	// MeasureBoth the loser is cancelled, only near ties are measured.
+	Margin   ReportDistribution `json:"margin"`
+	Families []ReportRaceFamily `json:"families"`
+}
+
+// ReportRaceFamily holds the connect attempts of one IP family in the races
+type ReportRaceFamily struct {
+	Name        string             `json:"name"`
+	Attempts    int64              `json:"attempts"`
+	Successes   int64              `json:"successes"`
+	SuccessRate float64            `json:"success_rate"`
+	Wins        int64              `json:"wins"`
+	Connect     ReportDistribution `json:"connect"`
+}
+
+// ReportStage holds the counters of one stage of a load profile, so that the stage where the throughput saturates
+// or the errors climb stands out
+type ReportStage struct {
+	Name        string `json:"name"`
+	Concurrency int    `json:"concurrency"`
+	// Ramp marks a stage that moves linearly from the concurrency before it to its own
+	Ramp bool `json:"ramp"`
+	// StartSeconds is the offset of the stage from the start of the profile, DurationSeconds how long it ran
+	StartSeconds               float64            `json:"start_seconds"`
+	DurationSeconds            float64            `json:"duration_seconds"`
+	Requests                   int64              `json:"requests"`
+	Failures                   int64              `json:"failures"`
+	ErrorRate                  float64            `json:"error_rate"`
+	Bytes                      int64              `json:"bytes"`
+	UploadedBytes              int64              `json:"uploaded_bytes"`
+	AverageBitsPerSecond       float64            `json:"average_bits_per_second"`
+	AverageUploadBitsPerSecond float64            `json:"average_upload_bits_per_second"`
+	Latency                    ReportDistribution `json:"latency"`
+}
+
+// ReportProtocol holds the requests and connections of a negotiated protocol, so that h1 and h2 runs against the
+// same edge compare
+type ReportProtocol struct {
+	Name        string `json:"name"`
+	Requests    int64  `json:"requests"`
+	Bytes       int64  `json:"bytes"`
+	Connections int64  `json:"connections"`
+	// StreamsPerConnection is the average number of requests a connection carried, PeakStreams the distribution of
+	// the most streams in flight at once on a connection
+	StreamsPerConnection float64            `json:"streams_per_connection"`
+	PeakStreams          ReportDistribution `json:"peak_streams"`
+	StreamThroughput     ReportDistribution `json:"stream_throughput"`
+}
+
+// ReportFamily holds the counters of a URL over one IP family, Name is IPv4 or IPv6, so that a dual run compares
+// the throughput of both for the same URL
+type ReportFamily struct {
+	URL string `json:"url"`
+	ReportCounters
+}
+
+// ReportBandwidth shows how much the bandwidth cap of a run throttled its transfers, the caps are in bytes per second
+type ReportBandwidth struct {
+	Limit            float64 `json:"limit"`
+	PerLocalIP       float64 `json:"per_local_ip"`
+	ThrottledReads   int64   `json:"throttled_reads"`
+	ThrottledSeconds float64 `json:"throttled_seconds"`
+}
+
+// ReportOpenLoop compares the request rate of an open-loop run with its target
+type ReportOpenLoop struct {
+	TargetRate float64 `json:"target_rate"`
+	// AchievedRate is the rate of the completed requests over the whole run
+	AchievedRate float64 `json:"achieved_rate"`
+	Scheduled    int64   `json:"scheduled"`
+	Backlog      int64   `json:"backlog"`
+	MaxBacklog   int64   `json:"max_backlog"`
+}
+
+// ReportWorkers counts the failed worker runs and what the supervisor did about them
+type ReportWorkers struct {
+	Panics   int64 `json:"panics"`
+	Errors   int64 `json:"errors"`
+	Restarts int64 `json:"restarts"`
+	Retired  int64 `json:"retired"`
+}
+
+// ReportConfig is the configuration the run was started with
+type ReportConfig struct {
+	URL      string `json:"url"`
+	IPFamily string `json:"ip_family"`
+	LocalIP  string `json:"local_ip"`
+	// LocalIPs are the source addresses the workers were spread over, LocalIP is the one of the DNS queries
+	LocalIPs    []string `json:"local_ips"`
+	HTTPMethod  string   `json:"http_method"`
+	Upload      bool     `json:"upload"`
+	Chunked     bool     `json:"chunked"`
+	HTTPVersion string   `json:"http_version"`
+	Streams     int      `json:"streams"`
+	ConnMode    string   `json:"conn_mode"`
+	MaxAttempts int      `json:"max_attempts"`
+	// Rate is the target request rate of an open-loop run, 0 in closed-loop runs
+	Rate                  float64 `json:"rate"`
+	Parallel              int     `json:"parallel"`
+	SingleIpDownloadTimes int     `json:"single_ip_download_times"`
+	CrawlerMode           bool    `json:"crawler_mode"`
+	Duration              string  `json:"duration"`
+	MaxBytes              int64   `json:"max_bytes"`
+	MaxRequests           int64   `json:"max_requests"`
+}
+
+// ReportCounters are the totals of a remote IP, a URL or the whole run
+type ReportCounters struct {
+	Name        string `json:"name,omitempty"`
+	Bytes       int64  `json:"bytes"`
+	Requests    int64  `json:"requests"`
+	Failures    int64  `json:"failures"`
+	Retries     int64  `json:"retries"`
+	ReusedConns int64  `json:"reused_conns"`
+	// ConnReuseRatio is the share of the requests that went over a reused connection
+	ConnReuseRatio             float64 `json:"conn_reuse_ratio"`
+	AverageBitsPerSecond       float64 `json:"average_bits_per_second"`
+	UploadedBytes              int64   `json:"uploaded_bytes"`
+	AverageUploadBitsPerSecond float64 `json:"average_upload_bits_per_second"`
+}
+
+// ReportDistribution is the distribution of a request phase in milliseconds, or of the per-request throughput in bits per second
+type ReportDistribution struct {
+	Name   string  `json:"name"`
+	Unit   string  `json:"unit"`
+	Count  int64   `json:"count"`
+	Min    float64 `json:"min"`
+	Mean   float64 `json:"mean"`
+	Max    float64 `json:"max"`
+	StdDev float64 `json:"stddev"`
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+	P95    float64 `json:"p95"`
+	P99    float64 `json:"p99"`
+	P999   float64 `json:"p99_9"`
+}
+
+func newReportConfig(runOptions *RunOptions, downloadHttpConfig *DownloadHttpConfig, runLimit *RunLimit) ReportConfig {
+	reportConfig := ReportConfig{
+		URL:                   downloadHttpConfig.url.String(),
+		HTTPMethod:            downloadHttpConfig.HTTPMethod,
+		Upload:                downloadHttpConfig.Upload,
+		Chunked:               downloadHttpConfig.Chunked,
+		HTTPVersion:           downloadHttpConfig.HTTPVersion,
+		Streams:               downloadHttpConfig.Streams,
+		ConnMode:              downloadHttpConfig.ConnMode(),
+		MaxAttempts:           1,
+		Parallel:              runOptions.ParallelDownloads,
+		SingleIpDownloadTimes: downloadHttpConfig.SingleIpDownloadTimes,
+		CrawlerMode:           runOptions.CrawlerMode,
+		Duration:              runLimit.Duration.String(),
+		MaxBytes:              runLimit.MaxBytes,
+		MaxRequests:           runLimit.MaxRequests,
+	}
+	if downloadHttpConfig.schedule != nil {
+		reportConfig.Rate = downloadHttpConfig.schedule.Rate
+	}
+	if downloadHttpConfig.retryPolicy != nil {
+		reportConfig.MaxAttempts = downloadHttpConfig.retryPolicy.MaxAttempts
+	}
+	if downloadHttpConfig.LocalIP != nil {
+		reportConfig.LocalIP = downloadHttpConfig.LocalIP.String()
+	}
+	if runOptions.LocalIPs != nil {
+		reportConfig.LocalIPs = runOptions.LocalIPs.IPs()
+	}
+	reportConfig.IPFamily = runOptions.IPFamily
+	return reportConfig
+}
+
+// Report builds the end-of-run report from a single snapshot of the counters
+func (runStats *RunStats) Report(reportConfig ReportConfig, stopReason string) *Report {
+	endTime := time.Now()
+	elapsed := endTime.Sub(runStats.startTime)
+	snapshot := runStats.collector.Snapshot()
+	report := &Report{
+		Config:         reportConfig,
+		StartTime:      runStats.startTime,
+		EndTime:        endTime,
+		ElapsedSeconds: elapsed.Seconds(),
+		StopReason:     stopReason,
+		Totals:         newReportCounters("", snapshot.Total, elapsed),
+		Errors:         make(map[string]int64),
+		DNSQueries:     snapshot.DNSQueries,
+		DNSAnswers:     runStats.DNSAnswers(),
+		TLSSessions:    runStats.TLSSessions(),
+		Workers: ReportWorkers{
+			Panics:   snapshot.WorkerPanics,
+			Errors:   snapshot.WorkerErrors,
+			Restarts: snapshot.WorkerRestarts,
+			Retired:  snapshot.RetiredWorkers,
+		},
+	}
+	if runStats.schedule != nil {
+		report.OpenLoop = &ReportOpenLoop{
+			TargetRate: runStats.schedule.Rate,
+			Scheduled:  runStats.schedule.Scheduled(),
+			Backlog:    runStats.schedule.Backlog(),
+			MaxBacklog: runStats.schedule.MaxBacklog(),
+		}
+		if elapsed > 0 {
+			report.OpenLoop.AchievedRate = float64(snapshot.Total.Requests) / elapsed.Seconds()
+		}
+	}
+	if limiter := runStats.bandwidthLimiter; limiter != nil {
+		report.Bandwidth = &ReportBandwidth{
+			Limit:            limiter.Limit,
+			PerLocalIP:       limiter.PerLocalIP,
+			ThrottledReads:   limiter.Waits(),
+			ThrottledSeconds: limiter.Throttled().Seconds(),
+		}
+	}
+	if runStats.loadProfile != nil {
+		report.Stages = runStats.stageReports(endTime)
+	}
+	if happyEyeballs := runStats.happyEyeballs; happyEyeballs != nil {
+		report.HappyEyeballs = &ReportHappyEyeballs{
+			DelayMs:     float64(happyEyeballs.Delay) / float64(time.Millisecond),
+			MeasureBoth: happyEyeballs.MeasureBoth,
+			Races:       happyEyeballs.Races(),
+			FailedRaces: happyEyeballs.FailedRaces(),
+			Margin:      newReportDistribution("margin", "ms", happyEyeballs.Margins(), 1/float64(time.Millisecond)),
+		}
+		for _, family := range []string{FamilyIPv6, FamilyIPv4} {
+			raceFamilyStats := happyEyeballs.Family(family)
+			report.HappyEyeballs.Families = append(report.HappyEyeballs.Families, ReportRaceFamily{
+				Name:        family,
+				Attempts:    raceFamilyStats.Attempts(),
+				Successes:   raceFamilyStats.Successes(),
+				SuccessRate: raceFamilyStats.SuccessRate(),
+				Wins:        raceFamilyStats.Wins(),
+				Connect:     newReportDistribution("connect", "ms", raceFamilyStats.Connect(), 1/float64(time.Millisecond)),
+			})
+		}
+	}
+	if quicStats := runStats.quicStats; quicStats != nil {
+		report.QUIC = &ReportQUIC{
+			Handshakes:    quicStats.Handshakes(),
+			ZeroRTT:       quicStats.ZeroRTT(),
+			Handshake:     newReportDistribution("handshake", "ms", quicStats.Handshake(), 1/float64(time.Millisecond)),
+			PacketsSent:   quicStats.PacketsSent(),
+			PacketsLost:   quicStats.PacketsLost(),
+			LossRate:      quicStats.LossRate(),
+			ProbeTimeouts: quicStats.ProbeTimeouts(),
+		}
+	}
+	for _, group := range snapshot.GroupBy(func(labels Metrics.Labels) string { return labels.LocalIP }) {
+		report.LocalIPs = append(report.LocalIPs, newReportCounters(group.Labels.LocalIP, group, elapsed))
+	}
+	for _, group := range snapshot.GroupBy(func(labels Metrics.Labels) string { return labels.RemoteIP }) {
+		report.RemoteIPs = append(report.RemoteIPs, newReportCounters(group.Labels.RemoteIP, group, elapsed))
+	}
+	for _, group := range snapshot.GroupBy(func(labels Metrics.Labels) string { return labels.URL }) {
+		report.URLs = append(report.URLs, newReportCounters(group.Labels.URL, group, elapsed))
+	}
+	for _, family := range []string{FamilyIPv4, FamilyIPv6} {
+		for _, group := range familySnapshot(snapshot, family).GroupBy(func(labels Metrics.Labels) string { return labels.URL }) {
+			report.IPFamilies = append(report.IPFamilies, ReportFamily{URL: group.Labels.URL, ReportCounters: newReportCounters(family, group, elapsed)})
+		}
+	}
+	for _, protocol := range runStats.Protocols() {
+		protocolStats := runStats.protocolStats(protocol)
+		report.Protocols = append(report.Protocols, ReportProtocol{
+			Name:                 protocol,
+			Requests:             protocolStats.requests.Load(),
+			Bytes:                protocolStats.bytes.Load(),
+			Connections:          protocolStats.connections.Load(),
+			StreamsPerConnection: protocolStats.StreamsPerConnection(),
+			PeakStreams:          newReportDistribution("peak_streams", "streams", protocolStats.peakStreams.Snapshot(), 1),
+			StreamThroughput:     newReportDistribution("stream_throughput", "bit/s", protocolStats.streamThroughput.Snapshot(), 8),
+		})
+	}
+	for class, count := range snapshot.Total.FailureClasses {
+		report.Errors[class] = count
+	}
+	// The failed DoH queries add to the downloads that failed to resolve their host
+	if snapshot.DNSFailures > 0 {
+		report.Errors[FailureDNS] += snapshot.DNSFailures
+	}
+	for _, phase := range phases {
+		histogram := runStats.phaseHistograms[phase].Snapshot()
+		if histogram.Count > 0 {
+			report.Latency = append(report.Latency, newReportDistribution(phase, "ms", histogram, 1/float64(time.Millisecond)))
+		}
+	}
+	report.Throughput = newReportDistribution("throughput", "bit/s", runStats.throughputHistogram.Snapshot(), 8)
+	report.UploadThroughput = newReportDistribution("upload_throughput", "bit/s", runStats.uploadThroughputHistogram.Snapshot(), 8)
+	return report
+}
+
+// stageReports returns the counters of every stage of the load profile as of now
+func (runStats *RunStats) stageReports(now time.Time) []ReportStage {
+	reportStages := make([]ReportStage, 0, len(runStats.stages))
+	for index, stage := range runStats.stages {
+		elapsed := runStats.stageElapsed(index, now)
+		reportStage := ReportStage{
+			Name:            stage.Stage.Name,
+			Concurrency:     stage.Stage.Concurrency,
+			Ramp:            stage.Stage.Ramp,
+			StartSeconds:    runStats.loadProfile.StageStart(index).Seconds(),
+			DurationSeconds: elapsed.Seconds(),
+			Requests:        stage.requests.Load(),
+			Failures:        stage.failures.Load(),
+			Bytes:           stage.bytes.Load(),
+			UploadedBytes:   stage.uploadedBytes.Load(),
+			Latency:         newReportDistribution("total", "ms", stage.latency.Snapshot(), 1/float64(time.Millisecond)),
+		}
+		if attempts := reportStage.Requests + reportStage.Failures; attempts > 0 {
+			reportStage.ErrorRate = float64(reportStage.Failures) / float64(attempts)
+		}
+		if elapsed > 0 {
+			reportStage.AverageBitsPerSecond = float64(reportStage.Bytes) * 8 / elapsed.Seconds()
+			reportStage.AverageUploadBitsPerSecond = float64(reportStage.UploadedBytes) * 8 / elapsed.Seconds()
+		}
+		reportStages = append(reportStages, reportStage)
+	}
+	return reportStages
+}
+
+func newReportCounters(name string, shard Metrics.ShardSnapshot, elapsed time.Duration) ReportCounters {
+	reportCounters := ReportCounters{
+		Name:          name,
+		Bytes:         shard.Bytes,
+		Requests:      shard.Requests,
+		Failures:      shard.Failures,
+		Retries:       shard.Retries,
+		ReusedConns:   shard.ReusedConns,
+		UploadedBytes: shard.UploadedBytes,
+	}
+	if shard.Requests > 0 {
+		reportCounters.ConnReuseRatio = float64(shard.ReusedConns) / float64(shard.Requests)
+	}
+	if elapsed > 0 {
+		reportCounters.AverageBitsPerSecond = float64(shard.Bytes) * 8 / elapsed.Seconds()
+		reportCounters.AverageUploadBitsPerSecond = float64(shard.UploadedBytes) * 8 / elapsed.Seconds()
+	}
+	return reportCounters
+}
+
+// newReportDistribution converts a histogram snapshot to the report unit by multiplying with scale
+func newReportDistribution(name, unit string, histogram Metrics.HistogramSnapshot, scale float64) ReportDistribution {
+	return ReportDistribution{
+		Name:   name,
+		Unit:   unit,
+		Count:  histogram.Count,
+		Min:    float64(histogram.Min) * scale,
+		Mean:   histogram.Mean * scale,
+		Max:    float64(histogram.Max) * scale,
+		StdDev: histogram.StdDev * scale,
+		P50:    float64(histogram.P50) * scale,
+		P90:    float64(histogram.P90) * scale,
+		P95:    float64(histogram.P95) * scale,
+		P99:    float64(histogram.P99) * scale,
+		P999:   float64(histogram.P999) * scale,
+	}
+}
+
+func (report *Report) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// WriteCSV writes the report as section,name,field,value rows, so that every part of it fits one flat table
+func (report *Report) WriteCSV(writer io.Writer) error {
+	csvWriter := csv.NewWriter(writer)
+	row := func(section, name, field, value string) {
+		_ = csvWriter.Write([]string{section, name, field, value})
+	}
+	formatFloat := func(value float64) string {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	counters := func(section string, reportCounters ReportCounters) {
+		row(section, reportCounters.Name, "bytes", strconv.FormatInt(reportCounters.Bytes, 10))
+		row(section, reportCounters.Name, "requests", strconv.FormatInt(reportCounters.Requests, 10))
+		row(section, reportCounters.Name, "failures", strconv.FormatInt(reportCounters.Failures, 10))
+		row(section, reportCounters.Name, "retries", strconv.FormatInt(reportCounters.Retries, 10))
+		row(section, reportCounters.Name, "reused_conns", strconv.FormatInt(reportCounters.ReusedConns, 10))
+		row(section, reportCounters.Name, "conn_reuse_ratio", formatFloat(reportCounters.ConnReuseRatio))
+		row(section, reportCounters.Name, "average_bits_per_second", formatFloat(reportCounters.AverageBitsPerSecond))
+		row(section, reportCounters.Name, "uploaded_bytes", strconv.FormatInt(reportCounters.UploadedBytes, 10))
+		row(section, reportCounters.Name, "average_upload_bits_per_second", formatFloat(reportCounters.AverageUploadBitsPerSecond))
+	}
+	distribution := func(section string, reportDistribution ReportDistribution) {
+		name := reportDistribution.Name
+		row(section, name, "unit", reportDistribution.Unit)
+		row(section, name, "count", strconv.FormatInt(reportDistribution.Count, 10))
+		row(section, name, "min", formatFloat(reportDistribution.Min))
+		row(section, name, "mean", formatFloat(reportDistribution.Mean))
+		row(section, name, "max", formatFloat(reportDistribution.Max))
+		row(section, name, "stddev", formatFloat(reportDistribution.StdDev))
+		row(section, name, "p50", formatFloat(reportDistribution.P50))
+		row(section, name, "p90", formatFloat(reportDistribution.P90))
+		row(section, name, "p95", formatFloat(reportDistribution.P95))
+		row(section, name, "p99", formatFloat(reportDistribution.P99))
+		row(section, name, "p99.9", formatFloat(reportDistribution.P999))
+	}
+
+	row("section", "name", "field", "value")
+	row("config", "", "url", report.Config.URL)
+	row("config", "", "ip_family", report.Config.IPFamily)
+	row("config", "", "local_ip", report.Config.LocalIP)
+	row("config", "", "local_ips", strings.Join(report.Config.LocalIPs, " "))
+	row("config", "", "http_method", report.Config.HTTPMethod)
+	row("config", "", "upload", strconv.FormatBool(report.Config.Upload))
+	row("config", "", "chunked", strconv.FormatBool(report.Config.Chunked))
+	row("config", "", "http_version", report.Config.HTTPVersion)
+	row("config", "", "streams", strconv.Itoa(report.Config.Streams))
+	row("config", "", "conn_mode", report.Config.ConnMode)
+	row("config", "", "max_attempts", strconv.Itoa(report.Config.MaxAttempts))
+	row("config", "", "rate", formatFloat(report.Config.Rate))
+	row("config", "", "parallel", strconv.Itoa(report.Config.Parallel))
+	row("config", "", "single_ip_download_times", strconv.Itoa(report.Config.SingleIpDownloadTimes))
+	row("config", "", "crawler_mode", strconv.FormatBool(report.Config.CrawlerMode))
+	row("config", "", "duration", report.Config.Duration)
+	row("config", "", "max_bytes", strconv.FormatInt(report.Config.MaxBytes, 10))
+	row("config", "", "max_requests", strconv.FormatInt(report.Config.MaxRequests, 10))
+	row("run", "", "start_time", report.StartTime.Format(time.RFC3339Nano))
+	row("run", "", "end_time", report.EndTime.Format(time.RFC3339Nano))
+	row("run", "", "elapsed_seconds", formatFloat(report.ElapsedSeconds))
+	row("run", "", "stop_reason", report.StopReason)
+	row("run", "", "dns_queries", strconv.FormatInt(report.DNSQueries, 10))
+	if report.OpenLoop != nil {
+		row("open_loop", "", "target_rate", formatFloat(report.OpenLoop.TargetRate))
+		row("open_loop", "", "achieved_rate", formatFloat(report.OpenLoop.AchievedRate))
+		row("open_loop", "", "scheduled", strconv.FormatInt(report.OpenLoop.Scheduled, 10))
+		row("open_loop", "", "backlog", strconv.FormatInt(report.OpenLoop.Backlog, 10))
+		row("open_loop", "", "max_backlog", strconv.FormatInt(report.OpenLoop.MaxBacklog, 10))
+	}
+	if report.Bandwidth != nil {
+		row("bandwidth", "", "limit", formatFloat(report.Bandwidth.Limit))
+		row("bandwidth", "", "per_local_ip", formatFloat(report.Bandwidth.PerLocalIP))
+		row("bandwidth", "", "throttled_reads", strconv.FormatInt(report.Bandwidth.ThrottledReads, 10))
+		row("bandwidth", "", "throttled_seconds", formatFloat(report.Bandwidth.ThrottledSeconds))
+	}
+	for _, stage := range report.Stages {
+		row("stage", stage.Name, "concurrency", strconv.Itoa(stage.Concurrency))
+		row("stage", stage.Name, "ramp", strconv.FormatBool(stage.Ramp))
+		row("stage", stage.Name, "start_seconds", formatFloat(stage.StartSeconds))
+		row("stage", stage.Name, "duration_seconds", formatFloat(stage.DurationSeconds))
+		row("stage", stage.Name, "requests", strconv.FormatInt(stage.Requests, 10))
+		row("stage", stage.Name, "failures", strconv.FormatInt(stage.Failures, 10))
+		row("stage", stage.Name, "error_rate", formatFloat(stage.ErrorRate))
+		row("stage", stage.Name, "bytes", strconv.FormatInt(stage.Bytes, 10))
+		row("stage", stage.Name, "uploaded_bytes", strconv.FormatInt(stage.UploadedBytes, 10))
+		row("stage", stage.Name, "average_bits_per_second", formatFloat(stage.AverageBitsPerSecond))
+		row("stage", stage.Name, "average_upload_bits_per_second", formatFloat(stage.AverageUploadBitsPerSecond))
+		row("stage", stage.Name, "latency_p50_ms", formatFloat(stage.Latency.P50))
+		row("stage", stage.Name, "latency_p99_ms", formatFloat(stage.Latency.P99))
+	}
+	if report.HappyEyeballs != nil {
+		row("happy_eyeballs", "", "delay_ms", formatFloat(report.HappyEyeballs.DelayMs))
+		row("happy_eyeballs", "", "measure_both", strconv.FormatBool(report.HappyEyeballs.MeasureBoth))
+		row("happy_eyeballs", "", "races", strconv.FormatInt(report.HappyEyeballs.Races, 10))
+		row("happy_eyeballs", "", "failed_races", strconv.FormatInt(report.HappyEyeballs.FailedRaces, 10))
+		row("happy_eyeballs", "", "margin_p50_ms", formatFloat(report.HappyEyeballs.Margin.P50))
+		row("happy_eyeballs", "", "margin_p99_ms", formatFloat(report.HappyEyeballs.Margin.P99))
+		for _, family := range report.HappyEyeballs.Families {
+			row("happy_eyeballs", family.Name, "attempts", strconv.FormatInt(family.Attempts, 10))
+			row("happy_eyeballs", family.Name, "successes", strconv.FormatInt(family.Successes, 10))
+			row("happy_eyeballs", family.Name, "success_rate", formatFloat(family.SuccessRate))
+			row("happy_eyeballs", family.Name, "wins", strconv.FormatInt(family.Wins, 10))
+			row("happy_eyeballs", family.Name, "connect_p50_ms", formatFloat(family.Connect.P50))
+		}
+	}
+	if report.QUIC != nil {
+		row("quic", "", "handshakes", strconv.FormatInt(report.QUIC.Handshakes, 10))
+		row("quic", "", "zero_rtt", strconv.FormatInt(report.QUIC.ZeroRTT, 10))
+		row("quic", "", "handshake_p50_ms", formatFloat(report.QUIC.Handshake.P50))
+		row("quic", "", "handshake_p99_ms", formatFloat(report.QUIC.Handshake.P99))
+		row("quic", "", "packets_sent", strconv.FormatInt(report.QUIC.PacketsSent, 10))
+		row("quic", "", "packets_lost", strconv.FormatInt(report.QUIC.PacketsLost, 10))
+		row("quic", "", "loss_rate", formatFloat(report.QUIC.LossRate))
+		row("quic", "", "probe_timeouts", strconv.FormatInt(report.QUIC.ProbeTimeouts, 10))
+	}
+	row("workers", "", "panics", strconv.FormatInt(report.Workers.Panics, 10))
+	row("workers", "", "errors", strconv.FormatInt(report.Workers.Errors, 10))
+	row("workers", "", "restarts", strconv.FormatInt(report.Workers.Restarts, 10))
+	row("workers", "", "retired", strconv.FormatInt(report.Workers.Retired, 10))
+	counters("totals", report.Totals)
+	for _, reportCounters := range report.LocalIPs {
+		counters("local_ip", reportCounters)
+	}
+	for _, reportCounters := range report.RemoteIPs {
+		counters("remote_ip", reportCounters)
+	}
+	for _, reportCounters := range report.URLs {
+		counters("url", reportCounters)
+	}
+	for _, reportFamily := range report.IPFamilies {
+		reportCounters := reportFamily.ReportCounters
+		reportCounters.Name = reportFamily.URL + " " + reportFamily.Name
+		counters("ip_family", reportCounters)
+	}
+	for _, protocol := range report.Protocols {
+		row("protocol", protocol.Name, "requests", strconv.FormatInt(protocol.Requests, 10))
+		row("protocol", protocol.Name, "bytes", strconv.FormatInt(protocol.Bytes, 10))
+		row("protocol", protocol.Name, "connections", strconv.FormatInt(protocol.Connections, 10))
+		row("protocol", protocol.Name, "streams_per_connection", formatFloat(protocol.StreamsPerConnection))
+		row("protocol", protocol.Name, "peak_streams_max", formatFloat(protocol.PeakStreams.Max))
+		row("protocol", protocol.Name, "stream_throughput_p50", formatFloat(protocol.StreamThroughput.P50))
+		row("protocol", protocol.Name, "stream_throughput_mean", formatFloat(protocol.StreamThroughput.Mean))
+	}
+	classes := make([]string, 0, len(report.Errors))
+	for class := range report.Errors {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		row("error", class, "count", strconv.FormatInt(report.Errors[class], 10))
+	}
+	for _, reportDistribution := range report.Latency {
+		distribution("latency", reportDistribution)
+	}
+	distribution("throughput", report.Throughput)
+	distribution("throughput", report.UploadThroughput)
+	for _, dnsAnswer := range report.DNSAnswers {
+		name := fmt.Sprintf("%s %s %s", dnsAnswer.Host, dnsAnswer.Server, dnsAnswer.ClientSubnet)
+		for _, answer := range dnsAnswer.Answers {
+			row("dns_answer", name, "answer", answer)
+		}
+		row("dns_answer", name, "count", strconv.FormatInt(dnsAnswer.Count, 10))
+	}
+	for _, tlsSession := range report.TLSSessions {
+		name := tlsSession.RemoteIP + " " + tlsSession.setup()
+		row("tls_session", name, "remote_ip", tlsSession.RemoteIP)
+		row("tls_session", name, "version", tlsSession.Version)
+		row("tls_session", name, "cipher_suite", tlsSession.CipherSuite)
+		row("tls_session", name, "alpn", tlsSession.ALPN)
+		row("tls_session", name, "curve", tlsSession.Curve)
+		row("tls_session", name, "subject", tlsSession.Subject)
+		for _, san := range tlsSession.SANs {
+			row("tls_session", name, "san", san)
+		}
+		row("tls_session", name, "not_after", tlsSession.NotAfter.Format(time.RFC3339))
+		row("tls_session", name, "connections", strconv.FormatInt(tlsSession.Connections, 10))
+		row("tls_session", name, "resumed", strconv.FormatInt(tlsSession.Resumed, 10))
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// writeReports prints the text summary and writes the report in the chosen format, to -report-file when set.
+// Without a report file a json or csv report replaces the text summary on stdout.
+func writeReports(runOptions *RunOptions, runStats *RunStats, report *Report) error {
+	if runOptions.ReportFile == "" {
+		if runOptions.OutputFormat == OutputText {
+			fmt.Printf("\n%s\n", runStats.Summary())
+			return nil
+		}
+		return writeReport(os.Stdout, runOptions.OutputFormat, runStats, report)
+	}
+	fmt.Printf("\n%s\n", runStats.Summary())
+	file, err := os.Create(runOptions.ReportFile)
+	if err != nil {
+		return err
+	}
+	err = writeReport(file, runOptions.OutputFormat, runStats, report)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func writeReport(writer io.Writer, outputFormat string, runStats *RunStats, report *Report) error {
+	switch outputFormat {
+	case OutputJSON:
+		return report.WriteJSON(writer)
+	case OutputCSV:
+		return report.WriteCSV(writer)
+	default:
+		_, err := fmt.Fprintln(writer, runStats.Summary())
+		return err
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"HttpBenchmark/Metrics"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestReport() *Report {
+	runStats := NewRunStats()
+	shard := runStats.Shard(Metrics.Labels{RemoteIP: "10.0.0.1", URL: "https://example.com/a"})
+	shard.AddBytes(1000)
+	runStats.AddRequest(shard, 1000, 0, &RequestTiming{Connect: time.Millisecond, TTFB: 2 * time.Millisecond, Total: 4 * time.Millisecond})
+	runStats.Shard(Metrics.Labels{RemoteIP: "10.0.0.2", URL: "https://example.com/a"}).AddFailure(FailureBody)
+	ip := net.ParseIP("10.0.0.1")
+	runStats.AddDNSAnswer("example.com", "https://223.5.5.5/dns-query", "1.2.3.0/24", []*net.IP{&ip})
+	runStats.AddDNSAnswer("example.com", "https://223.5.5.5/dns-query", "1.2.3.0/24", []*net.IP{&ip})
+	return runStats.Report(ReportConfig{URL: "https://example.com/a", Parallel: 2}, StopReasonLimit)
+}
+
+func TestReportJSON(t *testing.T) {
+	var buffer bytes.Buffer
+	assert.Nil(t, newTestReport().WriteJSON(&buffer))
+	var report Report
+	assert.Nil(t, json.Unmarshal(buffer.Bytes(), &report))
+	assert.Equal(t, "https://example.com/a", report.Config.URL)
+	assert.Equal(t, int64(1000), report.Totals.Bytes)
+	assert.Len(t, report.RemoteIPs, 2)
+	assert.Equal(t, []ReportCounters{{Name: "https://example.com/a", Bytes: 1000, Requests: 1, Failures: 1,
+		AverageBitsPerSecond: report.URLs[0].AverageBitsPerSecond}}, report.URLs)
+	assert.Equal(t, map[string]int64{FailureBody: 1}, report.Errors)
+	assert.Equal(t, 0.0, report.Totals.ConnReuseRatio)
+	assert.Equal(t, PhaseConnect, report.Latency[0].Name)
+	assert.InDelta(t, 1, report.Latency[0].P50, 0.02)
+	assert.Equal(t, []DNSAnswer{{Host: "example.com", Server: "https://223.5.5.5/dns-query", ClientSubnet: "1.2.3.0/24",
+		Answers: []string{"10.0.0.1"}, Count: 2}}, report.DNSAnswers)
+}
+
+func TestReportCSV(t *testing.T) {
+	var buffer bytes.Buffer
+	assert.Nil(t, newTestReport().WriteCSV(&buffer))
+	records, err := csv.NewReader(&buffer).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"section", "name", "field", "value"}, records[0])
+	assert.Contains(t, records, []string{"remote_ip", "10.0.0.2", "failures", "1"})
+	assert.Contains(t, records, []string{"error", FailureBody, "count", "1"})
+	assert.Contains(t, records, []string{"dns_answer", "example.com https://223.5.5.5/dns-query 1.2.3.0/24", "answer", "10.0.0.1"})
+	assert.Contains(t, records, []string{"run", "", "stop_reason", StopReasonLimit})
+}
+
+func TestReportErrorsAddDNSFailures(t *testing.T) {
+	runStats := NewRunStats()
+	runStats.Shard(Metrics.Labels{RemoteIP: "10.0.0.1"}).AddFailure(FailureDNS)
+	runStats.Collector().AddDNSQuery("https://223.5.5.5/dns-query", time.Millisecond, true)
+	report := runStats.Report(ReportConfig{}, StopReasonLimit)
+	assert.Equal(t, map[string]int64{FailureDNS: 2}, report.Errors)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// RequestTiming records the phases of a single download request through httptrace hooks.
+// DNS stays zero when the remote IP is pinned, Connect and TLSHandshake stay zero on a reused connection.
+type RequestTiming struct {
+	DNS          time.Duration
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	// Upload is the time from getting the connection to the request, body included, being written
+	Upload time.Duration
+	// Queue is the time an open-loop request waited past the time it was due, Total includes it
+	Queue time.Duration
+	// TTFB is the time from the request being written to the first response byte
+	TTFB       time.Duration
+	Transfer   time.Duration
+	Total      time.Duration
+	ConnReused bool
+	// Protocol is the protocol of the response like HTTP/1.1 or HTTP/2.0
+	Protocol string
+
+	mutex        sync.Mutex
+	startTime    time.Time
+	scheduledAt  time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	gotConn      time.Time
+	// conn is the connection the request went over, an HTTP/2 connection carries several requests at once
+	conn         net.Conn
+	wroteRequest time.Time
+	firstByte    time.Time
+}
+
+func NewRequestTiming() *RequestTiming {
+	return &RequestTiming{
+		startTime: time.Now(),
+	}
+}
+
+// ScheduledAt times the request from the time it was due rather than from now, so that the latency
+// of an open-loop run includes the time the request waited for a free worker
+func (requestTiming *RequestTiming) ScheduledAt(scheduledAt time.Time) {
+	requestTiming.mutex.Lock()
+	defer requestTiming.mutex.Unlock()
+	requestTiming.scheduledAt = scheduledAt
+	requestTiming.Queue = max(requestTiming.startTime.Sub(scheduledAt), 0)
+	requestTiming.startTime = scheduledAt
+}
+
+// ClientTrace returns the httptrace hooks that fill this RequestTiming
+func (requestTiming *RequestTiming) ClientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			requestTiming.mutex.Lock()
+			defer requestTiming.mutex.Unlock()
+			requestTiming.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			requestTiming.mutex.Lock()
+			defer requestTiming.mutex.Unlock()
+			requestTiming.DNS = time.Since(requestTiming.dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			requestTiming.mutex.Lock()
+			defer requestTiming.mutex.Unlock()
+			requestTiming.connectStart = time.Now()
+		},
+		ConnectDone: func(string, string, error) {
+			requestTiming.mutex.Lock()
+			defer requestTiming.mutex.Unlock()
+			requestTiming.Connect = time.Since(requestTiming.connectStart)
+		},
+		TLSHandshakeStart: func() {
+			requestTiming.mutex.Lock()
+			defer requestTiming.mutex.Unlock()
+			requestTiming.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			requestTiming.mutex.Lock()
+			defer requestTiming.mutex.Unlock()
+			requestTiming.TLSHandshake = time.Since(requestTiming.tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			requestTiming.mutex.Lock()
+			defer requestTiming.mutex.Unlock()
+			requestTiming.ConnReused = info.Reused
+			requestTiming.conn = info.Conn
+			requestTiming.gotConn = time.Now()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			requestTiming.mutex.Lock()
+			defer requestTiming.mutex.Unlock()
+			requestTiming.wroteRequest = time.Now()
+			if !requestTiming.gotConn.IsZero() {
+				requestTiming.Upload = requestTiming.wroteRequest.Sub(requestTiming.gotConn)
+			}
+		},
+		GotFirstResponseByte: func() {
+			requestTiming.mutex.Lock()
+			defer requestTiming.mutex.Unlock()
+			requestTiming.firstByte = time.Now()
+			requestTiming.TTFB = requestTiming.firstByte.Sub(requestTiming.wroteRequest)
+		},
+	}
+}
+
+// Conn returns the connection the request went over, nil before it got one
+func (requestTiming *RequestTiming) Conn() net.Conn {
+	requestTiming.mutex.Lock()
+	defer requestTiming.mutex.Unlock()
+	return requestTiming.conn
+}
+
+// GotResponse stands in for GotFirstResponseByte with transports that do not call it like http3, TTFB then runs
+// from getting the connection to the response headers
+func (requestTiming *RequestTiming) GotResponse() {
+	requestTiming.mutex.Lock()
+	defer requestTiming.mutex.Unlock()
+	if !requestTiming.firstByte.IsZero() {
+		return
+	}
+	requestTiming.firstByte = time.Now()
+	switch {
+	case !requestTiming.wroteRequest.IsZero():
+		requestTiming.TTFB = requestTiming.firstByte.Sub(requestTiming.wroteRequest)
+	case !requestTiming.gotConn.IsZero():
+		requestTiming.TTFB = requestTiming.firstByte.Sub(requestTiming.gotConn)
+	}
+}
+
+// Done marks the end of the body transfer and fills Transfer and Total
+func (requestTiming *RequestTiming) Done() {
+	requestTiming.mutex.Lock()
+	defer requestTiming.mutex.Unlock()
+	now := time.Now()
+	if !requestTiming.firstByte.IsZero() {
+		requestTiming.Transfer = now.Sub(requestTiming.firstByte)
+	}
+	requestTiming.Total = now.Sub(requestTiming.startTime)
+}
+
+// tlsHandshakeWithTrace runs the handshake of a custom DialTLSContext and reports it to the httptrace hooks,
+// which net/http only calls for the TLS handshakes it does itself
+func tlsHandshakeWithTrace(trace *httptrace.ClientTrace, handshake func() error, state func() tls.ConnectionState) error {
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	err := handshake()
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(state(), err)
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestDownloadHttpConfig(t *testing.T, server *httptest.Server) *DownloadHttpConfig {
+	serverURL, err := url.Parse(server.URL)
+	assert.Nil(t, err)
+	host, port, err := net.SplitHostPort(serverURL.Host)
+	assert.Nil(t, err)
+	remoteIP := net.ParseIP(host)
+	remotePort, err := strconv.Atoi(port)
+	assert.Nil(t, err)
+	// Point the URL at a host name that does not resolve, so that only the pinned remote IP can be dialed
+	serverURL.Host = net.JoinHostPort("download.invalid", port)
+	return NewDownloadHttpConfig(WithUrl(serverURL), WithRemoteIP(&remoteIP), WithRemotePort(remotePort))
+}
+
+func TestRequestTimingTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 64*1024))
+	}))
+	defer server.Close()
+
+	downloadHttpConfig := newTestDownloadHttpConfig(t, server)
+	requestTiming := NewRequestTiming()
+	ctx := httptrace.WithClientTrace(context.Background(), requestTiming.ClientTrace())
+	client := downloadHttpConfig.createHttpClient(downloadHttpConfig.createTransport())
+	request, err := downloadHttpConfig.createHttpRequest(ctx, nil, 0)
+	assert.Nil(t, err)
+	response, err := client.Do(request)
+	assert.Nil(t, err)
+	written, err := io.Copy(io.Discard, response.Body)
+	assert.Nil(t, err)
+	assert.Nil(t, response.Body.Close())
+	requestTiming.Done()
+
+	assert.Equal(t, int64(64*1024), written)
+	assert.Greater(t, requestTiming.Connect, time.Duration(0))
+	assert.Greater(t, requestTiming.TLSHandshake, time.Duration(0))
+	assert.Greater(t, requestTiming.TTFB, time.Duration(0))
+	assert.GreaterOrEqual(t, requestTiming.Total, requestTiming.TTFB+requestTiming.Transfer)
+	assert.False(t, requestTiming.ConnReused)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy decides how often and after how long a failed download request is retried
+type RetryPolicy struct {
+	// MaxAttempts counts the first attempt too, 1 disables retries
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// RetryOnStatus lists the HTTP status codes that are retried, other error statuses only count as failures
+	RetryOnStatus []int
+}
+
+type RetryPolicyOption func(*RetryPolicy)
+
+func WithMaxAttempts(maxAttempts int) RetryPolicyOption {
+	return func(retryPolicy *RetryPolicy) {
+		retryPolicy.MaxAttempts = maxAttempts
+	}
+}
+
+func WithBackoff(baseBackoff, maxBackoff time.Duration) RetryPolicyOption {
+	return func(retryPolicy *RetryPolicy) {
+		retryPolicy.BaseBackoff = baseBackoff
+		retryPolicy.MaxBackoff = maxBackoff
+	}
+}
+
+func WithRetryOnStatus(statusCodes []int) RetryPolicyOption {
+	return func(retryPolicy *RetryPolicy) {
+		retryPolicy.RetryOnStatus = statusCodes
+	}
+}
+
+func NewRetryPolicy(opts ...RetryPolicyOption) *RetryPolicy {
+	retryPolicy := &RetryPolicy{
+		MaxAttempts:   3,
+		BaseBackoff:   200 * time.Millisecond,
+		MaxBackoff:    5 * time.Second,
+		RetryOnStatus: []int{429, 502, 503, 504},
+	}
+	for _, opt := range opts {
+		opt(retryPolicy)
+	}
+	return retryPolicy
+}
+
+// ParseStatusCodes parses a comma separated list of HTTP status codes
+func ParseStatusCodes(list string) ([]int, error) {
+	var statusCodes []int
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		statusCode, err := strconv.Atoi(field)
+		if err != nil || statusCode < 100 || statusCode > 599 {
+			return nil, fmt.Errorf("invalid HTTP status code %q", field)
+		}
+		statusCodes = append(statusCodes, statusCode)
+	}
+	return statusCodes, nil
+}
+
+// Backoff returns the delay before the given retry, counted from 1. The delay doubles with every retry up to MaxBackoff
+// and a random jitter of up to half of it keeps the workers from retrying in lockstep.
+func (retryPolicy *RetryPolicy) Backoff(retry int) time.Duration {
+	backoff := retryPolicy.BaseBackoff
+	for i := 1; i < retry && backoff < retryPolicy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, retryPolicy.MaxBackoff)
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// Retryable tells whether a request that failed with err may be retried
+func (retryPolicy *RetryPolicy) Retryable(err error) bool {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		for _, statusCode := range retryPolicy.RetryOnStatus {
+			if statusErr.statusCode == statusCode {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// statusError is the failure of a request answered with an HTTP error status
+type statusError struct {
+	statusCode int
+}
+
+func (statusErr *statusError) Error() string {
+	return "HTTP status " + strconv.Itoa(statusErr.statusCode)
+}
+
+// downloadFailure is a failed download request with its failure class
+type downloadFailure struct {
+	class string
+	err   error
+	// conn is the connection the request went over, nil when it did not get one
+	conn net.Conn
+}
+
+func (failure *downloadFailure) Error() string {
+	return failure.class + ": " + failure.err.Error()
+}
+
+func (failure *downloadFailure) Unwrap() error {
+	return failure.err
+}
+
+// errIdleReadTimeout and errRequestTimeout are the causes of the request contexts cancelled by the download timeouts
+var (
+	errIdleReadTimeout = errors.New("idle read timeout")
+	errRequestTimeout  = errors.New("request timeout")
+)
+
+// classifyFailure maps the error of a failed request to its failure class, bodyRead tells whether it failed while
+// reading the response body. Timeouts take precedence over the phase they happened in.
+func classifyFailure(ctx context.Context, err error, bodyRead bool) string {
+	var statusErr *statusError
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var netErr net.Error
+	cause := context.Cause(ctx)
+	switch {
+	case errors.As(err, &statusErr):
+		return FailureStatus
+	case errors.As(err, &dnsErr):
+		return FailureDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(cause, errIdleReadTimeout), errors.Is(cause, errRequestTimeout),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return FailureTimeout
+	case isTLSError(err):
+		return FailureTLS
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return FailureConnect
+	case bodyRead:
+		return FailureBody
+	default:
+		return FailureRequest
+	}
+}
+
+func isTLSError(err error) bool {
+	var recordHeaderErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var certificateErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certificateInvalidErr x509.CertificateInvalidError
+	if errors.As(err, &recordHeaderErr) || errors.As(err, &alertErr) || errors.As(err, &certificateErr) ||
+		errors.As(err, &unknownAuthorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &certificateInvalidErr) {
+		return true
+	}
+	// Most handshake errors of crypto/tls and utls are plain errors with a "tls: " prefix
+	return strings.Contains(err.Error(), "tls: ")
+}
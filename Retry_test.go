@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	retryPolicy := NewRetryPolicy(WithBackoff(100*time.Millisecond, time.Second))
+	for retry, wantMax := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 10: time.Second} {
+		for i := 0; i < 100; i++ {
+			backoff := retryPolicy.Backoff(retry)
+			assert.GreaterOrEqual(t, backoff, wantMax/2)
+			assert.LessOrEqual(t, backoff, wantMax)
+		}
+	}
+	assert.Equal(t, time.Duration(0), NewRetryPolicy(WithBackoff(0, 0)).Backoff(3))
+}
+
+func TestParseStatusCodes(t *testing.T) {
+	statusCodes, err := ParseStatusCodes("429, 503,")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{429, 503}, statusCodes)
+	_, err = ParseStatusCodes("50x")
+	assert.NotNil(t, err)
+	_, err = ParseStatusCodes("1000")
+	assert.NotNil(t, err)
+}
+
+func TestClassifyFailure(t *testing.T) {
+	idleCtx, cancel := context.WithCancelCause(context.Background())
+	cancel(errIdleReadTimeout)
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		err      error
+		bodyRead bool
+		want     string
+	}{
+		{"status", context.Background(), &statusError{statusCode: 503}, false, FailureStatus},
+		{"dns", context.Background(), &net.DNSError{Err: "no such host", Name: "download.invalid"}, false, FailureDNS},
+		{"deadline", context.Background(), context.DeadlineExceeded, false, FailureTimeout},
+		{"idle read", idleCtx, context.Canceled, true, FailureTimeout},
+		{"tls", context.Background(), tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, false, FailureTLS},
+		{"connect", context.Background(), &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, false, FailureConnect},
+		{"body", context.Background(), errors.New("unexpected EOF"), true, FailureBody},
+		{"other", context.Background(), errors.New("unsupported protocol scheme"), false, FailureRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, classifyFailure(test.ctx, test.err, test.bodyRead))
+		})
+	}
+}
+
+func TestDownloadRetries(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case requests.Add(1)%3 != 0:
+			// Every third request succeeds
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			_, _ = w.Write(make([]byte, 1024))
+		}
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		name        string
+		path        string
+		maxAttempts int
+		want        map[string]int64
+		wantRetries int64
+		wantOK      int64
+	}{
+		{name: "retried", path: "/", maxAttempts: 3, want: map[string]int64{FailureStatus: 4}, wantRetries: 4, wantOK: 2},
+		{name: "retries exhausted", path: "/", maxAttempts: 2, want: map[string]int64{FailureStatus: 2}, wantRetries: 1, wantOK: 1},
+		{name: "status not retried", path: "/missing", maxAttempts: 3, want: map[string]int64{FailureStatus: 2}, wantOK: 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			requests.Store(0)
+			runStats := NewRunStats()
+			downloadHttpConfig := newTestDownloadHttpConfig(t, server)
+			downloadHttpConfig.url.Path = test.path
+			for _, opt := range []DownloadHttpConfigOption{WithRunStats(runStats), WithSingleIpDownloadTimes(2),
+				WithRetryPolicy(NewRetryPolicy(WithMaxAttempts(test.maxAttempts), WithBackoff(time.Millisecond, 2*time.Millisecond)))} {
+				opt(downloadHttpConfig)
+			}
+			assert.Nil(t, downloadHttpConfig.DoHttpDownload(context.Background()))
+
+			total := runStats.Collector().Snapshot().Total
+			assert.Equal(t, test.want, total.FailureClasses)
+			assert.Equal(t, test.wantRetries, total.Retries)
+			assert.Equal(t, test.wantOK, total.Requests)
+			assert.Contains(t, runStats.Summary(), "Failures: http_status")
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// RunLimit holds the stop conditions shared by all download workers, a zero value means unlimited
+type RunLimit struct {
+	Duration    time.Duration
+	MaxBytes    int64
+	MaxRequests int64
+	runStats    *RunStats
+	// loadProfile ends the run after its last stage, nil without one
+	loadProfile     *LoadProfile
+	startedRequests atomic.Int64
+	stopped         atomic.Bool
+}
+
+type RunLimitOption func(*RunLimit)
+
+func WithDuration(duration time.Duration) RunLimitOption {
+	return func(runLimit *RunLimit) {
+		runLimit.Duration = duration
+	}
+}
+
+func WithMaxBytes(maxBytes int64) RunLimitOption {
+	return func(runLimit *RunLimit) {
+		runLimit.MaxBytes = maxBytes
+	}
+}
+
+func WithMaxRequests(maxRequests int64) RunLimitOption {
+	return func(runLimit *RunLimit) {
+		runLimit.MaxRequests = maxRequests
+	}
+}
+
+func WithLoadProfileEnd(loadProfile *LoadProfile) RunLimitOption {
+	return func(runLimit *RunLimit) {
+		runLimit.loadProfile = loadProfile
+	}
+}
+
+func NewRunLimit(runStats *RunStats, opts ...RunLimitOption) *RunLimit {
+	runLimit := &RunLimit{
+		runStats: runStats,
+	}
+	for _, opt := range opts {
+		opt(runLimit)
+	}
+	return runLimit
+}
+
+// Stop makes Reached report true, so that no new requests are scheduled
+func (runLimit *RunLimit) Stop() {
+	runLimit.stopped.Store(true)
+}
+
+// Stopped reports whether the run was stopped by Stop rather than by a limit
+func (runLimit *RunLimit) Stopped() bool {
+	return runLimit.stopped.Load()
+}
+
+// Reached reports whether the run should stop scheduling new requests
+func (runLimit *RunLimit) Reached() bool {
+	if runLimit.Stopped() {
+		return true
+	}
+	if runLimit.Duration > 0 && runLimit.runStats.Elapsed() >= runLimit.Duration {
+		return true
+	}
+	if runLimit.MaxBytes > 0 && runLimit.runStats.TransferredBytes() >= runLimit.MaxBytes {
+		return true
+	}
+	if runLimit.loadProfile != nil && runLimit.loadProfile.Over() {
+		return true
+	}
+	if runLimit.MaxRequests > 0 && runLimit.startedRequests.Load() >= runLimit.MaxRequests {
+		return true
+	}
+	return false
+}
+
+// Acquire reserves a slot for one more request, it returns false once any limit is reached
+func (runLimit *RunLimit) Acquire() bool {
+	if runLimit.Reached() {
+		return false
+	}
+	if runLimit.MaxRequests <= 0 {
+		runLimit.startedRequests.Add(1)
+		return true
+	}
+	for {
+		started := runLimit.startedRequests.Load()
+		if started >= runLimit.MaxRequests {
+			return false
+		}
+		if runLimit.startedRequests.CompareAndSwap(started, started+1) {
+			return true
+		}
+	}
+}
+
+// Progress returns how far the run is toward its nearest limit as a fraction between 0 and 1,
+// ok is false when no limit is set
+func (runLimit *RunLimit) Progress() (progress float64, ok bool) {
+	if runLimit.Duration > 0 {
+		progress = max(progress, float64(runLimit.runStats.Elapsed())/float64(runLimit.Duration))
+		ok = true
+	}
+	if runLimit.MaxBytes > 0 {
+		progress = max(progress, float64(runLimit.runStats.TransferredBytes())/float64(runLimit.MaxBytes))
+		ok = true
+	}
+	if runLimit.loadProfile != nil && runLimit.loadProfile.Duration() > 0 {
+		if started := runLimit.loadProfile.Started(); !started.IsZero() {
+			progress = max(progress, float64(time.Since(started))/float64(runLimit.loadProfile.Duration()))
+		}
+		ok = true
+	}
+	if runLimit.MaxRequests > 0 {
+		progress = max(progress, float64(runLimit.startedRequests.Load())/float64(runLimit.MaxRequests))
+		ok = true
+	}
+	return min(progress, 1), ok
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"HttpBenchmark/Metrics"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRunLimitMaxRequests(t *testing.T) {
+	runLimit := NewRunLimit(NewRunStats(), WithMaxRequests(3))
+	acquired := 0
+	for i := 0; i < 10; i++ {
+		if runLimit.Acquire() {
+			acquired++
+		}
+	}
+	assert.Equal(t, 3, acquired)
+	assert.True(t, runLimit.Reached())
+}
+
+func TestRunLimitMaxBytes(t *testing.T) {
+	runStats := NewRunStats()
+	runLimit := NewRunLimit(runStats, WithMaxBytes(1024))
+	assert.True(t, runLimit.Acquire())
+	runStats.Shard(Metrics.Labels{}).AddBytes(1024)
+	assert.True(t, runLimit.Reached())
+	assert.False(t, runLimit.Acquire())
+}
+
+func TestRunLimitDuration(t *testing.T) {
+	runLimit := NewRunLimit(NewRunStats(), WithDuration(10*time.Millisecond))
+	assert.False(t, runLimit.Reached())
+	time.Sleep(20 * time.Millisecond)
+	assert.True(t, runLimit.Reached())
+}
+
+func TestRunLimitUnlimited(t *testing.T) {
+	runLimit := NewRunLimit(NewRunStats())
+	for i := 0; i < 100; i++ {
+		assert.True(t, runLimit.Acquire())
+	}
+	assert.False(t, runLimit.Reached())
+}
+
+func TestRunLimitStop(t *testing.T) {
+	runLimit := NewRunLimit(NewRunStats())
+	assert.True(t, runLimit.Acquire())
+	runLimit.Stop()
+	assert.True(t, runLimit.Stopped())
+	assert.False(t, runLimit.Acquire())
+}
+
+func TestRunLimitProgress(t *testing.T) {
+	_, ok := NewRunLimit(NewRunStats()).Progress()
+	assert.False(t, ok)
+
+	runStats := NewRunStats()
+	runLimit := NewRunLimit(runStats, WithMaxBytes(1000), WithMaxRequests(10))
+	runStats.Shard(Metrics.Labels{}).AddBytes(250)
+	for i := 0; i < 5; i++ {
+		runLimit.Acquire()
+	}
+	progress, ok := runLimit.Progress()
+	assert.True(t, ok)
+	assert.Equal(t, 0.5, progress)
+}
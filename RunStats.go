@@ -0,0 +1,494 @@
+package main
+
+import (
+	"HttpBenchmark/Metrics"
+	"HttpBenchmark/Utils"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// Request phases with their own latency histogram, in report order
+const (
+	PhaseQueue        = "queue"
+	PhaseDNS          = "dns"
+	PhaseConnect      = "connect"
+	PhaseTLSHandshake = "tls"
+	PhaseUpload       = "upload"
+	PhaseTTFB         = "ttfb"
+	PhaseTransfer     = "transfer"
+	PhaseTotal        = "total"
+)
+
+var phases = []string{PhaseQueue, PhaseDNS, PhaseConnect, PhaseTLSHandshake, PhaseUpload, PhaseTTFB, PhaseTransfer, PhaseTotal}
+
+// RunStats accumulates the totals of a whole benchmark run across all download workers
+type RunStats struct {
+	startTime time.Time
+	collector *Metrics.Collector
+	// phaseHistograms holds request phase durations in nanoseconds
+	phaseHistograms map[string]*Metrics.Histogram
+	// throughputHistogram holds the per-request throughput in bytes per second
+	throughputHistogram *Metrics.Histogram
+	// uploadThroughputHistogram holds the per-request upload throughput in bytes per second
+	uploadThroughputHistogram *Metrics.Histogram
+
+	// schedule is the request timeline of an open-loop run, nil in closed-loop runs
+	schedule *RequestSchedule
+	// bandwidthLimiter caps the transfers of a run with -max-bandwidth, nil when they are uncapped
+	bandwidthLimiter *BandwidthLimiter
+	// loadProfile is the staged load of the run and stages holds the counters of each of its stages, both nil without one
+	loadProfile *LoadProfile
+	stages      []*StageStats
+	// happyEyeballs counts the IPv6 and IPv4 connect races of -happy-eyeballs, nil without them
+	happyEyeballs *HappyEyeballs
+	// quicStats counts the QUIC handshakes and packets of -http-version 3, nil over TCP
+	quicStats *QUICStats
+
+	mutex        sync.Mutex
+	dnsServer    string
+	clientSubnet string
+	// dnsAnswers holds the distinct DNS answers in the order they were first seen
+	dnsAnswers     []*DNSAnswer
+	dnsAnswerIndex map[string]*DNSAnswer
+	// tlsSessions holds the distinct TLS setups of every remote IP in the order they were first seen
+	tlsSessions     []*TLSSession
+	tlsSessionIndex map[string]*TLSSession
+	// protocols holds the counters of every negotiated protocol like HTTP/1.1 and HTTP/2.0
+	protocols map[string]*ProtocolStats
+}
+
+// StageStats are the counters of one stage of a load profile, every count goes to the stage it happened in
+type StageStats struct {
+	Stage         Stage
+	bytes         atomic.Int64
+	uploadedBytes atomic.Int64
+	requests      atomic.Int64
+	failures      atomic.Int64
+	// latency holds the total request durations in nanoseconds
+	latency *Metrics.Histogram
+}
+
+// DNSAnswer is a distinct answer a DoH server gave for a host and client subnet, Count is how often it was given
+type DNSAnswer struct {
+	Host         string   `json:"host"`
+	Server       string   `json:"server"`
+	ClientSubnet string   `json:"client_subnet"`
+	Answers      []string `json:"answers"`
+	Count        int64    `json:"count"`
+}
+
+func NewRunStats() *RunStats {
+	runStats := &RunStats{
+		startTime:                 time.Now(),
+		collector:                 Metrics.NewCollector(),
+		phaseHistograms:           make(map[string]*Metrics.Histogram, len(phases)),
+		throughputHistogram:       Metrics.NewHistogram(),
+		uploadThroughputHistogram: Metrics.NewHistogram(),
+		dnsAnswerIndex:            make(map[string]*DNSAnswer),
+		tlsSessionIndex:           make(map[string]*TLSSession),
+		protocols:                 make(map[string]*ProtocolStats),
+	}
+	for _, phase := range phases {
+		runStats.phaseHistograms[phase] = Metrics.NewHistogram()
+	}
+	return runStats
+}
+
+// Collector returns the counters shared with the DNS queries and the reporter
+func (runStats *RunStats) Collector() *Metrics.Collector {
+	return runStats.collector
+}
+
+// Shard returns the counters of the workers with the given labels
+func (runStats *RunStats) Shard(labels Metrics.Labels) *Metrics.Shard {
+	return runStats.collector.Shard(labels)
+}
+
+// SetSchedule makes the report and the dashboard follow the backlog of an open-loop run
+func (runStats *RunStats) SetSchedule(schedule *RequestSchedule) {
+	runStats.schedule = schedule
+}
+
+// SetBandwidthLimiter makes the report and the dashboard show how much the bandwidth cap throttled the transfers
+func (runStats *RunStats) SetBandwidthLimiter(bandwidthLimiter *BandwidthLimiter) {
+	runStats.bandwidthLimiter = bandwidthLimiter
+}
+
+// SetHappyEyeballs makes the report and the dashboard show which IP family won the connect races
+func (runStats *RunStats) SetHappyEyeballs(happyEyeballs *HappyEyeballs) {
+	runStats.happyEyeballs = happyEyeballs
+}
+
+// SetQUICStats makes the report and the dashboard show the QUIC handshakes, 0-RTT resumptions and packet losses
+func (runStats *RunStats) SetQUICStats(quicStats *QUICStats) {
+	runStats.quicStats = quicStats
+}
+
+// SetLoadProfile splits the counters of the run by the stages of loadProfile
+func (runStats *RunStats) SetLoadProfile(loadProfile *LoadProfile) {
+	runStats.loadProfile = loadProfile
+	runStats.stages = make([]*StageStats, len(loadProfile.Stages))
+	for i, stage := range loadProfile.Stages {
+		runStats.stages[i] = &StageStats{
+			Stage:   stage,
+			latency: Metrics.NewHistogram(),
+		}
+	}
+}
+
+// currentStage returns the counters of the current stage, nil without a load profile or outside of it
+func (runStats *RunStats) currentStage() *StageStats {
+	if runStats.loadProfile == nil {
+		return nil
+	}
+	index, _ := runStats.loadProfile.Current()
+	if index < 0 || index >= len(runStats.stages) {
+		return nil
+	}
+	return runStats.stages[index]
+}
+
+// AddBytes counts downloaded bytes in shard and in the current stage
+func (runStats *RunStats) AddBytes(shard *Metrics.Shard, n int64) {
+	shard.AddBytes(n)
+	if stage := runStats.currentStage(); stage != nil {
+		stage.bytes.Add(n)
+	}
+}
+
+// AddUploadedBytes counts uploaded bytes in shard and in the current stage
+func (runStats *RunStats) AddUploadedBytes(shard *Metrics.Shard, n int64) {
+	shard.AddUploadedBytes(n)
+	if stage := runStats.currentStage(); stage != nil {
+		stage.uploadedBytes.Add(n)
+	}
+}
+
+// AddFailure counts a failed request attempt in shard and in the current stage
+func (runStats *RunStats) AddFailure(shard *Metrics.Shard, class string) {
+	shard.AddFailure(class)
+	if stage := runStats.currentStage(); stage != nil {
+		stage.failures.Add(1)
+	}
+}
+
+// stageElapsed returns how long the stage at index ran until now
+func (runStats *RunStats) stageElapsed(index int, now time.Time) time.Duration {
+	started := runStats.loadProfile.Started()
+	if started.IsZero() {
+		return 0
+	}
+	elapsed := max(now.Sub(started)-runStats.loadProfile.StageStart(index), 0)
+	if duration := runStats.stages[index].Stage.Duration; duration > 0 {
+		elapsed = min(elapsed, duration)
+	}
+	return elapsed
+}
+
+// stageSummary formats a table with the throughput, error rate and latency of every stage of the load profile
+func (runStats *RunStats) stageSummary(report []ReportStage) string {
+	var builder strings.Builder
+	writer := tabwriter.NewWriter(&builder, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(writer, "stage	concurrency	duration	requests	failures	error rate	Mbps	p50	p99	")
+	for _, stage := range report {
+		concurrency := strconv.Itoa(stage.Concurrency)
+		if stage.Ramp {
+			concurrency = "->" + concurrency
+		}
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%d\t%.2f%%\t%.2f\t%s\t%s\t\n", stage.Name, concurrency,
+			time.Duration(stage.DurationSeconds*float64(time.Second)).Round(time.Millisecond), stage.Requests, stage.Failures,
+			stage.ErrorRate*100, stage.AverageBitsPerSecond/1e6, formatNanos(stage.Latency.P50*float64(time.Millisecond)),
+			formatNanos(stage.Latency.P99*float64(time.Millisecond)))
+	}
+	_ = writer.Flush()
+	return builder.String()
+}
+
+// SetDNSTarget records the DoH server and the EDNS client subnet of the latest DNS query
+func (runStats *RunStats) SetDNSTarget(dnsServer, clientSubnet string) {
+	runStats.mutex.Lock()
+	defer runStats.mutex.Unlock()
+	runStats.dnsServer = dnsServer
+	runStats.clientSubnet = clientSubnet
+}
+
+func (runStats *RunStats) DNSTarget() (dnsServer, clientSubnet string) {
+	runStats.mutex.Lock()
+	defer runStats.mutex.Unlock()
+	return runStats.dnsServer, runStats.clientSubnet
+}
+
+// AddDNSAnswer records the IPs a DNS query for host returned
+func (runStats *RunStats) AddDNSAnswer(host, dnsServer, clientSubnet string, ips []*net.IP) {
+	if len(ips) == 0 {
+		return
+	}
+	answers := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		answers = append(answers, ip.String())
+	}
+	key := strings.Join(append([]string{host, dnsServer, clientSubnet}, answers...), " ")
+	runStats.mutex.Lock()
+	defer runStats.mutex.Unlock()
+	dnsAnswer, ok := runStats.dnsAnswerIndex[key]
+	if !ok {
+		dnsAnswer = &DNSAnswer{
+			Host:         host,
+			Server:       dnsServer,
+			ClientSubnet: clientSubnet,
+			Answers:      answers,
+		}
+		runStats.dnsAnswerIndex[key] = dnsAnswer
+		runStats.dnsAnswers = append(runStats.dnsAnswers, dnsAnswer)
+	}
+	dnsAnswer.Count++
+}
+
+// DNSAnswers returns a copy of the distinct DNS answers
+func (runStats *RunStats) DNSAnswers() []DNSAnswer {
+	runStats.mutex.Lock()
+	defer runStats.mutex.Unlock()
+	dnsAnswers := make([]DNSAnswer, 0, len(runStats.dnsAnswers))
+	for _, dnsAnswer := range runStats.dnsAnswers {
+		dnsAnswers = append(dnsAnswers, *dnsAnswer)
+	}
+	return dnsAnswers
+}
+
+// AddRequest counts a successful request on shard and records its phase timings and throughput,
+// written is the response body size and uploaded the request body size.
+// Phases that did not happen, like the handshakes on a reused connection, are left out of their histograms.
+func (runStats *RunStats) AddRequest(shard *Metrics.Shard, written, uploaded int64, requestTiming *RequestTiming) {
+	if requestTiming == nil {
+		shard.AddRequest(false)
+		return
+	}
+	shard.AddRequest(requestTiming.ConnReused)
+	if stage := runStats.currentStage(); stage != nil {
+		stage.requests.Add(1)
+		stage.latency.Record(int64(requestTiming.Total))
+	}
+	if !requestTiming.scheduledAt.IsZero() {
+		runStats.phaseHistograms[PhaseQueue].Record(int64(requestTiming.Queue))
+	}
+	if requestTiming.DNS > 0 {
+		runStats.phaseHistograms[PhaseDNS].Record(int64(requestTiming.DNS))
+	}
+	if !requestTiming.ConnReused {
+		runStats.phaseHistograms[PhaseConnect].Record(int64(requestTiming.Connect))
+		if requestTiming.TLSHandshake > 0 {
+			runStats.phaseHistograms[PhaseTLSHandshake].Record(int64(requestTiming.TLSHandshake))
+		}
+	}
+	if uploaded > 0 {
+		runStats.phaseHistograms[PhaseUpload].Record(int64(requestTiming.Upload))
+		if requestTiming.Upload > 0 {
+			runStats.uploadThroughputHistogram.Record(int64(float64(uploaded) / requestTiming.Upload.Seconds()))
+		}
+	}
+	runStats.phaseHistograms[PhaseTTFB].Record(int64(requestTiming.TTFB))
+	runStats.phaseHistograms[PhaseTransfer].Record(int64(requestTiming.Transfer))
+	runStats.phaseHistograms[PhaseTotal].Record(int64(requestTiming.Total))
+	if requestTiming.Total > 0 {
+		runStats.throughputHistogram.Record(int64(float64(written) / requestTiming.Total.Seconds()))
+	}
+	if requestTiming.Protocol != "" {
+		protocolStats := runStats.protocolStats(requestTiming.Protocol)
+		protocolStats.requests.Add(1)
+		protocolStats.bytes.Add(written)
+		if requestTiming.Total > 0 {
+			protocolStats.streamThroughput.Record(int64(float64(written) / requestTiming.Total.Seconds()))
+		}
+	}
+}
+
+func (runStats *RunStats) TotalBytes() int64 {
+	return runStats.collector.TotalBytes()
+}
+
+// TransferredBytes is the sum of the downloaded and the uploaded bytes
+func (runStats *RunStats) TransferredBytes() int64 {
+	return runStats.collector.TotalBytes() + runStats.collector.TotalUploadedBytes()
+}
+
+func (runStats *RunStats) TotalRequests() int64 {
+	return runStats.collector.TotalRequests()
+}
+
+func (runStats *RunStats) Elapsed() time.Duration {
+	return time.Since(runStats.startTime)
+}
+
+// PhaseSummary formats a table with the latency distribution of every request phase and the per-request throughput
+func (runStats *RunStats) PhaseSummary() string {
+	return runStats.phaseSummary(runStats.collector.Snapshot())
+}
+
+func (runStats *RunStats) phaseSummary(snapshot Metrics.Snapshot) string {
+	var builder strings.Builder
+	writer := tabwriter.NewWriter(&builder, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(writer, "phase\tcount\tmin\tmean\tmax\tstddev\tp50\tp90\tp95\tp99\tp99.9\t")
+	for _, phase := range phases {
+		histogram := runStats.phaseHistograms[phase].Snapshot()
+		if histogram.Count == 0 {
+			continue
+		}
+		_, _ = fmt.Fprintf(writer, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", phase, histogram.Count,
+			formatNanos(float64(histogram.Min)), formatNanos(histogram.Mean), formatNanos(float64(histogram.Max)), formatNanos(histogram.StdDev),
+			formatNanos(float64(histogram.P50)), formatNanos(float64(histogram.P90)), formatNanos(float64(histogram.P95)),
+			formatNanos(float64(histogram.P99)), formatNanos(float64(histogram.P999)))
+	}
+	for _, throughput := range []struct {
+		name      string
+		histogram *Metrics.Histogram
+	}{{"Mbps", runStats.throughputHistogram}, {"upload Mbps", runStats.uploadThroughputHistogram}} {
+		histogram := throughput.histogram.Snapshot()
+		if histogram.Count == 0 {
+			continue
+		}
+		_, _ = fmt.Fprintf(writer, "%s\t%d\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t\n", throughput.name, histogram.Count,
+			toMbps(float64(histogram.Min)), toMbps(histogram.Mean), toMbps(float64(histogram.Max)), toMbps(histogram.StdDev),
+			toMbps(float64(histogram.P50)), toMbps(float64(histogram.P90)), toMbps(float64(histogram.P95)),
+			toMbps(float64(histogram.P99)), toMbps(float64(histogram.P999)))
+	}
+	_ = writer.Flush()
+	_, _ = fmt.Fprintf(&builder, "Reused connections: %d/%d", snapshot.Total.ReusedConns, snapshot.Total.Requests)
+	if snapshot.Total.Requests > 0 {
+		_, _ = fmt.Fprintf(&builder, " (%.1f%%)", float64(snapshot.Total.ReusedConns)/float64(snapshot.Total.Requests)*100)
+	}
+	return builder.String()
+}
+
+// formatNanos formats a duration given in nanoseconds with a precision that suits the report
+func formatNanos(nanos float64) string {
+	duration := time.Duration(nanos)
+	switch {
+	case duration >= time.Second:
+		return duration.Round(time.Millisecond).String()
+	case duration >= time.Millisecond:
+		return duration.Round(10 * time.Microsecond).String()
+	default:
+		return duration.Round(time.Microsecond).String()
+	}
+}
+
+// toMbps converts bytes per second to megabits per second
+func toMbps(bytesPerSecond float64) float64 {
+	return bytesPerSecond * 8 / 1e6
+}
+
+// Summary formats the end-of-run statistics
+func (runStats *RunStats) Summary() string {
+	elapsed := runStats.Elapsed()
+	snapshot := runStats.collector.Snapshot()
+	var averageRate, averageUploadRate float64
+	if elapsed > 0 {
+		averageRate = float64(snapshot.Total.Bytes) / elapsed.Seconds()
+		averageUploadRate = float64(snapshot.Total.UploadedBytes) / elapsed.Seconds()
+	}
+	var uploaded string
+	if snapshot.Total.UploadedBytes > 0 {
+		uploaded = fmt.Sprintf(", Total uploaded: %s, Average upload speed: %s (%s)", Utils.FormatBytes(snapshot.Total.UploadedBytes),
+			Utils.FormatBitRate(averageUploadRate), Utils.FormatByteRate(averageUploadRate))
+	}
+	// sections holds the lines of the summary, every feature of the run that is on adds its own
+	sections := []string{fmt.Sprintf("Elapsed: %s, Requests: %d (failed %d), Total downloaded: %s, Average speed: %s (%s)%s, DNS queries: %d (failed %d)",
+		elapsed.Round(time.Millisecond), snapshot.Total.Requests, snapshot.Total.Failures, Utils.FormatBytes(snapshot.Total.Bytes),
+		Utils.FormatBitRate(averageRate), Utils.FormatByteRate(averageRate), uploaded, snapshot.DNSQueries, snapshot.DNSFailures)}
+	if snapshot.Total.Failures > 0 {
+		failures := make([]string, 0, len(snapshot.Total.FailureClasses))
+		for class := range snapshot.Total.FailureClasses {
+			failures = append(failures, class)
+		}
+		sort.Strings(failures)
+		for i, class := range failures {
+			failures[i] = fmt.Sprintf("%s %d", class, snapshot.Total.FailureClasses[class])
+		}
+		sections = append(sections, fmt.Sprintf("Failures: %s, retries %d", strings.Join(failures, ", "), snapshot.Total.Retries))
+	}
+	if localIPs := snapshot.GroupBy(func(labels Metrics.Labels) string { return labels.LocalIP }); len(localIPs) > 1 {
+		perLocalIP := make([]string, 0, len(localIPs))
+		for _, group := range localIPs {
+			var rate float64
+			if elapsed > 0 {
+				rate = float64(group.Bytes) / elapsed.Seconds()
+			}
+			perLocalIP = append(perLocalIP, fmt.Sprintf("%s %s (%s, %d requests, %d failed)", group.Labels.LocalIP,
+				Utils.FormatBytes(group.Bytes), Utils.FormatBitRate(rate), group.Requests, group.Failures))
+		}
+		sections = append(sections, "Local IPs: "+strings.Join(perLocalIP, ", "))
+	}
+	sections = append(sections, ipFamilySummary(snapshot, elapsed)...)
+	if protocols := runStats.Protocols(); len(protocols) > 0 {
+		perProtocol := make([]string, 0, len(protocols))
+		for _, protocol := range protocols {
+			protocolStats := runStats.protocolStats(protocol)
+			perProtocol = append(perProtocol, fmt.Sprintf("%s %d requests over %d connections (%.1f streams per connection, peak %d), %s per stream",
+				protocol, protocolStats.requests.Load(), protocolStats.connections.Load(), protocolStats.StreamsPerConnection(),
+				protocolStats.peakStreams.Snapshot().Max, Utils.FormatBitRate(protocolStats.streamThroughput.Snapshot().Mean)))
+		}
+		sections = append(sections, "Protocols: "+strings.Join(perProtocol, ", "))
+	}
+	if runStats.schedule != nil {
+		sections = append(sections, fmt.Sprintf("Open loop: target %.2f req/s, scheduled %d, backlog %d (max %d)", runStats.schedule.Rate,
+			runStats.schedule.Scheduled(), runStats.schedule.Backlog(), runStats.schedule.MaxBacklog()))
+	}
+	if limiter := runStats.bandwidthLimiter; limiter != nil {
+		sections = append(sections, fmt.Sprintf("Bandwidth cap: %s, throttled %d reads for %s", limiter, limiter.Waits(),
+			limiter.Throttled().Round(time.Millisecond)))
+	}
+	if happyEyeballs := runStats.happyEyeballs; happyEyeballs != nil {
+		ipv6, ipv4 := happyEyeballs.Family(FamilyIPv6), happyEyeballs.Family(FamilyIPv4)
+		sections = append(sections, fmt.Sprintf("Happy eyeballs: %d races, IPv6 won %d, IPv4 won %d, %d failed, median margin %s, connects succeeded IPv6 %.1f%% IPv4 %.1f%%",
+			happyEyeballs.Races(), ipv6.Wins(), ipv4.Wins(), happyEyeballs.FailedRaces(), formatNanos(float64(happyEyeballs.Margins().P50)),
+			ipv6.SuccessRate()*100, ipv4.SuccessRate()*100))
+	}
+	if quicStats := runStats.quicStats; quicStats != nil {
+		sections = append(sections, fmt.Sprintf("QUIC: %d handshakes (%d 0-RTT), median handshake %s, %d of %d packets lost (%.2f%%), %d probe timeouts",
+			quicStats.Handshakes(), quicStats.ZeroRTT(), formatNanos(float64(quicStats.Handshake().P50)), quicStats.PacketsLost(),
+			quicStats.PacketsSent(), quicStats.LossRate()*100, quicStats.ProbeTimeouts()))
+	}
+	if tlsSessions := runStats.TLSSessions(); len(tlsSessions) > 0 {
+		sections = append(sections, tlsSummary(tlsSessions))
+	}
+	if snapshot.WorkerPanics+snapshot.WorkerErrors > 0 {
+		sections = append(sections, fmt.Sprintf("Worker failures: %d panics, %d errors, %d restarts, %d retired",
+			snapshot.WorkerPanics, snapshot.WorkerErrors, snapshot.WorkerRestarts, snapshot.RetiredWorkers))
+	}
+	sections = append(sections, runStats.phaseSummary(snapshot))
+	if runStats.loadProfile != nil {
+		sections = append(sections, runStats.stageSummary(runStats.stageReports(time.Now())))
+	}
+	return strings.Join(sections, "\n")
+}
+
+// ipFamilySummary compares IPv4 and IPv6 for every URL that was downloaded over both, one line each
+func ipFamilySummary(snapshot Metrics.Snapshot, elapsed time.Duration) []string {
+	byURL := func(labels Metrics.Labels) string { return labels.URL }
+	ipv6Groups := make(map[string]Metrics.ShardSnapshot)
+	for _, group := range familySnapshot(snapshot, FamilyIPv6).GroupBy(byURL) {
+		ipv6Groups[group.Labels.URL] = group
+	}
+	format := func(family string, group Metrics.ShardSnapshot) string {
+		var rate float64
+		if elapsed > 0 {
+			rate = float64(group.Bytes) / elapsed.Seconds()
+		}
+		return fmt.Sprintf("%s %s (%s, %d requests, %d failed)", family, Utils.FormatBytes(group.Bytes), Utils.FormatBitRate(rate),
+			group.Requests, group.Failures)
+	}
+	var lines []string
+	for _, ipv4Group := range familySnapshot(snapshot, FamilyIPv4).GroupBy(byURL) {
+		if ipv6Group, ok := ipv6Groups[ipv4Group.Labels.URL]; ok {
+			lines = append(lines, fmt.Sprintf("IPv4 vs IPv6 for %s: %s, %s", ipv4Group.Labels.URL, format(FamilyIPv4, ipv4Group), format(FamilyIPv6, ipv6Group)))
+		}
+	}
+	return lines
+}
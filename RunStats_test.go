@@ -0,0 +1,39 @@
+package main
+
+import (
+	"HttpBenchmark/Metrics"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunStatsPhaseSummary(t *testing.T) {
+	runStats := NewRunStats()
+	shard := runStats.Shard(Metrics.Labels{})
+	for i := 1; i <= 100; i++ {
+		shard.AddBytes(1000000)
+		runStats.AddRequest(shard, 1000000, 0, &RequestTiming{
+			Connect:      time.Duration(i) * time.Millisecond,
+			TLSHandshake: 2 * time.Duration(i) * time.Millisecond,
+			TTFB:         10 * time.Millisecond,
+			Transfer:     time.Second,
+			Total:        time.Second,
+			ConnReused:   i%2 == 0,
+		})
+	}
+	summary := runStats.PhaseSummary()
+	t.Log("\n" + summary)
+	assert.Contains(t, summary, "p99.9")
+	assert.Contains(t, summary, "Reused connections: 50/100")
+	assert.NotContains(t, summary, PhaseDNS+" ")
+	for _, line := range strings.Split(summary, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "Mbps" {
+			assert.Equal(t, "8.00", fields[2])
+		}
+		if len(fields) > 0 && fields[0] == PhaseConnect {
+			assert.Equal(t, "50", fields[1])
+		}
+	}
+}
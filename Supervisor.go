@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Restart policies of failed download workers, on-failure restarts a worker up to MaxRestarts times
+// and never retires it on its first failure
+const (
+	RestartOnFailure = "on-failure"
+	RestartNever     = "never"
+)
+
+// Supervisor runs the download workers, it isolates their panics and errors and restarts or retires a failed worker
+// according to its policy, so that one broken worker never takes down the whole benchmark
+type Supervisor struct {
+	Policy string
+	// MaxRestarts bounds the restarts of a single worker, it is retired after that
+	MaxRestarts  int
+	RestartDelay time.Duration
+	runStats     *RunStats
+	runLimit     *RunLimit
+}
+
+type SupervisorOption func(*Supervisor)
+
+func WithRestartPolicy(policy string, maxRestarts int) SupervisorOption {
+	return func(supervisor *Supervisor) {
+		supervisor.Policy = policy
+		supervisor.MaxRestarts = maxRestarts
+	}
+}
+
+func WithRestartDelay(restartDelay time.Duration) SupervisorOption {
+	return func(supervisor *Supervisor) {
+		supervisor.RestartDelay = restartDelay
+	}
+}
+
+func NewSupervisor(runStats *RunStats, runLimit *RunLimit, opts ...SupervisorOption) *Supervisor {
+	supervisor := &Supervisor{
+		Policy:       RestartOnFailure,
+		MaxRestarts:  3,
+		RestartDelay: time.Second,
+		runStats:     runStats,
+		runLimit:     runLimit,
+	}
+	for _, opt := range opts {
+		opt(supervisor)
+	}
+	return supervisor
+}
+
+// workerPanic is the error of a worker run that panicked
+type workerPanic struct {
+	value any
+	stack []byte
+}
+
+func (panicErr *workerPanic) Error() string {
+	return fmt.Sprintf("panic: %v", panicErr.value)
+}
+
+// Go runs worker on its own goroutine under Run and marks it done in waitGroup when it has finished for good
+func (supervisor *Supervisor) Go(ctx context.Context, waitGroup *sync.WaitGroup, name string, worker func(context.Context) error) {
+	waitGroup.Add(1)
+	go func() {
+		defer waitGroup.Done()
+		supervisor.Run(ctx, name, worker)
+	}()
+}
+
+// Run runs worker until it returns without an error, restarting it after a panic or an error as the policy allows.
+// A worker is not restarted once ctx is cancelled or the run limit is reached.
+func (supervisor *Supervisor) Run(ctx context.Context, name string, worker func(context.Context) error) {
+	for restarts := 0; ; restarts++ {
+		err := supervisor.runOnce(ctx, worker)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		if panicErr, ok := err.(*workerPanic); ok {
+			log.Errorf("Worker %s panicked: %v\n%s", name, panicErr.value, panicErr.stack)
+		} else {
+			log.Errorf("Worker %s failed: %s", name, err)
+		}
+		if supervisor.Policy == RestartNever || restarts >= supervisor.MaxRestarts || (supervisor.runLimit != nil && supervisor.runLimit.Reached()) {
+			log.Warnf("Worker %s retired after %d restarts", name, restarts)
+			supervisor.collect(func() { supervisor.runStats.Collector().WorkerRetired() })
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(supervisor.RestartDelay):
+		}
+		log.Infof("Restarting worker %s", name)
+		supervisor.collect(func() { supervisor.runStats.Collector().WorkerRestarted() })
+	}
+}
+
+func (supervisor *Supervisor) runOnce(ctx context.Context, worker func(context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &workerPanic{value: r, stack: debug.Stack()}
+			supervisor.collect(func() { supervisor.runStats.Collector().WorkerFailed(true) })
+		}
+	}()
+	err = worker(ctx)
+	if err != nil {
+		supervisor.collect(func() { supervisor.runStats.Collector().WorkerFailed(false) })
+	}
+	return err
+}
+
+// collect runs update when the supervisor reports to run stats
+func (supervisor *Supervisor) collect(update func()) {
+	if supervisor.runStats != nil {
+		update()
+	}
+}
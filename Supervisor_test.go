@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorRestartsFailedWorkers(t *testing.T) {
+	runStats := NewRunStats()
+	supervisor := NewSupervisor(runStats, nil, WithRestartPolicy(RestartOnFailure, 2), WithRestartDelay(time.Millisecond))
+	var panics, attempts atomic.Int64
+	var waitGroup sync.WaitGroup
+	supervisor.Go(context.Background(), &waitGroup, "panicking", func(context.Context) error {
+		panics.Add(1)
+		panic("broken worker")
+	})
+	supervisor.Go(context.Background(), &waitGroup, "recovering", func(context.Context) error {
+		if attempts.Add(1) == 1 {
+			return errors.New("no request could be built")
+		}
+		return nil
+	})
+	waitGroup.Wait()
+
+	// The panicking worker runs three times and is retired, the other one recovers after one restart
+	assert.Equal(t, int64(3), panics.Load())
+	assert.Equal(t, int64(2), attempts.Load())
+	snapshot := runStats.Collector().Snapshot()
+	assert.Equal(t, int64(3), snapshot.WorkerPanics)
+	assert.Equal(t, int64(1), snapshot.WorkerErrors)
+	assert.Equal(t, int64(3), snapshot.WorkerRestarts)
+	assert.Equal(t, int64(1), snapshot.RetiredWorkers)
+	assert.Contains(t, runStats.Summary(), "Worker failures: 3 panics")
+}
+
+func TestSupervisorNeverRestarts(t *testing.T) {
+	runStats := NewRunStats()
+	supervisor := NewSupervisor(runStats, nil, WithRestartPolicy(RestartNever, 5))
+	runs := 0
+	supervisor.Run(context.Background(), "failing", func(context.Context) error {
+		runs++
+		return errors.New("no request could be built")
+	})
+	assert.Equal(t, 1, runs)
+	snapshot := runStats.Collector().Snapshot()
+	assert.Equal(t, int64(1), snapshot.WorkerErrors)
+	assert.Equal(t, int64(0), snapshot.WorkerRestarts)
+	assert.Equal(t, int64(1), snapshot.RetiredWorkers)
+}
+
+func TestSupervisorStopsOnCancel(t *testing.T) {
+	supervisor := NewSupervisor(nil, nil, WithRestartDelay(time.Hour))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		supervisor.Run(ctx, "failing", func(context.Context) error {
+			return errors.New("no request could be built")
+		})
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the supervisor kept waiting to restart a worker of a cancelled run")
+	}
+}
+
+func TestDownloadWorkerError(t *testing.T) {
+	// A body file that disappears during the run fails the worker instead of the process
+	remoteIP := net.ParseIP("127.0.0.1")
+	downloadHttpConfig := NewDownloadHttpConfig(WithUrl(&url.URL{Scheme: "http", Host: "download.invalid"}), WithRemoteIP(&remoteIP),
+		WithBodyFile(t.TempDir()+"/missing"))
+	err := downloadHttpConfig.DoHttpDownload(context.Background())
+	assert.ErrorContains(t, err, "creating the request body")
+}
@@ -0,0 +1,13 @@
+//go:build go1.25
+
+package main
+
+import "crypto/tls"
+
+// tlsCurve returns the key exchange group of state, crypto/tls reports it since Go 1.25
+func tlsCurve(state tls.ConnectionState) string {
+	if state.CurveID == 0 {
+		return ""
+	}
+	return state.CurveID.String()
+}
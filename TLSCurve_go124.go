@@ -0,0 +1,10 @@
+//go:build !go1.25
+
+package main
+
+import "crypto/tls"
+
+// tlsCurve returns an empty key exchange group, crypto/tls before Go 1.25 does not report it
+func tlsCurve(tls.ConnectionState) string {
+	return ""
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	utls "github.com/sagernet/utls"
+	log "github.com/sirupsen/logrus"
+)
+
+// TLSSession is a distinct TLS setup a remote IP negotiated, Connections is how many connections negotiated it and
+// Resumed how many of them resumed an earlier session. Edges that serve another certificate or TLS configuration
+// show up as sessions of their own.
+type TLSSession struct {
+	RemoteIP    string `json:"remote_ip"`
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	ALPN        string `json:"alpn"`
+	// Curve is the key exchange group, empty when the Go version the benchmark was built with does not report it
+	Curve string `json:"curve"`
+	// Subject, SANs, NotAfter and Fingerprint, its SHA-256 hash, describe the leaf certificate
+	Subject     string    `json:"subject"`
+	SANs        []string  `json:"sans"`
+	NotAfter    time.Time `json:"not_after"`
+	Fingerprint string    `json:"fingerprint"`
+	Connections int64     `json:"connections"`
+	Resumed     int64     `json:"resumed"`
+}
+
+// newTLSSession describes the negotiated state of a connection to remoteIP
+func newTLSSession(remoteIP string, state tls.ConnectionState) *TLSSession {
+	tlsSession := &TLSSession{
+		RemoteIP:    remoteIP,
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ALPN:        state.NegotiatedProtocol,
+		Curve:       tlsCurve(state),
+	}
+	if len(state.PeerCertificates) > 0 {
+		leaf := state.PeerCertificates[0]
+		fingerprint := sha256.Sum256(leaf.Raw)
+		tlsSession.Subject = leaf.Subject.String()
+		tlsSession.SANs = append(tlsSession.SANs, leaf.DNSNames...)
+		for _, ip := range leaf.IPAddresses {
+			tlsSession.SANs = append(tlsSession.SANs, ip.String())
+		}
+		tlsSession.NotAfter = leaf.NotAfter
+		tlsSession.Fingerprint = hex.EncodeToString(fingerprint[:])
+	}
+	return tlsSession
+}
+
+// uTLSConnectionState copies the fields of a utls connection state that a TLSSession describes, utls does not
+// report the key exchange group
+func uTLSConnectionState(state utls.ConnectionState) tls.ConnectionState {
+	return tls.ConnectionState{
+		Version:            state.Version,
+		HandshakeComplete:  state.HandshakeComplete,
+		DidResume:          state.DidResume,
+		CipherSuite:        state.CipherSuite,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		ServerName:         state.ServerName,
+		PeerCertificates:   state.PeerCertificates,
+	}
+}
+
+// setup identifies the TLS configuration and certificate of the session regardless of the remote IP
+func (tlsSession *TLSSession) setup() string {
+	return strings.Join([]string{tlsSession.Version, tlsSession.CipherSuite, tlsSession.ALPN, tlsSession.Curve,
+		tlsSession.Fingerprint}, " ")
+}
+
+func (tlsSession *TLSSession) String() string {
+	alpn := tlsSession.ALPN
+	if alpn == "" {
+		alpn = "no ALPN"
+	}
+	description := strings.Join(strings.Fields(strings.Join([]string{tlsSession.Version, tlsSession.CipherSuite, alpn,
+		tlsSession.Curve}, " ")), " ")
+	if tlsSession.Fingerprint != "" {
+		description += fmt.Sprintf(", certificate %q expires %s", tlsSession.Subject, tlsSession.NotAfter.Format(time.DateOnly))
+	}
+	return description
+}
+
+// AddTLSConnection records the negotiated state of a new TLS connection to remoteAddr
+func (runStats *RunStats) AddTLSConnection(remoteAddr net.Addr, state tls.ConnectionState) {
+	remoteIP := remoteAddr.String()
+	if host, _, err := net.SplitHostPort(remoteIP); err == nil {
+		remoteIP = host
+	}
+	tlsSession := newTLSSession(remoteIP, state)
+	log.Debugf("TLS connection to %s: %s, SANs %s, resumed %t", remoteIP, tlsSession, strings.Join(tlsSession.SANs, ","),
+		state.DidResume)
+	key := remoteIP + " " + tlsSession.setup()
+	runStats.mutex.Lock()
+	defer runStats.mutex.Unlock()
+	if existing, ok := runStats.tlsSessionIndex[key]; ok {
+		tlsSession = existing
+	} else {
+		runStats.tlsSessionIndex[key] = tlsSession
+		runStats.tlsSessions = append(runStats.tlsSessions, tlsSession)
+	}
+	tlsSession.Connections++
+	if state.DidResume {
+		tlsSession.Resumed++
+	}
+}
+
+// TLSSessions returns a copy of the distinct TLS sessions ordered by remote IP
+func (runStats *RunStats) TLSSessions() []TLSSession {
+	runStats.mutex.Lock()
+	defer runStats.mutex.Unlock()
+	tlsSessions := make([]TLSSession, 0, len(runStats.tlsSessions))
+	for _, tlsSession := range runStats.tlsSessions {
+		tlsSessions = append(tlsSessions, *tlsSession)
+	}
+	sort.SliceStable(tlsSessions, func(i, j int) bool {
+		return tlsSessions[i].RemoteIP < tlsSessions[j].RemoteIP
+	})
+	return tlsSessions
+}
+
+// tlsSummary lists the distinct TLS setups with the remote IPs that negotiated them, one line each, so that edges
+// with another certificate or configuration than the rest stand out
+func tlsSummary(tlsSessions []TLSSession) string {
+	type setupStats struct {
+		session     TLSSession
+		remoteIPs   []string
+		connections int64
+		resumed     int64
+	}
+	var setups []*setupStats
+	index := make(map[string]*setupStats)
+	for _, tlsSession := range tlsSessions {
+		stats, ok := index[tlsSession.setup()]
+		if !ok {
+			stats = &setupStats{session: tlsSession}
+			index[tlsSession.setup()] = stats
+			setups = append(setups, stats)
+		}
+		stats.remoteIPs = append(stats.remoteIPs, tlsSession.RemoteIP)
+		stats.connections += tlsSession.Connections
+		stats.resumed += tlsSession.Resumed
+	}
+	lines := make([]string, 0, len(setups))
+	for _, stats := range setups {
+		lines = append(lines, fmt.Sprintf("TLS: %s on %d remote IPs (%s), %d connections, %d resumed", &stats.session,
+			len(stats.remoteIPs), strings.Join(stats.remoteIPs, ", "), stats.connections, stats.resumed))
+	}
+	return strings.Join(lines, "\n")
+}
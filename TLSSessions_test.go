@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSSessionsPerRemoteIP(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 1024))
+	}))
+	defer server.Close()
+
+	runStats := NewRunStats()
+	downloadHttpConfig := newTestDownloadHttpConfig(t, server)
+	downloadHttpConfig.ReuseConn = false
+	WithRunStats(runStats)(downloadHttpConfig)
+	WithSingleIpDownloadTimes(3)(downloadHttpConfig)
+	assert.Nil(t, downloadHttpConfig.DoHttpDownload(context.Background()))
+
+	report := runStats.Report(ReportConfig{}, StopReasonLimit)
+	assert.Len(t, report.TLSSessions, 1)
+	tlsSession := report.TLSSessions[0]
+	assert.Equal(t, "127.0.0.1", tlsSession.RemoteIP)
+	assert.Equal(t, "TLS 1.3", tlsSession.Version)
+	assert.NotEmpty(t, tlsSession.CipherSuite)
+	// The certificate of httptest is issued to example.com and the loopback addresses
+	assert.Contains(t, tlsSession.SANs, "example.com")
+	assert.Contains(t, tlsSession.SANs, "127.0.0.1")
+	assert.Equal(t, "O=Acme Co", tlsSession.Subject)
+	assert.Len(t, tlsSession.Fingerprint, 64)
+	assert.Equal(t, int64(3), tlsSession.Connections)
+	// The later connections of the worker resume the session of the first one
+	assert.Equal(t, int64(2), tlsSession.Resumed)
+	assert.Contains(t, runStats.Summary(), "TLS: TLS 1.3 "+tlsSession.CipherSuite)
+}
+
+func TestTLSSummaryGroupsSetups(t *testing.T) {
+	edge := TLSSession{Version: "TLS 1.3", CipherSuite: "TLS_AES_128_GCM_SHA256", ALPN: "h2", Subject: "CN=download.example",
+		Fingerprint: "aa", Connections: 2}
+	first, second, stale := edge, edge, edge
+	first.RemoteIP, second.RemoteIP, stale.RemoteIP = "192.0.2.1", "192.0.2.2", "192.0.2.3"
+	stale.Fingerprint, stale.Resumed = "bb", 1
+
+	lines := strings.Split(tlsSummary([]TLSSession{first, second, stale}), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "on 2 remote IPs (192.0.2.1, 192.0.2.2), 4 connections, 0 resumed")
+	// The edge with another certificate gets a line of its own
+	assert.Contains(t, lines[1], "on 1 remote IPs (192.0.2.3), 2 connections, 1 resumed")
+}
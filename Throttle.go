@@ -0,0 +1,212 @@
+package main
+
+import (
+	"HttpBenchmark/Utils"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// throttleChunk bounds a single throttled read, so that the bytes of a read never exceed the burst of a bucket by much
+// and the workers sharing a bucket take turns
+const throttleChunk = 16 * 1024
+
+// ParseBandwidth parses a bandwidth like 200Mbps, 1.5Gbps, 500kbps or 25MB/s into bytes per second,
+// a bare number is taken as bits per second
+func ParseBandwidth(value string) (float64, error) {
+	units := []struct {
+		suffix     string
+		multiplier float64
+	}{
+		// Byte rates first, MB/s must not be taken for a bit rate
+		{"GB/s", 1e9}, {"MB/s", 1e6}, {"KB/s", 1e3}, {"kB/s", 1e3}, {"B/s", 1},
+		{"Gbps", 1e9 / 8}, {"Mbps", 1e6 / 8}, {"kbps", 1e3 / 8}, {"Kbps", 1e3 / 8}, {"bps", 1.0 / 8},
+	}
+	number, multiplier := value, 1.0/8
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			number, multiplier = strings.TrimSuffix(value, unit.suffix), unit.multiplier
+			break
+		}
+	}
+	bandwidth, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || bandwidth < 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q, use a value like 200Mbps or 25MB/s", value)
+	}
+	return bandwidth * multiplier, nil
+}
+
+// TokenBucket lets Rate bytes per second through on average and up to Burst bytes at once
+type TokenBucket struct {
+	Rate  float64
+	Burst float64
+
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a full bucket of rate bytes per second, its burst is a tenth of a second of traffic
+// but at least one throttled read
+func NewTokenBucket(rate float64) *TokenBucket {
+	burst := max(rate/10, throttleChunk)
+	return &TokenBucket{
+		Rate:   rate,
+		Burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// Reserve takes n bytes from the bucket and returns how long to wait before sending them.
+// The bucket goes into debt for the bytes it cannot cover, so the reservations queue up in order.
+func (bucket *TokenBucket) Reserve(n int64) time.Duration {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	now := time.Now()
+	bucket.tokens = min(bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.Rate, bucket.Burst)
+	bucket.last = now
+	bucket.tokens -= float64(n)
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens / bucket.Rate * float64(time.Second))
+}
+
+// BandwidthLimiter caps the transferred bytes of the whole run and, optionally, of every local IP
+type BandwidthLimiter struct {
+	// Limit and PerLocalIP are in bytes per second, 0 leaves the traffic uncapped
+	Limit      float64
+	PerLocalIP float64
+
+	global   *TokenBucket
+	mutex    sync.Mutex
+	localIPs map[string]*TokenBucket
+	// throttled is the total wait in nanoseconds, waits counts the reads that waited and waiting the ones waiting now
+	throttled atomic.Int64
+	waits     atomic.Int64
+	waiting   atomic.Int64
+}
+
+func NewBandwidthLimiter(limit, perLocalIP float64) *BandwidthLimiter {
+	limiter := &BandwidthLimiter{
+		Limit:      limit,
+		PerLocalIP: perLocalIP,
+		localIPs:   make(map[string]*TokenBucket),
+	}
+	if limit > 0 {
+		limiter.global = NewTokenBucket(limit)
+	}
+	return limiter
+}
+
+// buckets returns the buckets the traffic of localIP is charged to
+func (limiter *BandwidthLimiter) buckets(localIP string) []*TokenBucket {
+	var buckets []*TokenBucket
+	if limiter.global != nil {
+		buckets = append(buckets, limiter.global)
+	}
+	if limiter.PerLocalIP > 0 {
+		limiter.mutex.Lock()
+		bucket, ok := limiter.localIPs[localIP]
+		if !ok {
+			bucket = NewTokenBucket(limiter.PerLocalIP)
+			limiter.localIPs[localIP] = bucket
+		}
+		limiter.mutex.Unlock()
+		buckets = append(buckets, bucket)
+	}
+	return buckets
+}
+
+// Wait charges n bytes of localIP and blocks until all of its buckets let them through, it returns false
+// when ctx is cancelled first
+func (limiter *BandwidthLimiter) Wait(ctx context.Context, localIP string, n int64) bool {
+	var wait time.Duration
+	for _, bucket := range limiter.buckets(localIP) {
+		wait = max(wait, bucket.Reserve(n))
+	}
+	if wait <= 0 {
+		return ctx.Err() == nil
+	}
+	limiter.waits.Add(1)
+	limiter.waiting.Add(1)
+	defer limiter.waiting.Add(-1)
+	start := time.Now()
+	defer func() { limiter.throttled.Add(int64(time.Since(start))) }()
+	return sleepUntil(ctx, start.Add(wait))
+}
+
+// String describes the caps of the limiter, like 200.00Mbps, 50.00Mbps per local IP
+func (limiter *BandwidthLimiter) String() string {
+	var caps []string
+	if limiter.Limit > 0 {
+		caps = append(caps, Utils.FormatBitRate(limiter.Limit))
+	}
+	if limiter.PerLocalIP > 0 {
+		caps = append(caps, Utils.FormatBitRate(limiter.PerLocalIP)+" per local IP")
+	}
+	return strings.Join(caps, ", ")
+}
+
+// Throttled returns the total time the transfers waited for the limiter
+func (limiter *BandwidthLimiter) Throttled() time.Duration {
+	return time.Duration(limiter.throttled.Load())
+}
+
+// Waits returns the number of reads that had to wait for the limiter
+func (limiter *BandwidthLimiter) Waits() int64 {
+	return limiter.waits.Load()
+}
+
+// Waiting returns the number of transfers waiting for the limiter right now
+func (limiter *BandwidthLimiter) Waiting() int64 {
+	return limiter.waiting.Load()
+}
+
+// Reader throttles reader to the bandwidth of localIP. onWait, if set, is called before and after every wait,
+// so that the idle read timeout does not count the time spent in the limiter.
+func (limiter *BandwidthLimiter) Reader(ctx context.Context, localIP string, reader io.Reader, onWait func(waiting bool)) io.Reader {
+	return &throttledReader{
+		ctx:     ctx,
+		limiter: limiter,
+		localIP: localIP,
+		reader:  reader,
+		onWait:  onWait,
+	}
+}
+
+// throttledReader waits for the limiter after every read, the transfer pauses until the bytes are paid for
+// and TCP flow control slows the sender down meanwhile
+type throttledReader struct {
+	ctx     context.Context
+	limiter *BandwidthLimiter
+	localIP string
+	reader  io.Reader
+	onWait  func(waiting bool)
+}
+
+func (reader *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := reader.reader.Read(p)
+	if n > 0 {
+		if reader.onWait != nil {
+			reader.onWait(true)
+		}
+		ok := reader.limiter.Wait(reader.ctx, reader.localIP, int64(n))
+		if reader.onWait != nil {
+			reader.onWait(false)
+		}
+		if !ok && err == nil {
+			err = context.Cause(reader.ctx)
+		}
+	}
+	return n, err
+}
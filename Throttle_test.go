@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	for value, want := range map[string]float64{"200Mbps": 25e6, "1.5Gbps": 187.5e6, "800kbps": 1e5, "25MB/s": 25e6,
+		"512KB/s": 512e3, "8000": 1000} {
+		bandwidth, err := ParseBandwidth(value)
+		assert.Nil(t, err, value)
+		assert.InDelta(t, want, bandwidth, 1e-6, value)
+	}
+	for _, value := range []string{"", "fast", "Mbps", "-1Mbps"} {
+		_, err := ParseBandwidth(value)
+		assert.NotNil(t, err, value)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	bucket := NewTokenBucket(1e6)
+	assert.Equal(t, 1e5, bucket.Burst)
+	// The burst goes through at once, the bytes after it wait for the rate
+	assert.Equal(t, time.Duration(0), bucket.Reserve(1e5))
+	wait := bucket.Reserve(5e4)
+	assert.InDelta(t, float64(50*time.Millisecond), float64(wait), float64(5*time.Millisecond))
+	assert.Greater(t, bucket.Reserve(5e4), wait)
+}
+
+func TestBandwidthLimiterPerLocalIP(t *testing.T) {
+	limiter := NewBandwidthLimiter(0, 1e6)
+	ctx := context.Background()
+	assert.True(t, limiter.Wait(ctx, "192.0.2.1", 1e5))
+	// Every local IP has its own bucket
+	assert.True(t, limiter.Wait(ctx, "192.0.2.2", 1e5))
+	assert.Equal(t, int64(0), limiter.Waits())
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.False(t, limiter.Wait(cancelled, "192.0.2.1", 1e6))
+	assert.Equal(t, int64(1), limiter.Waits())
+	assert.Equal(t, "8.00Mbps per local IP", limiter.String())
+}
+
+func TestDownloadThrottled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 256*1024))
+	}))
+	defer server.Close()
+
+	runStats := NewRunStats()
+	limiter := NewBandwidthLimiter(1e6, 0)
+	runStats.SetBandwidthLimiter(limiter)
+	downloadHttpConfig := newTestDownloadHttpConfig(t, server)
+	for _, opt := range []DownloadHttpConfigOption{WithRunStats(runStats), WithSingleIpDownloadTimes(2), WithBandwidthLimiter(limiter)} {
+		opt(downloadHttpConfig)
+	}
+	start := time.Now()
+	assert.Nil(t, downloadHttpConfig.DoHttpDownload(context.Background()))
+
+	// 512KiB at 1MB/s with a burst of 100kB take at least 0.4s
+	assert.Greater(t, time.Since(start), 350*time.Millisecond)
+	assert.Equal(t, int64(512*1024), runStats.Collector().Snapshot().Total.Bytes)
+	assert.Greater(t, limiter.Waits(), int64(0))
+	assert.Greater(t, limiter.Throttled(), 300*time.Millisecond)
+	report := runStats.Report(ReportConfig{}, StopReasonLimit)
+	assert.Equal(t, 1e6, report.Bandwidth.Limit)
+	assert.Contains(t, runStats.Summary(), "Bandwidth cap: 8.00Mbps, throttled")
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"math/rand"
+	"net"
 	"os"
 	"strings"
 	"time"
@@ -73,3 +74,36 @@ func GetIpSubnetFromEmbedFile(data string, lineNum int) ([]string, error) {
 
 	return lines, nil
 }
+
+// RandomSubnet returns a random subnet of length prefixLen inside cidr, a cidr that is not wider than prefixLen
+// is returned as is. It spreads the EDNS client subnets of the wide IPv6 prefixes over realistic /48 networks.
+func RandomSubnet(cidr string, prefixLen int) (string, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	ones, bits := ipNet.Mask.Size()
+	if ones >= prefixLen || prefixLen > bits {
+		return ipNet.String(), nil
+	}
+	ip := make(net.IP, len(ipNet.IP))
+	rand.New(rand.NewSource(time.Now().UnixNano())).Read(ip)
+	for i := range ip {
+		// Keep the bits of the prefix, randomise the ones up to prefixLen and clear the rest
+		prefixMask, subnetMask := byteMask(ones, i), byteMask(prefixLen, i)
+		ip[i] = ipNet.IP[i]&prefixMask | ip[i]&subnetMask&^prefixMask
+	}
+	return (&net.IPNet{IP: ip, Mask: net.CIDRMask(prefixLen, bits)}).String(), nil
+}
+
+// byteMask returns the byte at index of a network mask of ones bits
+func byteMask(ones, index int) byte {
+	switch {
+	case ones >= (index+1)*8:
+		return 0xff
+	case ones <= index*8:
+		return 0
+	default:
+		return ^byte(0xff >> (ones - index*8))
+	}
+}
@@ -1,7 +1,10 @@
 package Utils
 
 import (
+	"net"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestGetIpSubnetFromFile(t *testing.T) {
@@ -15,3 +18,25 @@ func TestGetIpSubnetFromFile(t *testing.T) {
 		t.Errorf("Expected length of file to be %d, but got %d", expectedLength, len(file))
 	}
 }
+
+func TestRandomSubnet(t *testing.T) {
+	subnet, err := RandomSubnet("2408:8000::/20", 48)
+	assert.Nil(t, err)
+	ip, ipNet, err := net.ParseCIDR(subnet)
+	assert.Nil(t, err)
+	ones, _ := ipNet.Mask.Size()
+	assert.Equal(t, 48, ones)
+	assert.True(t, ip.Equal(ipNet.IP))
+	_, pool, _ := net.ParseCIDR("2408:8000::/20")
+	assert.True(t, pool.Contains(ip))
+
+	// Prefixes that are already narrow enough are kept
+	subnet, err = RandomSubnet("1.0.8.0/21", 24)
+	assert.Nil(t, err)
+	assert.Regexp(t, `^1\.0\.(8|9|10|11|12|13|14|15)\.0/24$`, subnet)
+	subnet, err = RandomSubnet("2001:da8::/32", 24)
+	assert.Nil(t, err)
+	assert.Equal(t, "2001:da8::/32", subnet)
+	_, err = RandomSubnet("2001:da8::", 48)
+	assert.NotNil(t, err)
+}
@@ -4,26 +4,61 @@ import (
 	"fmt"
 )
 
-// Helper function to format bytes to human readable string
+// Helper function to format bytes to human readable string, in IEC units (1 KiB = 1024 B)
 func FormatBytes(bytes int64) string {
 	const (
-		_  = iota
-		KB = 1 << (10 * iota)
-		MB
-		GB
-		TB
+		_   = iota
+		KiB = 1 << (10 * iota)
+		MiB
+		GiB
+		TiB
 	)
 
 	switch {
-	case bytes >= TB:
-		return fmt.Sprintf("%.2fTB", float64(bytes)/float64(TB))
-	case bytes >= GB:
-		return fmt.Sprintf("%.2fGB", float64(bytes)/float64(GB))
-	case bytes >= MB:
-		return fmt.Sprintf("%.2fMB", float64(bytes)/float64(MB))
-	case bytes >= KB:
-		return fmt.Sprintf("%.2fKB", float64(bytes)/float64(KB))
+	case bytes >= TiB:
+		return fmt.Sprintf("%.2fTiB", float64(bytes)/float64(TiB))
+	case bytes >= GiB:
+		return fmt.Sprintf("%.2fGiB", float64(bytes)/float64(GiB))
+	case bytes >= MiB:
+		return fmt.Sprintf("%.2fMiB", float64(bytes)/float64(MiB))
+	case bytes >= KiB:
+		return fmt.Sprintf("%.2fKiB", float64(bytes)/float64(KiB))
 	default:
 		return fmt.Sprintf("%dB", bytes)
 	}
 }
+
+// FormatByteRate formats a rate given in bytes per second in IEC units, like 1.50MiB/s
+func FormatByteRate(bytesPerSecond float64) string {
+	const (
+		KiB = 1 << 10
+		MiB = 1 << 20
+		GiB = 1 << 30
+	)
+
+	switch {
+	case bytesPerSecond >= GiB:
+		return fmt.Sprintf("%.2fGiB/s", bytesPerSecond/GiB)
+	case bytesPerSecond >= MiB:
+		return fmt.Sprintf("%.2fMiB/s", bytesPerSecond/MiB)
+	case bytesPerSecond >= KiB:
+		return fmt.Sprintf("%.2fKiB/s", bytesPerSecond/KiB)
+	default:
+		return fmt.Sprintf("%.0fB/s", bytesPerSecond)
+	}
+}
+
+// FormatBitRate formats a rate given in bytes per second as bits per second in SI units (1 Mbps = 10^6 bit/s)
+func FormatBitRate(bytesPerSecond float64) string {
+	bitsPerSecond := bytesPerSecond * 8
+	switch {
+	case bitsPerSecond >= 1e9:
+		return fmt.Sprintf("%.2fGbps", bitsPerSecond/1e9)
+	case bitsPerSecond >= 1e6:
+		return fmt.Sprintf("%.2fMbps", bitsPerSecond/1e6)
+	case bitsPerSecond >= 1e3:
+		return fmt.Sprintf("%.2fkbps", bitsPerSecond/1e3)
+	default:
+		return fmt.Sprintf("%.0fbps", bitsPerSecond)
+	}
+}
@@ -0,0 +1,17 @@
+package Utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFormatUnits(t *testing.T) {
+	assert.Equal(t, "512B", FormatBytes(512))
+	assert.Equal(t, "1.50MiB", FormatBytes(3<<19))
+	assert.Equal(t, "1.00KiB/s", FormatByteRate(1024))
+	assert.Equal(t, "2.00GiB/s", FormatByteRate(2<<30))
+	// 12.5 MB/s is exactly 100 Mbps in SI units
+	assert.Equal(t, "100.00Mbps", FormatBitRate(12.5e6))
+	assert.Equal(t, "1.00Gbps", FormatBitRate(125e6))
+	assert.Equal(t, "800bps", FormatBitRate(100))
+}
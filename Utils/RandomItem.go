@@ -3,7 +3,9 @@ package Utils
 import (
 	"crypto/tls"
 	"fmt"
+	"io"
 	"math/rand"
+	"net"
 	"time"
 )
 
@@ -21,6 +23,14 @@ func GenerateRandomIPAddress() string {
 	return ip
 }
 
+// GenerateRandomIPv6Address returns a random address of the global unicast range 2000::/3
+func GenerateRandomIPv6Address() string {
+	ip := make(net.IP, net.IPv6len)
+	rand.New(rand.NewSource(time.Now().UnixNano())).Read(ip)
+	ip[0] = 0x20 | ip[0]&0x1f
+	return ip.String()
+}
+
 func GenerateRRandStringBytesMaskImper(n int) string {
 	b := make([]byte, n)
 	for i, cache, remain := n-1, rand.Int63(), letterIdxMax; i >= 0; {
@@ -94,3 +104,42 @@ func RandomTlsConfig() *tls.Config {
 	}
 	return tlsConfig
 }
+
+// randomBlockSize is the size of the random block that random payloads repeat,
+// generating fresh random bytes for every payload would cost more CPU than sending them
+const randomBlockSize = 64 * 1024
+
+var randomBlock = func() []byte {
+	block := make([]byte, randomBlockSize)
+	rand.New(rand.NewSource(time.Now().UnixNano())).Read(block)
+	return block
+}()
+
+type randomPayload struct {
+	remaining int64
+	offset    int
+}
+
+// NewRandomPayload returns a reader of size random bytes
+func NewRandomPayload(size int64) io.Reader {
+	return &randomPayload{
+		remaining: size,
+	}
+}
+
+func (payload *randomPayload) Read(p []byte) (int, error) {
+	if payload.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > payload.remaining {
+		p = p[:payload.remaining]
+	}
+	n := 0
+	for n < len(p) {
+		copied := copy(p[n:], randomBlock[payload.offset:])
+		payload.offset = (payload.offset + copied) % randomBlockSize
+		n += copied
+	}
+	payload.remaining -= int64(n)
+	return n, nil
+}
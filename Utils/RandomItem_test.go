@@ -0,0 +1,27 @@
+package Utils
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRandomPayload(t *testing.T) {
+	for _, size := range []int64{0, 1, randomBlockSize, 3*randomBlockSize + 7} {
+		payload, err := io.ReadAll(NewRandomPayload(size))
+		assert.Nil(t, err)
+		assert.Equal(t, size, int64(len(payload)))
+		if size > randomBlockSize {
+			assert.Equal(t, randomBlock, payload[randomBlockSize:2*randomBlockSize])
+		}
+	}
+}
+
+func TestGenerateRandomIPv6Address(t *testing.T) {
+	ip := net.ParseIP(GenerateRandomIPv6Address())
+	assert.NotNil(t, ip)
+	assert.Nil(t, ip.To4())
+	assert.True(t, ip.IsGlobalUnicast())
+}
@@ -4,66 +4,141 @@ import (
 	"HttpBenchmark/Common"
 	"HttpBenchmark/DnsQuery"
 	"HttpBenchmark/Utils"
+	"context"
 	"flag"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"math/rand"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
-	"runtime"
+	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
 func main() {
 
 	log.Debugf("start...")
-	parallelDownloads, httpBaseConfig, downloadHttpConfig, crawlerMode := parseArgs()
+	runOptions, httpBaseConfig, downloadHttpConfig, runLimit := parseArgs()
+	ctx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	go handleSignals(runLimit, cancelRequests, runOptions.GracePeriod)
 	if log.IsLevelEnabled(log.DebugLevel) {
-		value := 2
-		parallelDownloads = &value
-		log.Debugf("parallelDownloads: %v", *parallelDownloads)
+		runOptions.ParallelDownloads = 2
+		log.Debugf("parallelDownloads: %v", runOptions.ParallelDownloads)
 	}
 	var parsedLinksList []string
-	if *crawlerMode {
+	if runOptions.CrawlerMode {
 		parsedLinksList, _ = Utils.GetAndParseLinks(downloadHttpConfig.url.String())
 	} else {
 		parsedLinksList = []string{downloadHttpConfig.url.String()}
 	}
 	log.Infof("parsedLinksList: %v", parsedLinksList)
-	for {
+	if runOptions.MetricsListen != "" {
+		stopMetricsServer := startMetricsServer(runOptions.MetricsListen, downloadHttpConfig.runStats)
+		defer stopMetricsServer()
+	}
+	supervisor := NewSupervisor(downloadHttpConfig.runStats, runLimit, WithRestartPolicy(runOptions.WorkerRestart, runOptions.WorkerMaxRestarts))
+	dashboardCtx, stopDashboard := context.WithCancel(context.Background())
+	dashboardDone := make(chan struct{})
+	go func() {
+		NewDashboard(downloadHttpConfig.runStats, runLimit, runOptions.RefreshInterval, os.Stdout).Run(dashboardCtx)
+		close(dashboardDone)
+	}()
+	for !runLimit.Reached() {
 		r := rand.New(rand.NewSource(time.Now().UnixNano()))
 		parsedURL, err := url.Parse(parsedLinksList[r.Intn(len(parsedLinksList))])
 		if err != nil {
 			log.Error("Error in parsing URL")
 		}
 		//downloadHttpConfig.url = parsedURL
-		subNetIpList, getSubNetIpErr := Utils.GetIpSubnetFromEmbedFile(cidrData, *parallelDownloads)
+		subNetIpList, getSubNetIpErr := clientSubnets(runOptions.IPFamily, runOptions.ParallelDownloads)
 		if getSubNetIpErr != nil {
 			log.Errorln("Get Ip from fail")
 		}
 		for _, subNetIp := range subNetIpList {
+			if runLimit.Reached() {
+				break
+			}
 			var queryRes []*net.IP
-			for len(queryRes) == 0 {
-				queryRes = doDnsQueryWithRetry(httpBaseConfig, parsedURL.Host, subNetIp, 10)
+			for len(queryRes) == 0 && !runLimit.Reached() {
+				queryRes = doDnsQueryWithRetry(ctx, httpBaseConfig, runOptions.DNSOverrides, downloadHttpConfig.runStats, parsedURL.Host, subNetIp, runOptions.IPFamily, 10)
+			}
+			if len(queryRes) == 0 {
+				break
 			}
 			var waitGroup sync.WaitGroup
 
-			tasks := createDownloadTasks(downloadHttpConfig, queryRes, *parallelDownloads, parsedURL)
-			go calculateTotalDownloadedAndSpeed(tasks)
-			executeDownloadTasks(tasks, &waitGroup)
+			tasks := createDownloadTasks(downloadHttpConfig, queryRes, runOptions.ParallelDownloads, runOptions.LocalIPs, parsedURL)
+			executeDownloadTasks(ctx, supervisor, tasks, &waitGroup)
 
 			waitGroup.Wait()
 		}
 	}
+	stopDashboard()
+	<-dashboardDone
+	stopReason := StopReasonLimit
+	if runLimit.Stopped() {
+		stopReason = StopReasonSignal
+		log.Infof("Shutdown requested, all download tasks drained")
+	} else {
+		log.Infof("Run limit reached, all download tasks drained")
+	}
+	report := downloadHttpConfig.runStats.Report(newReportConfig(runOptions, downloadHttpConfig, runLimit), stopReason)
+	if err := writeReports(runOptions, downloadHttpConfig.runStats, report); err != nil {
+		log.Errorf("Error writing the report: %s", err)
+	}
+}
+
+// RunOptions holds the command line settings that drive the run as a whole rather than a single download
+type RunOptions struct {
+	ParallelDownloads int
+	CrawlerMode       bool
+	GracePeriod       time.Duration
+	RefreshInterval   time.Duration
+	OutputFormat      string
+	ReportFile        string
+	MetricsListen     string
+	// WorkerRestart is the restart policy of failed workers, WorkerMaxRestarts bounds the restarts of one worker
+	WorkerRestart     string
+	WorkerMaxRestarts int
+	// LocalIPs hands out the source addresses of the download workers
+	LocalIPs *LocalIPPicker
+	// IPFamily is 4, 6 or dual, it picks the DNS record types, the client subnets and the local addresses
+	IPFamily string
+	// DNSOverrides holds the dns- flags that were set, they are applied to the settings of every DNS query
+	DNSOverrides map[string]string
 }
 
-func doDnsQueryWithRetry(httpBaseConfig *Common.HttpBaseConfig, host, subNetIp string, maxAttempts int) []*net.IP {
+// dnsFlagPrefix is the prefix of the flags generated from DnsQuery.QueryDNSFlags
+const dnsFlagPrefix = "dns-"
+
+// handleSignals stops scheduling new requests on the first SIGINT/SIGTERM and cancels the in-flight ones
+// after gracePeriod, a second signal cancels them immediately
+func handleSignals(runLimit *RunLimit, cancelRequests context.CancelFunc, gracePeriod time.Duration) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	log.Warnf("Received %s, stop scheduling new requests, waiting %s for in-flight requests", sig, gracePeriod)
+	runLimit.Stop()
+	timer := time.AfterFunc(gracePeriod, func() {
+		log.Warnf("Grace period elapsed, cancelling in-flight requests")
+		cancelRequests()
+	})
+	sig = <-signals
+	log.Warnf("Received %s again, cancelling in-flight requests", sig)
+	timer.Stop()
+	cancelRequests()
+}
+
+func doDnsQueryWithRetry(ctx context.Context, httpBaseConfig *Common.HttpBaseConfig, dnsOverrides map[string]string, runStats *RunStats, host, subNetIp, family string, maxAttempts int) []*net.IP {
 	var queryRes []*net.IP
-	for i := 0; i < maxAttempts; i++ {
-		queryRes = doDnsQuery(httpBaseConfig, host, subNetIp)
+	for i := 0; i < maxAttempts && ctx.Err() == nil; i++ {
+		queryRes = doDnsQuery(ctx, httpBaseConfig, dnsOverrides, runStats, host, subNetIp, family)
 		if len(queryRes) != 0 {
 			break
 		}
@@ -71,73 +146,361 @@ func doDnsQueryWithRetry(httpBaseConfig *Common.HttpBaseConfig, host, subNetIp s
 	return queryRes
 }
 
-func doDnsQuery(httpBaseConfig *Common.HttpBaseConfig, host, subNetIp string) []*net.IP {
+func doDnsQuery(ctx context.Context, httpBaseConfig *Common.HttpBaseConfig, dnsOverrides map[string]string, runStats *RunStats, host, subNetIp, family string) []*net.IP {
 	queryDNSFlags := DnsQuery.NewQueryDNSFlags()
+	// The IP family picks the record types unless they are set explicitly
+	queryDNSFlags.Types = dnsTypes(family)
+	if err := Common.ApplyValues(queryDNSFlags, dnsFlagPrefix, dnsOverrides); err != nil {
+		log.Error("Error in DNS settings:", err)
+	}
 	queryDNSFlags.Name = host
 	queryDNSFlags.ClientSubnet = subNetIp
 	queryDNSFlags.HttpBaseConfig = *httpBaseConfig
-	queryRes, err := DnsQuery.DoDnsQuery(*queryDNSFlags)
+	queryDNSFlags.Collector = runStats.Collector()
+	runStats.SetDNSTarget(queryDNSFlags.Server, subNetIp)
+	queryRes, err := DnsQuery.DoDnsQuery(ctx, *queryDNSFlags)
 	if err != nil {
 		log.Error("Error in DoDnsQuery:", err)
+	} else {
+		runStats.AddDNSAnswer(host, queryDNSFlags.Server, subNetIp, queryRes)
 	}
-	return queryRes
+	// Explicit record types may resolve addresses of the other family, the workers could not bind to them
+	return filterIPFamily(queryRes, family)
 }
 
-func parseArgs() (*int, *Common.HttpBaseConfig, *DownloadHttpConfig, *bool) {
+func parseArgs() (*RunOptions, *Common.HttpBaseConfig, *DownloadHttpConfig, *RunLimit) {
 
 	httpBaseConfig := Common.NewHttpBaseConfig()
 	downloadHttpConfig := NewDownloadHttpConfig()
 	singleIpDownloadTimes := flag.Int("SingleIpDownloadTimes", downloadHttpConfig.SingleIpDownloadTimes, "The number of single ip download times")
 	httpMethod := flag.String("httpMethod", httpBaseConfig.HTTPMethod, "The HTTP method to use")
 	postBody := flag.String("postBody", downloadHttpConfig.PostBody, "The HTTP post body")
+	bodyFile := flag.String("bodyFile", "", "Send the content of this file as the request body")
+	bodySize := flag.Int64("bodySize", 0, "Send a random request body of this many bytes")
+	upload := flag.Bool("upload", false, "Benchmark uploads, the request body is sent with POST unless httpMethod is PUT")
+	chunked := flag.Bool("chunked", false, "Send the request body with chunked transfer encoding")
 	reuseConn := flag.Bool("reuseConn", httpBaseConfig.ReuseConn, "Whether to reuse the connection")
+	httpVersion := flag.String("http-version", HTTPVersion11, "The HTTP version of the downloads: 1.1, 2, 3 for QUIC, or auto to take what the server negotiates over ALPN")
+	streams := flag.Int("streams", 1, "The requests every worker keeps in flight at once, over HTTP/2 and HTTP/3 they are concurrent streams of one connection")
+	connMode := flag.String("connMode", "", "keepalive reuses one connection per worker, new opens a connection for every request, overrides reuseConn when set")
 	timeout := flag.Duration("timeout", httpBaseConfig.Timeout, "The timeout duration")
 	referer := flag.String("referer", downloadHttpConfig.Referer, "The HTTP referer")
 	xForwardFor := flag.String("xForwardFor", downloadHttpConfig.XForwardFor, "The X-Forwarded-For HTTP header")
 
 	crawlerMode := flag.Bool("crawlerMode", false, "Whether to use crawler mode")
 
-	httpBaseConfig.HTTPMethod = *httpMethod
-	httpBaseConfig.ReuseConn = *reuseConn
-	httpBaseConfig.Timeout = *timeout
-
-	localIP := flag.String("localIP", "", "The local IP to use")
+	localIP := flag.String("localIP", "", "The local IPs to use, a comma separated list of IPs and CIDRs where an IP@weight gets weight times the workers, like 10.0.0.2,10.0.0.3@2,10.0.1.0/28")
+	networkInterface := flag.String("interface", "", "Use the addresses of the IP family of this network interface as local IPs, like eth1")
+	ipFamilyFlag := flag.String("ip-family", IPFamily4, "Resolve and download over IPv4 (4), IPv6 (6) or both (dual)")
+	happyEyeballs := flag.Bool("happy-eyeballs", false, "Race the IPv6 and IPv4 connects to a dual-stack host as RFC 8305 describes and report which family won, needs -ip-family dual")
+	happyEyeballsDelay := flag.Duration("happy-eyeballs-delay", defaultConnectionAttemptDelay, "The head start of the IPv6 connect in a happy eyeballs race")
+	happyEyeballsMeasureBoth := flag.Bool("happy-eyeballs-measure-both", false, "Connect to both families in every happy eyeballs race instead of cancelling the loser, to report the winning margins and the success rate of both families")
 	targetUrl := flag.String("url", "", "The URL to download")
 	parallelDownloads := flag.Int("parallel", 16, "The number of parallel downloads")
+	duration := flag.Duration("duration", 0, "Stop the run after this duration, 0 means unlimited")
+	maxBytes := flag.Int64("maxBytes", 0, "Stop the run after downloading and uploading this many bytes in total, 0 means unlimited")
+	maxRequests := flag.Int64("maxRequests", 0, "Stop the run after this many requests, 0 means unlimited")
+	rate := flag.String("rate", "", "Open-loop mode: send requests at this fixed rate, like 200/s or 600/m, whether or not earlier ones have finished")
+	maxBandwidth := flag.String("max-bandwidth", "", "Cap the transfers of the whole run to this bandwidth, like 200Mbps or 25MB/s")
+	maxBandwidthPerIP := flag.String("max-bandwidth-per-ip", "", "Cap the transfers of every local IP to this bandwidth, like 50Mbps")
+	rampUp := flag.Duration("ramp-up", 0, "Raise the requests in flight linearly from 0 to the first stage, or to parallel, over this duration")
+	stages := flag.String("stages", "", "Step stages as concurrency:duration pairs, like 8:30s,16:30s,32:1m, parallel follows the highest concurrency")
+	rampDown := flag.Duration("ramp-down", 0, "Lower the requests in flight linearly to 0 over this duration at the end of the run")
+	maxAttempts := flag.Int("maxAttempts", 3, "How often a failed request is attempted, 1 disables retries")
+	retryBackoff := flag.Duration("retryBackoff", 200*time.Millisecond, "The backoff before the first retry, it doubles with every further retry")
+	retryMaxBackoff := flag.Duration("retryMaxBackoff", 5*time.Second, "The upper bound of the retry backoff")
+	retryOnStatus := flag.String("retryOnStatus", "429,502,503,504", "Comma separated HTTP status codes that are retried")
+	workerRestart := flag.String("workerRestart", RestartOnFailure, "What happens to a worker that panics or fails: on-failure restarts it, never retires it")
+	workerMaxRestarts := flag.Int("workerMaxRestarts", 3, "How often a single worker is restarted before it is retired")
+	gracePeriod := flag.Duration("gracePeriod", 10*time.Second, "How long in-flight requests may run after SIGINT/SIGTERM before being cancelled")
+	refreshInterval := flag.Duration("refresh", time.Second, "How often the live dashboard, or the status log line when stdout is not a terminal, is refreshed")
+	outputFormat := flag.String("output", OutputText, "The end-of-run report format: json, csv or text")
+	reportFile := flag.String("report-file", "", "Write the end-of-run report to this file instead of stdout")
+	metricsListen := flag.String("metrics-listen", "", "Serve Prometheus metrics on this address, like :9100, disabled when empty")
+	configFile := flag.String("config", os.Getenv(Common.EnvPrefix+"CONFIG"), "A YAML file of flag names and values, command line flags and environment variables override it")
+
+	// Every tagged field of the HTTP and DNS settings gets a flag, DNS flags are prefixed with dns-
+	queryDNSFlags := DnsQuery.NewQueryDNSFlags()
+	Common.RegisterFlags(flag.CommandLine, httpBaseConfig, "", false)
+	Common.RegisterFlags(flag.CommandLine, queryDNSFlags, dnsFlagPrefix, true)
 
 	flag.Parse()
 
-	if localIP == nil {
-		log.Fatalln("Please provide a local IP")
-	} else if !isValidLocalIP(*localIP) {
-		log.Fatalln("Please provide a valid local IP")
-	} else {
-		httpBaseConfig.LocalIP = net.ParseIP(*localIP)
-		log.Debugf("Local IP: %s", *localIP)
+	var fileValues map[string]string
+	if *configFile != "" {
+		var err error
+		fileValues, err = Common.ReadConfigFile(*configFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	setValues, err := Common.LoadConfig(flag.CommandLine, fileValues, Common.EnvTags(httpBaseConfig, "", false))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	dnsOverrides := make(map[string]string)
+	for name, value := range setValues {
+		if strings.HasPrefix(name, dnsFlagPrefix) {
+			dnsOverrides[name] = value
+		}
+	}
+
+	// The camelCase flags predate the generated ones and win when they are set
+	if _, ok := setValues["httpMethod"]; ok {
+		httpBaseConfig.HTTPMethod = *httpMethod
+	}
+	if _, ok := setValues["reuseConn"]; ok {
+		httpBaseConfig.ReuseConn = *reuseConn
+	}
+	switch *connMode {
+	case "":
+	case ConnModeKeepAlive:
+		httpBaseConfig.ReuseConn = true
+	case ConnModeNew:
+		httpBaseConfig.ReuseConn = false
+	default:
+		log.Fatalln("Please provide keepalive or new as the connection mode")
+	}
+	httpBaseConfig.Timeout = *timeout
+	httpBaseConfig.HTTPMethod = strings.ToUpper(httpBaseConfig.HTTPMethod)
+	if *upload && httpBaseConfig.HTTPMethod == http.MethodGet {
+		httpBaseConfig.HTTPMethod = http.MethodPost
+	}
+	switch httpBaseConfig.HTTPMethod {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut:
+	default:
+		log.Fatalln("Please provide GET, HEAD, POST or PUT as the HTTP method")
+	}
+	bodySources := 0
+	for _, set := range []bool{*postBody != "", *bodyFile != "", *bodySize > 0} {
+		if set {
+			bodySources++
+		}
+	}
+	if bodySources > 1 || *bodySize < 0 {
+		log.Fatalln("Please provide at most one of postBody, bodyFile and a positive bodySize")
+	}
+	if *upload && (bodySources == 0 || (httpBaseConfig.HTTPMethod != http.MethodPost && httpBaseConfig.HTTPMethod != http.MethodPut)) {
+		log.Fatalln("Please provide bodySize, bodyFile or postBody and the POST or PUT method in upload mode")
+	}
+	if *chunked && bodySources == 0 {
+		log.Fatalln("Please provide bodySize, bodyFile or postBody to send a chunked body")
+	}
+	switch *httpVersion {
+	case HTTPVersion11, HTTPVersionAuto:
+	case HTTPVersion2:
+		if !strings.HasPrefix(*targetUrl, "https://") {
+			log.Fatalln("Please provide an https URL for -http-version 2, HTTP/2 is negotiated over TLS")
+		}
+	case HTTPVersion3:
+		if !strings.HasPrefix(*targetUrl, "https://") {
+			log.Fatalln("Please provide an https URL for -http-version 3, QUIC always runs TLS")
+		}
+	default:
+		log.Fatalln("Please provide 1.1, 2, 3 or auto as the HTTP version")
+	}
+	if *streams <= 0 {
+		log.Fatalln("Please provide a positive number of streams")
+	}
+	if *bodyFile != "" {
+		if _, err := os.Stat(*bodyFile); err != nil {
+			log.Fatalf("Please provide a readable body file: %s", err)
+		}
+	}
+
+	if *localIP == "" && httpBaseConfig.LocalIP != nil {
+		*localIP = httpBaseConfig.LocalIP.String()
+	}
+
+	if *localIP == "" && *networkInterface == "" {
+		log.Fatalln("Please provide a local IP or an interface")
+	}
+	if *ipFamilyFlag != IPFamily4 && *ipFamilyFlag != IPFamily6 && *ipFamilyFlag != IPFamilyDual {
+		log.Fatalln("Please provide 4, 6 or dual as the IP family")
+	}
+	if *happyEyeballs && (*ipFamilyFlag != IPFamilyDual || *happyEyeballsDelay < 0) {
+		log.Fatalln("Please provide -ip-family dual and a non-negative happy-eyeballs-delay for the happy eyeballs race")
+	}
+	if *happyEyeballs && *httpVersion == HTTPVersion3 {
+		log.Fatalln("Please provide -http-version 1.1, 2 or auto for the happy eyeballs race, it races TCP connects")
+	}
+	var localAddresses []LocalAddress
+	if *localIP != "" {
+		systemIPs, err := SystemIPs()
+		if err != nil {
+			log.Fatalf("Error getting the local IPs: %s", err)
+		}
+		if localAddresses, err = ParseLocalIPs(*localIP, systemIPs); err != nil {
+			log.Fatalf("Please provide valid local IPs: %s", err)
+		}
+	}
+	if *networkInterface != "" {
+		interfaceAddresses, err := InterfaceLocalIPs(*networkInterface, *ipFamilyFlag)
+		if err != nil {
+			log.Fatalf("Please provide a usable interface: %s", err)
+		}
+		localAddresses = dedupLocalAddresses(append(localAddresses, interfaceAddresses...))
+	}
+	localAddresses = filterLocalAddresses(localAddresses, *ipFamilyFlag)
+	if *ipFamilyFlag != IPFamily6 && len(filterLocalAddresses(localAddresses, IPFamily4)) == 0 {
+		log.Fatalf("Please provide a local IPv4 address for the IP family %s", *ipFamilyFlag)
 	}
+	if *ipFamilyFlag != IPFamily4 && len(filterLocalAddresses(localAddresses, IPFamily6)) == 0 {
+		log.Fatalf("Please provide a local IPv6 address for the IP family %s", *ipFamilyFlag)
+	}
+	localIPs := NewLocalIPPicker(localAddresses)
+	// The DNS queries go out from the first local IP, an IPv4 one when there is one since most DoH servers are
+	// reached over IPv4, the download workers take turns
+	httpBaseConfig.LocalIP = localIPs.First(FamilyIPv4)
+	log.Debugf("Local IPs: %v", localIPs.IPs())
 	if *targetUrl == "" || *parallelDownloads <= 0 {
 		log.Fatalln("Please provide a local IP, a URL, and a positive number for parallel downloads")
 	}
+	if *duration < 0 || *maxBytes < 0 || *maxRequests < 0 || *gracePeriod < 0 {
+		log.Fatalln("Please provide non-negative values for duration, maxBytes, maxRequests and gracePeriod")
+	}
+	if *maxAttempts < 1 || *retryBackoff < 0 || *retryMaxBackoff < *retryBackoff {
+		log.Fatalln("Please provide a positive maxAttempts and a retryMaxBackoff of at least retryBackoff")
+	}
+	retryStatusCodes, err := ParseStatusCodes(*retryOnStatus)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	var requestRate float64
+	if *rate != "" {
+		if requestRate, err = ParseRate(*rate); err != nil || requestRate <= 0 {
+			log.Fatalln("Please provide a positive rate like 200/s")
+		}
+	}
+	var bandwidthLimit, bandwidthPerIP float64
+	if *maxBandwidth != "" {
+		if bandwidthLimit, err = ParseBandwidth(*maxBandwidth); err != nil || bandwidthLimit <= 0 {
+			log.Fatalln("Please provide a positive max-bandwidth like 200Mbps")
+		}
+	}
+	if *maxBandwidthPerIP != "" {
+		if bandwidthPerIP, err = ParseBandwidth(*maxBandwidthPerIP); err != nil || bandwidthPerIP <= 0 {
+			log.Fatalln("Please provide a positive max-bandwidth-per-ip like 50Mbps")
+		}
+	}
+	var loadProfile *LoadProfile
+	if *rampUp != 0 || *rampDown != 0 || *stages != "" {
+		var steps []Stage
+		if *stages != "" {
+			if steps, err = ParseStages(*stages); err != nil {
+				log.Fatalln(err)
+			}
+		}
+		if *rampUp < 0 || *rampDown < 0 {
+			log.Fatalln("Please provide non-negative values for ramp-up and ramp-down")
+		}
+		profileStages, err := BuildStages(*parallelDownloads, *duration, *rampUp, *rampDown, steps)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		loadProfile = NewLoadProfile(profileStages)
+		// The workers beyond the highest concurrency would never send a request
+		*parallelDownloads = loadProfile.MaxConcurrency()
+	}
+	if (*workerRestart != RestartOnFailure && *workerRestart != RestartNever) || *workerMaxRestarts < 0 {
+		log.Fatalln("Please provide on-failure or never as the worker restart policy and a non-negative workerMaxRestarts")
+	}
+	if *refreshInterval <= 0 {
+		log.Fatalln("Please provide a positive refresh interval")
+	}
+	if *outputFormat != OutputJSON && *outputFormat != OutputCSV && *outputFormat != OutputText {
+		log.Fatalln("Please provide json, csv or text as the output format")
+	}
 	downloadHttpConfig.url, _ = url.Parse(*targetUrl)
 	downloadHttpConfig.PostBody = *postBody
+	downloadHttpConfig.BodyFile = *bodyFile
+	downloadHttpConfig.BodySize = *bodySize
+	downloadHttpConfig.Upload = *upload
+	downloadHttpConfig.Chunked = *chunked
+	downloadHttpConfig.HTTPVersion = *httpVersion
+	downloadHttpConfig.Streams = *streams
 	downloadHttpConfig.Referer = *referer
 	downloadHttpConfig.XForwardFor = *xForwardFor
 	downloadHttpConfig.SingleIpDownloadTimes = *singleIpDownloadTimes
 
 	downloadHttpConfig.HttpBaseConfig = *httpBaseConfig
 
-	return parallelDownloads, httpBaseConfig, downloadHttpConfig, crawlerMode
+	runStats := NewRunStats()
+	runLimit := NewRunLimit(runStats, WithDuration(*duration), WithMaxBytes(*maxBytes), WithMaxRequests(*maxRequests),
+		WithLoadProfileEnd(loadProfile))
+	downloadHttpConfig.runStats = runStats
+	downloadHttpConfig.runLimit = runLimit
+	if requestRate > 0 {
+		downloadHttpConfig.schedule = NewRequestSchedule(requestRate)
+		runStats.SetSchedule(downloadHttpConfig.schedule)
+	}
+	if loadProfile != nil {
+		downloadHttpConfig.loadProfile = loadProfile
+		runStats.SetLoadProfile(loadProfile)
+	}
+	if *happyEyeballs {
+		downloadHttpConfig.happyEyeballs = NewHappyEyeballs(*happyEyeballsDelay, *happyEyeballsMeasureBoth)
+		runStats.SetHappyEyeballs(downloadHttpConfig.happyEyeballs)
+	}
+	if *httpVersion == HTTPVersion3 {
+		downloadHttpConfig.quicStats = NewQUICStats()
+		runStats.SetQUICStats(downloadHttpConfig.quicStats)
+	}
+	if bandwidthLimit > 0 || bandwidthPerIP > 0 {
+		downloadHttpConfig.bandwidthLimiter = NewBandwidthLimiter(bandwidthLimit, bandwidthPerIP)
+		runStats.SetBandwidthLimiter(downloadHttpConfig.bandwidthLimiter)
+	}
+	downloadHttpConfig.retryPolicy = NewRetryPolicy(WithMaxAttempts(*maxAttempts), WithBackoff(*retryBackoff, *retryMaxBackoff),
+		WithRetryOnStatus(retryStatusCodes))
+
+	runOptions := &RunOptions{
+		ParallelDownloads: *parallelDownloads,
+		CrawlerMode:       *crawlerMode,
+		GracePeriod:       *gracePeriod,
+		RefreshInterval:   *refreshInterval,
+		OutputFormat:      *outputFormat,
+		ReportFile:        *reportFile,
+		MetricsListen:     *metricsListen,
+		WorkerRestart:     *workerRestart,
+		WorkerMaxRestarts: *workerMaxRestarts,
+		LocalIPs:          localIPs,
+		IPFamily:          *ipFamilyFlag,
+		DNSOverrides:      dnsOverrides,
+	}
+	return runOptions, httpBaseConfig, downloadHttpConfig, runLimit
 }
 
-func createDownloadTasks(downloadHttpConfig *DownloadHttpConfig, queryRes []*net.IP, parallelDownloads int, url *url.URL) []*DownloadHttpConfig {
+func createDownloadTasks(downloadHttpConfig *DownloadHttpConfig, queryRes []*net.IP, parallelDownloads int, localIPs *LocalIPPicker, url *url.URL) []*DownloadHttpConfig {
 	tasks := make([]*DownloadHttpConfig, parallelDownloads)
+	// In a happy eyeballs run every worker races an IPv6 answer against an IPv4 one, a host without both connects plainly
+	ipv4Answers := filterIPFamily(queryRes, IPFamily4)
+	racing := downloadHttpConfig.happyEyeballs != nil && len(ipv4Answers) > 0 && len(ipv4Answers) < len(queryRes)
+	if racing {
+		queryRes = filterIPFamily(queryRes, IPFamily6)
+	}
 	queryResLen := len(queryRes)
 
 	for i := 0; i < parallelDownloads; i++ {
 		queryResponseIp := queryRes[i%queryResLen]
-		newDownloadHttpConfig := NewDownloadHttpConfig(WithReferer(downloadHttpConfig.Referer), WithRemoteIP(queryResponseIp))
-		newDownloadHttpConfig.LocalIP = downloadHttpConfig.LocalIP
+		opts := []DownloadHttpConfigOption{WithHttpBaseConfig(downloadHttpConfig.HttpBaseConfig), WithReferer(downloadHttpConfig.Referer),
+			WithRemoteIP(queryResponseIp), WithSingleIpDownloadTimes(downloadHttpConfig.SingleIpDownloadTimes), WithPostBody(downloadHttpConfig.PostBody),
+			WithBodyFile(downloadHttpConfig.BodyFile), WithBodySize(downloadHttpConfig.BodySize), WithUpload(downloadHttpConfig.Upload),
+			WithChunked(downloadHttpConfig.Chunked), WithHTTPVersion(downloadHttpConfig.HTTPVersion), WithStreams(downloadHttpConfig.Streams),
+			WithRunLimit(downloadHttpConfig.runLimit), WithRunStats(downloadHttpConfig.runStats), WithRetryPolicy(downloadHttpConfig.retryPolicy),
+			WithSchedule(downloadHttpConfig.schedule), WithBandwidthLimiter(downloadHttpConfig.bandwidthLimiter),
+			WithLoadProfile(downloadHttpConfig.loadProfile), WithHappyEyeballs(downloadHttpConfig.happyEyeballs),
+			WithQUICStats(downloadHttpConfig.quicStats)}
+		if racing {
+			raceIP := ipv4Answers[i%len(ipv4Answers)]
+			opts = append(opts, WithRaceIP(raceIP, localIPs.Next(*raceIP)))
+		}
+		newDownloadHttpConfig := NewDownloadHttpConfig(opts...)
 		newDownloadHttpConfig.url = url
+		// A worker binds to a local address of the family of its remote IP
+		newDownloadHttpConfig.LocalIP = localIPs.Next(*queryResponseIp)
+		if queryResponseIp.To4() == nil {
+			newDownloadHttpConfig.XForwardFor = Utils.GenerateRandomIPv6Address()
+		}
 		if newDownloadHttpConfig.url.Scheme == "https" {
 			newDownloadHttpConfig.RemotePort = 443
 		} else {
@@ -148,89 +511,8 @@ func createDownloadTasks(downloadHttpConfig *DownloadHttpConfig, queryRes []*net
 	return tasks
 }
 
-func executeDownloadTasks(tasks []*DownloadHttpConfig, waitGroup *sync.WaitGroup) {
-	for _, task := range tasks {
-		waitGroup.Add(1)
-		go task.DoHttpDownload(waitGroup)
-	}
-}
-
-func calculateTotalDownloadedAndSpeed(tasks []*DownloadHttpConfig) {
-	for {
-		totalDownloadSpeed := int64(0)
-		totalDownloaded := int64(0)
-		for _, task := range tasks {
-			totalDownloadSpeed += task.DownloadSpeed
-			totalDownloaded += task.TotalDownloadedBytes
-		}
-
-		// Convert totalDownloadSpeed from KB/s to Mbps
-		totalDownloadSpeedInMbps := (totalDownloadSpeed * 8) / 1024
-
-		// Clear the console
-		//clearConsole()
-
-		fmt.Printf("\rTotal download speed: %d Mbps, Total downloaded: %s", totalDownloadSpeedInMbps, Utils.FormatBytes(totalDownloaded))
-		time.Sleep(300 * time.Second)
-	}
-}
-
-func clearConsole() {
-	switch runtime.GOOS {
-	case "windows":
-		cmd := exec.Command("cmd", "/c", "cls")
-		cmd.Stdout = os.Stdout
-		err := cmd.Run()
-		if err != nil {
-			log.Error("Error in clearConsole:", err)
-		}
-	case "linux", "darwin":
-		cmd := exec.Command("clear")
-		cmd.Stdout = os.Stdout
-		err := cmd.Run()
-		if err != nil {
-			log.Error("Error in clearConsole:", err)
-		}
-	}
-}
-
-func isValidLocalIP(ipStr string) bool {
-	// Parse the input string to an IP
-	ip := net.ParseIP(ipStr)
-	if ip == nil {
-		log.Errorf("invalid IP format")
-		return false
-	}
-
-	// Get all network interfaces
-	interfaces, err := net.Interfaces()
-	if err != nil {
-		log.Error("error getting network interfaces")
-		return false
-	}
-
-	// Check each interface
-	for _, i := range interfaces {
-		addresses, err := i.Addrs()
-		if err != nil {
-			log.Error("error getting addresses")
-			return false
-		}
-		// Check each address
-		for _, addr := range addresses {
-			var ip net.IP
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			}
-
-			// Check if the parsed IP matches the current address
-			if ip.Equal(net.ParseIP(ipStr)) {
-				return true
-			}
-		}
+func executeDownloadTasks(ctx context.Context, supervisor *Supervisor, tasks []*DownloadHttpConfig, waitGroup *sync.WaitGroup) {
+	for i, task := range tasks {
+		supervisor.Go(ctx, waitGroup, fmt.Sprintf("%d (%s from %s)", i, task.RemoteIP.String(), task.LocalIP), task.DoHttpDownload)
 	}
-	return false
 }